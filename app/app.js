@@ -1,4 +1,6 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  consumeSSOTokens();
+  await consumeEmailLinks();
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -8,9 +10,107 @@ document.addEventListener('DOMContentLoaded', async () => {
   } else {
     document.getElementById('auth-section').style.display = 'block';
     document.getElementById('video-section').style.display = 'none';
+    await getSSOProviders();
   }
 });
 
+// SSO logins redirect back to the app with the tokens in the URL fragment
+function consumeSSOTokens() {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const token = params.get('token');
+  if (!token) return;
+
+  localStorage.setItem('token', token);
+  localStorage.setItem('refresh_token', params.get('refresh_token'));
+  history.replaceState(null, '', window.location.pathname);
+}
+
+// verification and password reset emails link back to the app with a token
+async function consumeEmailLinks() {
+  const params = new URLSearchParams(window.location.search);
+  const verifyToken = params.get('verify_token');
+  const resetToken = params.get('reset_token');
+  if (!verifyToken && !resetToken) return;
+
+  history.replaceState(null, '', window.location.pathname);
+  try {
+    if (verifyToken) {
+      const res = await fetch('/api/users/verify', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: verifyToken }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to verify email: ${data.error}`);
+      }
+      alert('Email verified!');
+      return;
+    }
+
+    const password = prompt('Choose a new password');
+    if (!password) return;
+    const res = await fetch('/api/password/reset', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ token: resetToken, password }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to reset password: ${data.error}`);
+    }
+    logout();
+    alert('Password reset! Please log in with your new password.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function forgotPassword() {
+  const email = document.getElementById('email').value;
+  if (!email) {
+    alert('Enter your email address first.');
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password/forgot', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ email }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to request password reset: ${data.error}`);
+    }
+    alert('If that account exists, a password reset link is on its way.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function getSSOProviders() {
+  try {
+    const res = await fetch('/api/oidc/providers');
+    if (!res.ok) return;
+
+    const providers = await res.json();
+    const ssoContainer = document.getElementById('sso-buttons');
+    ssoContainer.innerHTML = '';
+    for (const provider of providers) {
+      const button = document.createElement('button');
+      button.type = 'button';
+      button.textContent = `Login with ${provider}`;
+      button.onclick = () => {
+        window.location.href = `/api/oidc/${encodeURIComponent(provider)}/login`;
+      };
+      ssoContainer.appendChild(button);
+    }
+  } catch (error) {
+    console.error(`Failed to get SSO providers: ${error.message}`);
+  }
+}
+
 document.getElementById('video-draft-form').addEventListener('submit', async (event) => {
   event.preventDefault();
   await createVideoDraft();
@@ -104,6 +204,7 @@ async function signup() {
 
 function logout() {
   localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
 }
@@ -161,6 +262,8 @@ async function uploadVideoFile(videoID) {
 
   uploadBtnSelector = 'upload-video-btn';
   setUploadButtonState(true, uploadBtnSelector);
+  const progressStream = new AbortController();
+  watchUploadProgress(videoID, progressStream.signal);
 
   try {
     const res = await fetch(`/api/video_upload/${videoID}`, {
@@ -181,9 +284,56 @@ async function uploadVideoFile(videoID) {
     alert(`Error: ${error.message}`);
   }
 
+  progressStream.abort();
+  document.getElementById('upload-progress').style.display = 'none';
+  document.getElementById('upload-progress-label').textContent = '';
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+// follow the upload's server-sent progress events. EventSource can't send
+// the Authorization header, so the stream is read with fetch.
+async function watchUploadProgress(videoID, signal) {
+  const bar = document.getElementById('upload-progress');
+  const label = document.getElementById('upload-progress-label');
+  bar.value = 0;
+  bar.style.display = 'inline-block';
+
+  try {
+    const res = await fetch(`/api/videos/${videoID}/progress`, {
+      headers: { Authorization: `Bearer ${localStorage.getItem('token')}` },
+      signal,
+    });
+    if (!res.ok) return;
+
+    const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffered = '';
+    // results from an earlier upload are ignored until this one reports
+    let started = false;
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) return;
+      buffered += value;
+      const events = buffered.split('\n\n');
+      buffered = events.pop();
+      for (const event of events) {
+        const data = event.split('\n').find((line) => line.startsWith('data: '));
+        if (!data) continue;
+        const update = JSON.parse(data.slice(6));
+        if (update.stage === 'done' || update.stage === 'failed') {
+          if (started) return;
+          continue;
+        }
+        started = true;
+        bar.value = update.percent;
+        label.textContent = update.percent ? `${update.stage} ${Math.round(update.percent)}%` : update.stage;
+      }
+    }
+  } catch (error) {
+    // the stream is aborted once the upload request finishes
+    if (error.name !== 'AbortError') console.log(`Progress unavailable: ${error.message}`);
+  }
+}
+
 const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
@@ -241,6 +391,7 @@ async function getVideo(videoID) {
       throw new Error('Failed to get video.');
     }
 
+    currentVideoETag = res.headers.get('ETag');
     const video = await res.json();
     viewVideo(video);
   } catch (error) {
@@ -249,6 +400,7 @@ async function getVideo(videoID) {
 }
 
 let currentVideo = null;
+let currentVideoETag = '*';
 
 function viewVideo(video) {
   currentVideo = video;
@@ -264,6 +416,11 @@ function viewVideo(video) {
     thumbnailImg.src = video.thumbnail_url;
   }
 
+  document.getElementById('publish-btn').textContent = video.published_at ? 'Unpublish' : 'Publish';
+  const watchLink = document.getElementById('watch-link');
+  watchLink.style.display = video.published_at && video.visibility !== 'private' ? 'inline' : 'none';
+  watchLink.href = `/app/watch.html?v=${video.id}`;
+
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
     if (!video.video_url) {
@@ -276,6 +433,65 @@ function viewVideo(video) {
   }
 }
 
+// publishing from the app makes the video public; unpublishing returns it to draft
+async function togglePublished() {
+  if (!currentVideo) return;
+
+  const changes = currentVideo.published_at
+    ? { published: false }
+    : { published: true, visibility: 'public' };
+  try {
+    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+      method: 'PATCH',
+      headers: {
+        'Content-Type': 'application/json',
+        'If-Match': currentVideoETag,
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+      body: JSON.stringify(changes),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(data.error);
+    }
+    currentVideoETag = res.headers.get('ETag');
+    viewVideo(await res.json());
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function createShareLink() {
+  if (!currentVideo) return;
+
+  const password = prompt('Password for the link (leave empty for none)');
+  if (password === null) return;
+  const maxViews = prompt('Maximum number of views (leave empty for unlimited)');
+  if (maxViews === null) return;
+
+  const body = { expires_in_seconds: 7 * 24 * 60 * 60 };
+  if (password) body.password = password;
+  if (maxViews) body.max_views = parseInt(maxViews, 10);
+
+  try {
+    const res = await fetch(`/api/videos/${currentVideo.id}/share_links`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+      body: JSON.stringify(body),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(data.error);
+    }
+    prompt('Share link (valid for 7 days)', data.url);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 async function deleteVideo() {
   if (!currentVideo) {
     alert('No video selected for deletion.');
@@ -0,0 +1,117 @@
+// watch pages are public, so they only use the unauthenticated API
+document.addEventListener('DOMContentLoaded', async () => {
+  const params = new URLSearchParams(window.location.search);
+  const title = document.getElementById('video-title-display');
+
+  try {
+    let video;
+    if (params.get('share')) {
+      video = await getSharedVideo(params.get('share'));
+    } else if (params.get('v')) {
+      video = await getPublicVideo(params.get('v'));
+      trackPlayback(video.id);
+      showComments(video);
+    } else {
+      throw new Error('Video not found');
+    }
+    showVideo(video);
+  } catch (error) {
+    title.textContent = error.message;
+  }
+});
+
+async function getPublicVideo(videoID) {
+  const res = await fetch(`/api/public/videos/${encodeURIComponent(videoID)}`);
+  if (!res.ok) {
+    throw new Error('Video not found');
+  }
+  return res.json();
+}
+
+// share links may ask for a password, which is only sent once it's needed
+async function getSharedVideo(token, password) {
+  const res = await fetch(`/api/share/${encodeURIComponent(token)}`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(password ? { password } : {}),
+  });
+  if (res.status === 401) {
+    const entered = prompt(password ? 'Wrong password, try again' : 'This video is password protected');
+    if (!entered) {
+      throw new Error('Password required');
+    }
+    return getSharedVideo(token, entered);
+  }
+  if (!res.ok) {
+    const data = await res.json();
+    throw new Error(data.error);
+  }
+  return res.json();
+}
+
+function showVideo(video) {
+  document.title = `${video.title} - Tubely`;
+  document.getElementById('video-title-display').textContent = video.title;
+  document.getElementById('video-description-display').textContent = video.description;
+
+  const videoPlayer = document.getElementById('video-player');
+  videoPlayer.src = video.video_url;
+  if (video.thumbnail_url) {
+    videoPlayer.poster = video.thumbnail_url;
+  }
+  videoPlayer.style.display = 'block';
+}
+
+// the watch page shows the newest comments, replies are left to the API
+async function showComments(video) {
+  document.getElementById('video-stats-display').textContent =
+    `${video.like_count} likes · ${video.comment_count} comments`;
+
+  const res = await fetch(`/api/videos/${encodeURIComponent(video.id)}/comments`);
+  if (!res.ok) return;
+  const page = await res.json();
+
+  const list = document.getElementById('comments-list');
+  for (const comment of page.comments) {
+    const item = document.createElement('li');
+    item.textContent = `${comment.author_name || 'Anonymous'}: ${comment.body}`;
+    list.appendChild(item);
+  }
+}
+
+// report plays, quartiles and completion so owners get analytics
+function trackPlayback(videoID) {
+  const player = document.getElementById('video-player');
+  const sessionID = crypto.randomUUID();
+  const reported = new Set();
+  let lastReportAt = 0;
+
+  const send = (event) => {
+    const watched = Math.max(0, player.currentTime - lastReportAt);
+    lastReportAt = player.currentTime;
+    const body = JSON.stringify({ ...event, session_id: sessionID, watched_seconds: Math.min(watched, 600) });
+    navigator.sendBeacon(`/api/videos/${videoID}/events`, body);
+  };
+
+  player.addEventListener('play', () => {
+    if (reported.has('play')) return;
+    reported.add('play');
+    lastReportAt = player.currentTime;
+    send({ type: 'play' });
+  });
+  player.addEventListener('timeupdate', () => {
+    if (!player.duration) return;
+    const percent = (player.currentTime / player.duration) * 100;
+    for (const quartile of [25, 50, 75]) {
+      if (percent >= quartile && !reported.has(quartile)) {
+        reported.add(quartile);
+        send({ type: 'progress', quartile });
+      }
+    }
+  });
+  player.addEventListener('ended', () => {
+    if (reported.has('complete')) return;
+    reported.add('complete');
+    send({ type: 'complete' });
+  });
+}
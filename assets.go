@@ -4,7 +4,7 @@ import (
 	"os"
 )
 
-func (cfg apiConfig) ensureAssetsDir() error {
+func (cfg *apiConfig) ensureAssetsDir() error {
 	if _, err := os.Stat(cfg.assetsRoot); os.IsNotExist(err) {
 		return os.Mkdir(cfg.assetsRoot, 0755)
 	}
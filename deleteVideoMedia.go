@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// deleteVideoMedia removes a video's thumbnail, its caption files and its
+// reference to its video file from storage.
+// Failures are logged rather than returned: the database rows are already
+// gone, so a leftover object is orphaned but never served.
+func (cfg *apiConfig) deleteVideoMedia(ctx context.Context, video database.Video) {
+	deleteStored := func(store storage.Store, url *string) {
+		if url == nil {
+			return
+		}
+		key, ok := storage.KeyFromURL(store, *url)
+		if !ok {
+			return
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "couldn't delete video media", "key", key, "video_id", video.ID, "error", err)
+		}
+	}
+
+	deleteStored(cfg.assetStore, video.ThumbnailURL)
+	for _, caption := range video.Captions {
+		deleteStored(cfg.videoStore, &caption.URL)
+	}
+	if video.VideoURL != nil {
+		cfg.releaseVideoFile(ctx, *video.VideoURL)
+	}
+}
+
+// releaseVideoFile drops a reference to the stored video file at url. Files
+// are shared by videos uploaded with the same content, so it's only deleted
+// once nothing refers to it. Failures are logged like deleteVideoMedia's.
+func (cfg *apiConfig) releaseVideoFile(ctx context.Context, url string) {
+	key, ok := storage.KeyFromURL(cfg.videoStore, url)
+	if !ok {
+		return
+	}
+	unused, err := cfg.db.WithContext(ctx).ReleaseStoredObject(key)
+	if err != nil {
+		slog.ErrorContext(ctx, "couldn't release stored video", "key", key, "error", err)
+		return
+	}
+	if !unused {
+		return
+	}
+	if err := cfg.videoStore.Delete(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "couldn't delete video media", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/analytics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// playback events are written at least this often, or sooner once this many are waiting
+	analyticsFlushInterval = 5 * time.Second
+	analyticsMaxPending    = 1000
+
+	// maxEventWatchSeconds caps how much watch time one beacon can report
+	maxEventWatchSeconds = 600
+	defaultAnalyticsDays = 28
+	maxAnalyticsDays     = 365
+)
+
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Type           analytics.EventType `json:"type"`
+		Quartile       int                 `json:"quartile"`
+		WatchedSeconds float64             `json:"watched_seconds"`
+		// SessionID lets anonymous players be counted as one viewer
+		SessionID string `json:"session_id"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// beacons are often sent as text/plain, so the content type isn't checked
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10))
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	switch params.Type {
+	case analytics.EventPlay, analytics.EventComplete:
+	case analytics.EventProgress:
+		if params.Quartile != 25 && params.Quartile != 50 && params.Quartile != 75 {
+			respondWithError(w, http.StatusBadRequest, "Progress events need a quartile of 25, 50 or 75", nil)
+			return
+		}
+	default:
+		respondWithError(w, http.StatusBadRequest, "Event type must be play, progress or complete", nil)
+		return
+	}
+	if params.WatchedSeconds < 0 || params.WatchedSeconds > maxEventWatchSeconds {
+		respondWithError(w, http.StatusBadRequest, "watched_seconds is out of range", nil)
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !videoVisibleTo(video, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	cfg.analytics.Record(analytics.Event{
+		VideoID:        video.ID,
+		Type:           params.Type,
+		Quartile:       params.Quartile,
+		WatchedSeconds: params.WatchedSeconds,
+		ViewerHash:     cfg.viewerHash(r, viewerID, params.SessionID),
+		Time:           time.Now().UTC(),
+	})
+
+	// events are written in batches, so accepted doesn't mean recorded yet
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// viewerHash identifies a viewer for unique viewer counts: by account if
+// signed in, then by player session, then by address and browser. Only the
+// hash is stored.
+func (cfg *apiConfig) viewerHash(r *http.Request, viewerID uuid.UUID, sessionID string) string {
+	var identity string
+	switch {
+	case viewerID != uuid.Nil:
+		identity = "user:" + viewerID.String()
+	case sessionID != "":
+		identity = "session:" + sessionID
+	default:
+		identity = "client:" + cfg.clientIP(r) + "|" + r.UserAgent()
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:16])
+}
+
+type retentionPoint struct {
+	Percent  int     `json:"percent"`
+	Viewers  int     `json:"viewers"`
+	Fraction float64 `json:"fraction"`
+}
+
+type videoAnalyticsResponse struct {
+	VideoID             uuid.UUID                  `json:"video_id"`
+	From                string                     `json:"from"`
+	To                  string                     `json:"to"`
+	Views               int                        `json:"views"`
+	UniqueViewers       int                        `json:"unique_viewers"`
+	AverageWatchSeconds float64                    `json:"average_watch_seconds"`
+	Retention           []retentionPoint           `json:"retention"`
+	Daily               []database.VideoDailyStats `json:"daily"`
+}
+
+func (cfg *apiConfig) handlerVideoAnalytics(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	days := defaultAnalyticsDays
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxAnalyticsDays {
+			respondWithError(w, http.StatusBadRequest, "days must be between 1 and 365", err)
+			return
+		}
+		days = parsed
+	}
+	now := time.Now().UTC()
+	from := database.StatsDay(now.AddDate(0, 0, -(days - 1)))
+	to := database.StatsDay(now)
+
+	db := cfg.db.WithContext(r.Context())
+	daily, err := db.GetVideoDailyStats(video.ID, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get analytics", err)
+		return
+	}
+	uniqueViewers, err := db.CountVideoViewers(video.ID, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get analytics", err)
+		return
+	}
+
+	total := database.VideoDailyStats{}
+	for _, day := range daily {
+		total.Plays += day.Plays
+		total.Quartile25 += day.Quartile25
+		total.Quartile50 += day.Quartile50
+		total.Quartile75 += day.Quartile75
+		total.Completes += day.Completes
+		total.WatchSeconds += day.WatchSeconds
+	}
+
+	response := videoAnalyticsResponse{
+		VideoID:       video.ID,
+		From:          from,
+		To:            to,
+		Views:         total.Plays,
+		UniqueViewers: uniqueViewers,
+		Daily:         daily,
+	}
+	if total.Plays > 0 {
+		response.AverageWatchSeconds = total.WatchSeconds / float64(total.Plays)
+	}
+	// the retention curve is the share of plays that reached each point
+	for _, point := range []retentionPoint{
+		{Percent: 0, Viewers: total.Plays},
+		{Percent: 25, Viewers: total.Quartile25},
+		{Percent: 50, Viewers: total.Quartile50},
+		{Percent: 75, Viewers: total.Quartile75},
+		{Percent: 100, Viewers: total.Completes},
+	} {
+		if total.Plays > 0 {
+			point.Fraction = float64(point.Viewers) / float64(total.Plays)
+		}
+		response.Retention = append(response.Retention, point)
+	}
+
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/analytics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoAnalytics(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	cfg.analytics = analytics.NewRecorder(cfg.db, time.Hour, 100)
+	t.Cleanup(cfg.analytics.Close)
+	mux.HandleFunc("POST /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	mux.HandleFunc("GET /api/videos/{videoID}/analytics", cfg.handlerVideoAnalytics)
+
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video := createTestVideo(t, cfg, owner.ID, "public", database.VisibilityPublic)
+	draft := createTestVideo(t, cfg, owner.ID, "draft", database.VisibilityPrivate)
+	eventsPath := "/api/videos/" + video.ID.String() + "/events"
+
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/videos/"+draft.ID.String()+"/events", map[string]any{"type": "play"}, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("event for a draft status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, eventsPath, map[string]any{"type": "progress", "quartile": 30}, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("event with an invalid quartile status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// two anonymous sessions and one signed in viewer, one of which watches to the end
+	events := []struct {
+		body   map[string]any
+		bearer string
+	}{
+		{map[string]any{"type": "play", "session_id": "s1"}, ""},
+		{map[string]any{"type": "play", "session_id": "s2"}, ""},
+		{map[string]any{"type": "play"}, otherToken},
+		{map[string]any{"type": "progress", "quartile": 25, "watched_seconds": 30, "session_id": "s1"}, ""},
+		{map[string]any{"type": "progress", "quartile": 50, "watched_seconds": 30, "session_id": "s1"}, ""},
+		{map[string]any{"type": "progress", "quartile": 75, "watched_seconds": 30, "session_id": "s1"}, ""},
+		{map[string]any{"type": "complete", "watched_seconds": 30, "session_id": "s1"}, ""},
+		{map[string]any{"type": "progress", "quartile": 25, "watched_seconds": 30}, otherToken},
+	}
+	for _, event := range events {
+		if rec := sendJSON(t, mux, http.MethodPost, eventsPath, event.body, event.bearer); rec.Code != http.StatusAccepted {
+			t.Fatalf("event %v status = %d, want %d: %s", event.body, rec.Code, http.StatusAccepted, rec.Body.String())
+		}
+	}
+	cfg.analytics.Flush()
+
+	analyticsPath := "/api/videos/" + video.ID.String() + "/analytics"
+	if rec := sendJSON(t, mux, http.MethodGet, analyticsPath, nil, otherToken); rec.Code != http.StatusForbidden {
+		t.Errorf("other user analytics status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec := sendJSON(t, mux, http.MethodGet, analyticsPath, nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("analytics status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var stats videoAnalyticsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Views != 3 || stats.UniqueViewers != 3 {
+		t.Errorf("views = %d, unique viewers = %d, want 3 and 3", stats.Views, stats.UniqueViewers)
+	}
+	if stats.AverageWatchSeconds != 50 {
+		t.Errorf("average watch seconds = %v, want 50", stats.AverageWatchSeconds)
+	}
+	wantViewers := []int{3, 2, 1, 1, 1}
+	if len(stats.Retention) != len(wantViewers) {
+		t.Fatalf("retention = %+v", stats.Retention)
+	}
+	for i, point := range stats.Retention {
+		if point.Viewers != wantViewers[i] {
+			t.Errorf("retention at %d%% = %d viewers, want %d", point.Percent, point.Viewers, wantViewers[i])
+		}
+	}
+	if len(stats.Daily) != 1 {
+		t.Errorf("daily stats = %+v, want one day", stats.Daily)
+	}
+}
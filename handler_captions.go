@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"golang.org/x/text/language"
+)
+
+const (
+	// maxCaptionBytes caps the size of a caption file
+	maxCaptionBytes = 1 << 20
+	maxCaptionLabel = 100
+)
+
+func (cfg *apiConfig) handlerCaptionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.visibleVideoForUser(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video.Captions)
+}
+
+// handlerCaptionUpload adds or replaces a video's caption track in the
+// language named in the path. SRT files are converted to WebVTT.
+func (cfg *apiConfig) handlerCaptionUpload(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	lang, ok := captionLanguage(w, r)
+	if !ok {
+		return
+	}
+
+	// parse the request body, leaving room for the form's other fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionBytes+1<<10)
+	err := r.ParseMultipartForm(maxCaptionBytes)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Caption files can be at most %d bytes", maxCaptionBytes), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse request", err)
+		return
+	}
+	file, header, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file/headers", err)
+		return
+	}
+	defer file.Close()
+
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		label = lang
+	}
+	if utf8.RuneCountInString(label) > maxCaptionLabel || strings.ContainsAny(label, "\r\n") {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("label must be a single line of at most %d characters", maxCaptionLabel), nil)
+		return
+	}
+
+	// browsers rarely know SRT's media type, so fall back to the extension
+	format, _, _ := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if format != captions.FormatWebVTT && format != captions.FormatSRT {
+		format, ok = captions.FormatFromFilename(header.Filename)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Captions must be WebVTT or SRT", nil)
+			return
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCaptionBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read captions", err)
+		return
+	}
+	if len(data) > maxCaptionBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Caption files can be at most %d bytes", maxCaptionBytes), nil)
+		return
+	}
+	vtt, err := captions.ToWebVTT(data, format)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid captions: %v", err), err)
+		return
+	}
+
+	// a new name each time so a replaced track isn't served from a cache
+	randomBytes := make([]byte, 8)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create random string for file name", err)
+		return
+	}
+	key := fmt.Sprintf("captions/%s/%s-%s.vtt", video.ID, lang, base64.RawURLEncoding.EncodeToString(randomBytes))
+	err = cfg.videoStore.Put(r.Context(), key, bytes.NewReader(vtt), captions.FormatWebVTT)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save captions file", err)
+		return
+	}
+
+	caption := database.Caption{VideoID: video.ID, Language: lang, Label: label, URL: cfg.videoStore.URL(key)}
+	previous, err := cfg.db.WithContext(r.Context()).PutCaption(caption)
+	if err != nil {
+		cfg.deleteCaptionFile(r.Context(), caption.URL)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save captions", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "saved captions", "language", lang, "format", format)
+	if previous != nil {
+		cfg.deleteCaptionFile(r.Context(), previous.URL)
+		respondWithJSON(w, http.StatusOK, caption)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, caption)
+}
+
+func (cfg *apiConfig) handlerCaptionDelete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	lang, ok := captionLanguage(w, r)
+	if !ok {
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	caption, err := db.GetCaption(video.ID, lang)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+	if caption == nil {
+		respondWithError(w, http.StatusNotFound, "Captions not found", nil)
+		return
+	}
+	err = db.DeleteCaption(video.ID, lang)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete captions", err)
+		return
+	}
+	cfg.deleteCaptionFile(r.Context(), caption.URL)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// captionLanguage reads the BCP 47 language tag in the path in its canonical
+// form, so "en-us" and "en-US" name the same track
+func captionLanguage(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tag, err := language.Parse(r.PathValue("language"))
+	if err == nil && tag == language.Und {
+		err = errors.New("language is undetermined")
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid language tag", err)
+		return "", false
+	}
+	return tag.String(), true
+}
+
+// deleteCaptionFile removes a caption track's file. Failures are logged like
+// deleteVideoMedia's.
+func (cfg *apiConfig) deleteCaptionFile(ctx context.Context, url string) {
+	key, ok := storage.KeyFromURL(cfg.videoStore, url)
+	if !ok {
+		return
+	}
+	if err := cfg.videoStore.Delete(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "couldn't delete captions file", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const testSRT = "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:00:03,000 --> 00:00:04,000\nBye\n"
+
+// putCaptions uploads a caption file for a video in language
+func (s *testServer) putCaptions(t *testing.T, videoID, lang, token, filename, contentType, label, contents string, want int, out any) {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if label != "" {
+		form.WriteField("label", label)
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="captions"; filename="`+filename+`"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(contents))
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPut, s.URL+"/api/videos/"+videoID+"/captions/"+lang, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	s.do(t, req, token, want, out)
+}
+
+func TestCaptions(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.signup(t, "boots@example.com", "hunter2")
+	other := s.signup(t, "other@example.com", "hunter2")
+
+	var video database.Video
+	s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Boots"}, http.StatusCreated, &video)
+	videoID := video.ID.String()
+	if video.Captions == nil || len(video.Captions) != 0 {
+		t.Fatalf("expected no captions on a new video, got %#v", video.Captions)
+	}
+
+	// only the owner can add captions, in a known language and format
+	s.putCaptions(t, videoID, "en", other.Token, "en.srt", "application/octet-stream", "", testSRT, http.StatusNotFound, nil)
+	s.putCaptions(t, videoID, "not a language", owner.Token, "en.srt", "application/octet-stream", "", testSRT, http.StatusBadRequest, nil)
+	s.putCaptions(t, videoID, "en", owner.Token, "en.txt", "text/plain", "", testSRT, http.StatusBadRequest, nil)
+	s.putCaptions(t, videoID, "en", owner.Token, "en.vtt", "text/vtt", "", testSRT, http.StatusBadRequest, nil)
+	s.putCaptions(t, videoID, "en", owner.Token, "en.srt", "application/x-subrip", "", strings.Repeat("x", maxCaptionBytes+1), http.StatusRequestEntityTooLarge, nil)
+
+	// SRT is stored as WebVTT, under the canonical language tag
+	var english database.Caption
+	s.putCaptions(t, videoID, "en-us", owner.Token, "en.srt", "application/octet-stream", "English", testSRT, http.StatusCreated, &english)
+	if english.Language != "en-US" || english.Label != "English" {
+		t.Fatalf("unexpected caption %+v", english)
+	}
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\n\n00:00:03.000 --> 00:00:04.000\nBye\n"
+	if got := s.fetch(t, english.URL, http.StatusOK); string(got) != want {
+		t.Errorf("captions file =\n%s\nwant\n%s", got, want)
+	}
+
+	var french database.Caption
+	s.putCaptions(t, videoID, "fr", owner.Token, "fr.vtt", "text/vtt", "", "WEBVTT\n\n00:01.000 --> 00:02.000\nBonjour\n", http.StatusCreated, &french)
+	if french.Label != "fr" {
+		t.Errorf("expected the label to default to the language, got %q", french.Label)
+	}
+
+	// tracks are listed with the video, sorted by language
+	s.doJSON(t, http.MethodGet, "/api/videos/"+videoID, owner.Token, nil, http.StatusOK, &video)
+	if len(video.Captions) != 2 || video.Captions[0] != english || video.Captions[1] != french {
+		t.Fatalf("video captions = %+v", video.Captions)
+	}
+	var listed []database.Caption
+	s.doJSON(t, http.MethodGet, "/api/videos/"+videoID+"/captions", owner.Token, nil, http.StatusOK, &listed)
+	if len(listed) != 2 {
+		t.Errorf("listed captions = %+v", listed)
+	}
+	s.doJSON(t, http.MethodGet, "/api/videos/"+videoID+"/captions", other.Token, nil, http.StatusNotFound, nil)
+
+	// replacing a track swaps its file
+	var replaced database.Caption
+	s.putCaptions(t, videoID, "EN-US", owner.Token, "en.vtt", "text/vtt", "English (CC)", "WEBVTT\n\n00:01.000 --> 00:02.000\nHi\n", http.StatusOK, &replaced)
+	if replaced.URL == english.URL || replaced.Label != "English (CC)" {
+		t.Errorf("unexpected replacement %+v", replaced)
+	}
+	s.fetch(t, english.URL, http.StatusNotFound)
+
+	s.doJSON(t, http.MethodDelete, "/api/videos/"+videoID+"/captions/fr", other.Token, nil, http.StatusNotFound, nil)
+	s.doJSON(t, http.MethodDelete, "/api/videos/"+videoID+"/captions/fr", owner.Token, nil, http.StatusNoContent, nil)
+	s.doJSON(t, http.MethodDelete, "/api/videos/"+videoID+"/captions/fr", owner.Token, nil, http.StatusNotFound, nil)
+	s.fetch(t, french.URL, http.StatusNotFound)
+
+	// deleting the video removes the rest
+	s.doJSON(t, http.MethodDelete, "/api/videos/"+videoID, owner.Token, nil, http.StatusNoContent, nil)
+	s.fetch(t, replaced.URL, http.StatusNotFound)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxCommentLength       = 2000
+	defaultCommentPageSize = 20
+	maxCommentPageSize     = 100
+)
+
+type commentPageResponse struct {
+	Comments []database.Comment `json:"comments"`
+	// NextCursor is passed back as ?cursor= to fetch the next page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type likeResponse struct {
+	Liked     bool `json:"liked"`
+	LikeCount int  `json:"like_count"`
+}
+
+func (cfg *apiConfig) handlerVideoLike(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.visibleVideoForUser(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.db.WithContext(r.Context()).LikeVideo(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, likeResponse{Liked: true, LikeCount: count})
+}
+
+func (cfg *apiConfig) handlerVideoUnlike(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.visibleVideoForUser(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := cfg.db.WithContext(r.Context()).UnlikeVideo(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, likeResponse{Liked: false, LikeCount: count})
+}
+
+func (cfg *apiConfig) handlerCommentCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body     string     `json:"body"`
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+
+	video, userID, ok := cfg.visibleVideoForUser(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	body, ok := validCommentBody(w, params.Body)
+	if !ok {
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	if params.ParentID != nil {
+		parent, err := db.GetComment(*params.ParentID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+			return
+		}
+		if parent.ID == uuid.Nil || parent.VideoID != video.ID {
+			respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+			return
+		}
+		// threads are one level deep, so replying to a reply joins its thread
+		if parent.ParentID != nil {
+			params.ParentID = parent.ParentID
+		}
+	}
+
+	comment, err := db.CreateComment(database.CreateCommentParams{
+		VideoID:  video.ID,
+		UserID:   userID,
+		ParentID: params.ParentID,
+		Body:     body,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+func (cfg *apiConfig) handlerCommentsRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	video, err := db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !videoVisibleTo(video, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.ListCommentsParams{VideoID: video.ID, Limit: defaultCommentPageSize}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxCommentPageSize {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxCommentPageSize), err)
+			return
+		}
+		params.Limit = limit
+	}
+	// replies are listed by passing the top-level comment as parent_id
+	if raw := query.Get("parent_id"); raw != "" {
+		parentID, err := uuid.Parse(raw)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid parent_id", err)
+			return
+		}
+		params.ParentID = &parentID
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := decodeCommentCursor(raw)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.After = &cursor
+	}
+
+	comments, err := db.ListComments(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve comments", err)
+		return
+	}
+
+	response := commentPageResponse{Comments: comments}
+	if len(comments) == params.Limit {
+		last := comments[len(comments)-1]
+		response.NextCursor = encodeCommentCursor(database.CommentCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerCommentUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	comment, userID, ok := cfg.commentForUser(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this comment", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	body, ok := validCommentBody(w, params.Body)
+	if !ok {
+		return
+	}
+
+	updated, err := cfg.db.WithContext(r.Context()).UpdateCommentBody(comment.ID, body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerCommentDelete(w http.ResponseWriter, r *http.Request) {
+	comment, userID, ok := cfg.commentForUser(w, r)
+	if !ok {
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	// authors can delete their comments and owners can moderate their videos
+	if comment.UserID != userID {
+		video, err := db.GetVideo(comment.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusForbidden, "You can't delete this comment", nil)
+			return
+		}
+	}
+
+	err := db.DeleteComment(comment)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete comment", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// visibleVideoForUser authenticates the request and loads the video named in
+// the path if the user can see it, responding with an error and returning
+// false if not
+func (cfg *apiConfig) visibleVideoForUser(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil || !videoVisibleTo(video, userID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, userID, true
+}
+
+// commentForUser authenticates the request and loads the comment named in the
+// path, responding with an error and returning false if it doesn't exist
+func (cfg *apiConfig) commentForUser(w http.ResponseWriter, r *http.Request) (database.Comment, uuid.UUID, bool) {
+	commentID, err := uuid.Parse(r.PathValue("commentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment ID", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+
+	comment, err := cfg.db.WithContext(r.Context()).GetComment(commentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return database.Comment{}, uuid.Nil, false
+	}
+	if comment.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+		return database.Comment{}, uuid.Nil, false
+	}
+	return comment, userID, true
+}
+
+func validCommentBody(w http.ResponseWriter, body string) (string, bool) {
+	body = strings.TrimSpace(body)
+	if body == "" || len(body) > maxCommentLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Comments must be 1 to %d characters", maxCommentLength), nil)
+		return "", false
+	}
+	return body, true
+}
+
+// comment cursors are opaque to clients: the last comment's time and ID
+func encodeCommentCursor(cursor database.CommentCursor) string {
+	raw := fmt.Sprintf("%d:%s", cursor.CreatedAt.UnixMilli(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCommentCursor(s string) (database.CommentCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.CommentCursor{}, err
+	}
+	millis, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return database.CommentCursor{}, fmt.Errorf("malformed cursor")
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return database.CommentCursor{}, err
+	}
+	commentID, err := uuid.Parse(id)
+	if err != nil {
+		return database.CommentCursor{}, err
+	}
+	return database.CommentCursor{CreatedAt: time.UnixMilli(ms).UTC(), ID: commentID}, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func newCommentTestConfig(t *testing.T) (*apiConfig, *http.ServeMux) {
+	t.Helper()
+
+	cfg, mux := newPlaylistTestConfig(t)
+	mux.HandleFunc("PUT /api/videos/{videoID}/like", cfg.handlerVideoLike)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/like", cfg.handlerVideoUnlike)
+	mux.HandleFunc("GET /api/videos/{videoID}/comments", cfg.handlerCommentsRetrieve)
+	mux.HandleFunc("POST /api/videos/{videoID}/comments", cfg.handlerCommentCreate)
+	mux.HandleFunc("PATCH /api/comments/{commentID}", cfg.handlerCommentUpdate)
+	mux.HandleFunc("DELETE /api/comments/{commentID}", cfg.handlerCommentDelete)
+	return cfg, mux
+}
+
+func decodeComment(t *testing.T, body []byte) database.Comment {
+	t.Helper()
+
+	var comment database.Comment
+	if err := json.Unmarshal(body, &comment); err != nil {
+		t.Fatal(err)
+	}
+	return comment
+}
+
+func TestVideoLikes(t *testing.T) {
+	cfg, mux := newCommentTestConfig(t)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video := createTestVideo(t, cfg, owner.ID, "a", database.VisibilityPublic)
+	private := createTestVideo(t, cfg, owner.ID, "private", database.VisibilityPrivate)
+
+	path := "/api/videos/" + video.ID.String() + "/like"
+	for i := 0; i < 2; i++ {
+		rec := sendJSON(t, mux, http.MethodPut, path, nil, otherToken)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("like: expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var like likeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &like); err != nil {
+			t.Fatal(err)
+		}
+		if !like.Liked || like.LikeCount != 1 {
+			t.Fatalf("liking twice should count once, got %+v", like)
+		}
+	}
+
+	rec := sendJSON(t, mux, http.MethodPut, "/api/videos/"+private.ID.String()+"/like", nil, otherToken)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("liking a hidden video: expected 404, got %d", rec.Code)
+	}
+
+	rec = sendJSON(t, mux, http.MethodGet, "/api/videos", nil, token)
+	var videos []database.Video
+	if err := json.Unmarshal(rec.Body.Bytes(), &videos); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range videos {
+		if v.ID == video.ID && v.LikeCount != 1 {
+			t.Fatalf("expected like_count 1 in the video list, got %d", v.LikeCount)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		rec = sendJSON(t, mux, http.MethodDelete, path, nil, otherToken)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unlike: expected 200, got %d", rec.Code)
+		}
+	}
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LikeCount != 0 {
+		t.Fatalf("expected like_count 0 after unliking, got %d", got.LikeCount)
+	}
+}
+
+func TestComments(t *testing.T) {
+	cfg, mux := newCommentTestConfig(t)
+	owner, ownerToken, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, authorToken, _ := createTestUser(t, cfg, mux, "author@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video := createTestVideo(t, cfg, owner.ID, "a", database.VisibilityPublic)
+	commentsPath := "/api/videos/" + video.ID.String() + "/comments"
+
+	post := func(token string, body map[string]any) database.Comment {
+		t.Helper()
+		// keep created_at distinct so the expected order is deterministic
+		time.Sleep(2 * time.Millisecond)
+		rec := sendJSON(t, mux, http.MethodPost, commentsPath, body, token)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create comment: expected 201, got %d: %s", rec.Code, rec.Body)
+		}
+		return decodeComment(t, rec.Body.Bytes())
+	}
+
+	rec := sendJSON(t, mux, http.MethodPost, commentsPath, map[string]any{"body": "   "}, authorToken)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("blank comment: expected 400, got %d", rec.Code)
+	}
+
+	root := post(authorToken, map[string]any{"body": "first"})
+	reply := post(otherToken, map[string]any{"body": "reply", "parent_id": root.ID})
+	// replying to a reply stays in the same thread
+	nested := post(authorToken, map[string]any{"body": "nested", "parent_id": reply.ID})
+	if nested.ParentID == nil || *nested.ParentID != root.ID {
+		t.Fatalf("expected reply to a reply to join the root thread, got %v", nested.ParentID)
+	}
+	second := post(otherToken, map[string]any{"body": "second"})
+
+	// top-level comments page newest first
+	rec = sendJSON(t, mux, http.MethodGet, commentsPath+"?limit=1", nil, "")
+	var page commentPageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 1 || page.Comments[0].ID != second.ID || page.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	rec = sendJSON(t, mux, http.MethodGet, commentsPath+"?limit=1&cursor="+page.NextCursor, nil, "")
+	page = commentPageResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 1 || page.Comments[0].ID != root.ID || page.Comments[0].ReplyCount != 2 {
+		t.Fatalf("unexpected second page: %+v", page)
+	}
+
+	rec = sendJSON(t, mux, http.MethodGet, commentsPath+"?parent_id="+root.ID.String(), nil, "")
+	page = commentPageResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 2 || page.Comments[0].ID != reply.ID || page.Comments[1].ID != nested.ID {
+		t.Fatalf("expected replies oldest first, got %+v", page.Comments)
+	}
+
+	rec = sendJSON(t, mux, http.MethodPatch, "/api/comments/"+root.ID.String(), map[string]string{"body": "edited"}, otherToken)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("editing someone else's comment: expected 403, got %d", rec.Code)
+	}
+	rec = sendJSON(t, mux, http.MethodPatch, "/api/comments/"+root.ID.String(), map[string]string{"body": "edited"}, authorToken)
+	if rec.Code != http.StatusOK || decodeComment(t, rec.Body.Bytes()).Body != "edited" {
+		t.Fatalf("author edit: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = sendJSON(t, mux, http.MethodDelete, "/api/comments/"+second.ID.String(), nil, authorToken)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("deleting someone else's comment: expected 403, got %d", rec.Code)
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CommentCount != 4 {
+		t.Fatalf("expected comment_count 4, got %d", got.CommentCount)
+	}
+
+	// the video owner can remove a thread, taking its replies with it
+	rec = sendJSON(t, mux, http.MethodDelete, "/api/comments/"+root.ID.String(), nil, ownerToken)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("owner delete: expected 204, got %d", rec.Code)
+	}
+	got, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CommentCount != 1 {
+		t.Fatalf("expected comment_count 1 after deleting the thread, got %d", got.CommentCount)
+	}
+	if c, err := cfg.db.GetComment(nested.ID); err != nil || c.ID != uuid.Nil {
+		t.Fatalf("expected replies to be deleted, got %+v, %v", c, err)
+	}
+}
+
+func TestDeleteAccountAdjustsSocialCounts(t *testing.T) {
+	cfg, mux := newCommentTestConfig(t)
+	owner, ownerToken, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video := createTestVideo(t, cfg, owner.ID, "a", database.VisibilityPublic)
+	untouched := createTestVideo(t, cfg, owner.ID, "b", database.VisibilityPublic)
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/videos/"+untouched.ID.String()+"/comments", map[string]string{"body": "mine"}, ownerToken)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create comment: expected 201, got %d", rec.Code)
+	}
+	sendJSON(t, mux, http.MethodPut, "/api/videos/"+video.ID.String()+"/like", nil, otherToken)
+	rec = sendJSON(t, mux, http.MethodPost, "/api/videos/"+video.ID.String()+"/comments", map[string]string{"body": "hi"}, otherToken)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create comment: expected 201, got %d", rec.Code)
+	}
+
+	rec = sendJSON(t, mux, http.MethodDelete, "/api/users/me", map[string]string{"password": "hunter2"}, otherToken)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete account: expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LikeCount != 0 || got.CommentCount != 0 {
+		t.Fatalf("expected counts to drop with the account, got likes %d comments %d", got.LikeCount, got.CommentCount)
+	}
+
+	got, err = cfg.db.GetVideo(untouched.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CommentCount != 1 {
+		t.Fatalf("expected comment_count 1 on a video the account never commented on, got %d", got.CommentCount)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// readinessTimeout bounds how long the readiness checks can take together
+const readinessTimeout = 3 * time.Second
+
+type healthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks,omitempty"`
+}
+
+// handlerHealthz reports the process is up. It doesn't check dependencies, so
+// an outage elsewhere doesn't get the server restarted.
+func (cfg *apiConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// handlerReadyz reports whether the server can take traffic: the database
+// and object stores can be reached, the media tools are installed and the
+// server isn't shutting down
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if cfg.shuttingDown() {
+		respondWithJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := []healthCheck{
+		healthCheckResult("database", cfg.db.Ping(ctx)),
+		healthCheckResult("video_store", storage.Check(ctx, cfg.videoStore)),
+		healthCheckResult("asset_store", storage.Check(ctx, cfg.assetStore)),
+	}
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		_, err := exec.LookPath(tool)
+		checks = append(checks, healthCheckResult(tool, err))
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	respondWithJSON(w, code, healthResponse{Status: status, Checks: checks})
+}
+
+func healthCheckResult(name string, err error) healthCheck {
+	if err != nil {
+		return healthCheck{Name: name, Error: err.Error()}
+	}
+	return healthCheck{Name: name, OK: true}
+}
+
+// shuttingDown reports whether the server has started shutting down
+func (cfg *apiConfig) shuttingDown() bool {
+	select {
+	case <-cfg.shutdown:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestReadyz(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	cfg.shutdown = make(chan struct{})
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+	// the asset directory exists but the video one was never created
+	assets := cfg.assetStore.(storage.LocalStore)
+	if err := os.MkdirAll(assets.Root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := sendJSON(t, mux, http.MethodGet, "/healthz", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthz: expected 200, got %d", rec.Code)
+	}
+
+	rec = sendJSON(t, mux, http.MethodGet, "/readyz", nil, "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz with a missing store: expected 503, got %d", rec.Code)
+	}
+	var resp healthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	checks := map[string]healthCheck{}
+	for _, check := range resp.Checks {
+		checks[check.Name] = check
+	}
+	if !checks["database"].OK || !checks["asset_store"].OK {
+		t.Fatalf("expected the database and asset store to be ready, got %+v", resp.Checks)
+	}
+	if checks["video_store"].OK || checks["video_store"].Error == "" {
+		t.Fatalf("expected the missing video store to be reported, got %+v", checks["video_store"])
+	}
+	if _, ok := checks["ffmpeg"]; !ok {
+		t.Fatalf("expected ffmpeg to be checked, got %+v", resp.Checks)
+	}
+
+	close(cfg.shutdown)
+	rec = sendJSON(t, mux, http.MethodGet, "/readyz", nil, "")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz while shutting down: expected 503, got %d", rec.Code)
+	}
+	rec = sendJSON(t, mux, http.MethodGet, "/healthz", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("healthz while shutting down: expected 200, got %d", rec.Code)
+	}
+}
+
+func TestShutdownEndsProgressStreams(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	cfg.uploadProgress = progress.NewTracker()
+	cfg.shutdown = make(chan struct{})
+	mux.HandleFunc("GET /api/videos/{videoID}/progress", cfg.handlerVideoProgress)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	video := createTestVideo(t, cfg, owner.ID, "a", database.VisibilityPrivate)
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.RegisterOnShutdown(func() { close(cfg.shutdown) })
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/videos/"+video.ID.String()+"/progress", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("expected the open progress stream not to hold up shutdown: %v", err)
+	}
+}
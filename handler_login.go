@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -28,51 +32,98 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	db := cfg.db.WithContext(r.Context())
+	user, err := db.GetUserByEmail(params.Email)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
+	// refuse attempts while the account is locked out, answering like a
+	// wrong password so a lockout doesn't reveal the email has an account
+	locked := user.LockedUntil != nil && user.LockedUntil.After(time.Now())
 	match, err := auth.CheckPasswordHash(params.Password, user.Password)
-	if err != nil {
+	if err != nil || !match || locked {
+		if user.ID != uuid.Nil && !locked {
+			cfg.recordFailedLogin(r.Context(), user.ID)
+		}
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
-	if !match {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+
+	err = db.ResetFailedLogins(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
 		return
 	}
 
+	accessToken, refreshToken, err := cfg.issueTokens(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+const (
+	loginLockoutThreshold = 5
+	loginLockoutBase      = time.Minute
+	loginLockoutMax       = time.Hour
+)
+
+// recordFailedLogin counts a failed password and locks the account once the
+// threshold is reached, doubling the lockout for every further failure
+func (cfg *apiConfig) recordFailedLogin(ctx context.Context, userID uuid.UUID) {
+	// a client hanging up mustn't stop the failure counting
+	db := cfg.db.WithContext(context.WithoutCancel(ctx))
+	failures, err := db.IncrementFailedLogins(userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "couldn't record failed login", "user_id", userID, "error", err)
+		return
+	}
+	if failures < loginLockoutThreshold {
+		return
+	}
+
+	lockout := loginLockoutMax
+	if doublings := failures - loginLockoutThreshold; doublings < 6 {
+		lockout = min(loginLockoutBase<<doublings, loginLockoutMax)
+	}
+	err = db.LockUser(userID, time.Now().Add(lockout))
+	if err != nil {
+		slog.ErrorContext(ctx, "couldn't lock user", "user_id", userID, "error", err)
+	}
+}
+
+// issueTokens creates an access JWT and a persisted refresh token for the user
+func (cfg *apiConfig) issueTokens(ctx context.Context, userID uuid.UUID) (string, string, error) {
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
+		userID,
+		cfg.keyring,
 		time.Hour*24*30,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
+	_, err = cfg.db.WithContext(ctx).CreateRefreshToken(database.CreateRefreshTokenParams{
+		UserID:    userID,
 		Token:     refreshToken,
 		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
+		return "", "", fmt.Errorf("couldn't save refresh token: %w", err)
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-	})
+	return accessToken, refreshToken, nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+func TestLoginLocksAccountAfterRepeatedFailures(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	for i := 0; i < loginLockoutThreshold; i++ {
+		rec := sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "boots@example.com", "password": "wrong"}, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d status = %d, want %d", i, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// even the right password is refused while locked, just like an email
+	// without an account
+	rec := sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "boots@example.com", "password": "hunter2"}, "")
+	unknown := sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "nobody@example.com", "password": "hunter2"}, "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("locked login status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec.Code != unknown.Code || rec.Body.String() != unknown.Body.String() || len(rec.Header()) != len(unknown.Header()) {
+		t.Errorf("locked login = %d %v %s, unknown email = %d %v %s", rec.Code, rec.Header(), rec.Body, unknown.Code, unknown.Header(), unknown.Body)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rules, err := parseRateLimits("POST /api/login=ip:2/1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		rateLimiter: ratelimit.NewLimiter(ratelimit.NewMemoryStore()),
+		rateLimits:  rules,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /api/videos", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := cfg.rateLimitMiddleware(mux, mux)
+
+	do := func(method, path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodPost, "/api/login", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := do(http.MethodPost, "/api/login", "10.0.0.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("limited status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") != "30" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("limited headers = %v", rec.Header())
+	}
+
+	if rec := do(http.MethodPost, "/api/login", "10.0.0.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other IP status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodGet, "/api/videos", "10.0.0.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("unlimited route status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies int
+		forwardedFor   []string
+		want           string
+	}{
+		{"no proxies ignores the header", 0, []string{"203.0.113.9"}, "10.0.0.1"},
+		{"one proxy", 1, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"one proxy ignores forged entries", 1, []string{"198.51.100.1, 203.0.113.9"}, "203.0.113.9"},
+		{"two proxies", 2, []string{"198.51.100.1, 203.0.113.9", "192.0.2.7"}, "203.0.113.9"},
+		{"fewer entries than proxies", 3, []string{"203.0.113.9, 192.0.2.7"}, "203.0.113.9"},
+		{"no header", 1, nil, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{trustedProxies: tt.trustedProxies}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := cfg.clientIP(req); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateCookie ties a login's state to the browser that started it,
+	// so a callback URL from someone else's login is refused
+	oidcStateCookie = "tubely_oidc_state"
+	oidcCookiePath  = "/api/oidc/"
+)
+
+// loadOIDCProviders discovers every configured single sign-on provider
+func loadOIDCProviders(ctx context.Context, providers []config.OIDCProvider) (map[string]*oidc.Provider, error) {
+	loaded := map[string]*oidc.Provider{}
+	for _, p := range providers {
+		provider, err := oidc.NewProvider(ctx, oidc.ProviderConfig{
+			Name:         p.Name,
+			IssuerURL:    p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		loaded[p.Name] = provider
+	}
+	return loaded, nil
+}
+
+func (cfg *apiConfig) handlerOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range cfg.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	respondWithJSON(w, http.StatusOK, names)
+}
+
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	// create the single-use state, nonce and PKCE verifier for this login
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login state", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login nonce", err)
+		return
+	}
+	codeVerifier := oidc.NewCodeVerifier()
+
+	db := cfg.db.WithContext(r.Context())
+	// abandoned logins are cleared out as new ones start
+	if err := db.DeleteExpiredOIDCStates(); err != nil {
+		slog.ErrorContext(r.Context(), "couldn't delete expired login states", "error", err)
+	}
+
+	err = db.CreateOIDCState(database.CreateOIDCStateParams{
+		State:        state,
+		Provider:     provider.Name,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().UTC().Add(oidcStateTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save login state", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce, codeVerifier), http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider denied login: "+errCode, nil)
+		return
+	}
+
+	// the state must be the one this browser was given when it started the login
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login wasn't started in this browser", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// look up (and use up) the state created when the login started
+	state, err := cfg.db.WithContext(r.Context()).ConsumeOIDCState(query.Get("state"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load login state", err)
+		return
+	}
+	if state == nil || state.Provider != provider.Name || state.ExpiresAt.Before(time.Now().UTC()) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state", nil)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify identity", err)
+		return
+	}
+
+	userID, err := cfg.userForIdentity(r.Context(), provider.Name, identity)
+	if errors.Is(err, errUnverifiedEmail) {
+		respondWithError(w, http.StatusForbidden, "Identity provider email is not verified", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't link identity", err)
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.issueTokens(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	// hand the tokens to the web app in the fragment so they never reach server logs
+	fragment := url.Values{}
+	fragment.Set("token", accessToken)
+	fragment.Set("refresh_token", refreshToken)
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
+
+var errUnverifiedEmail = errors.New("identity email is not verified")
+
+// userForIdentity returns the user linked to the identity, linking an existing
+// user with the same verified email or creating a new one on first login. An
+// existing account whose email was never verified loses its password and
+// sessions when it's linked.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, providerName string, identity oidc.Identity) (uuid.UUID, error) {
+	db := cfg.db.WithContext(ctx)
+	linked, err := db.GetUserIdentity(providerName, identity.Subject)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if linked != nil {
+		return linked.UserID, nil
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return uuid.Nil, errUnverifiedEmail
+	}
+
+	user, err := db.GetUserByEmail(identity.Email)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	userID := user.ID
+	if userID != uuid.Nil && user.EmailVerifiedAt == nil {
+		// nobody proved they own the address, so whoever set the password
+		// may not be the person signing in now; drop the password and its
+		// sessions before the account is handed over
+		err = db.UpdateUserPassword(userID, "")
+		if err != nil {
+			return uuid.Nil, err
+		}
+		err = db.RevokeUserRefreshTokens(userID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if userID == uuid.Nil {
+		// SSO-only users get an empty password hash, which never matches a password login
+		created, err := db.CreateUser(database.CreateUserParams{
+			Email: identity.Email,
+		})
+		if err != nil {
+			return uuid.Nil, err
+		}
+		userID = created.ID
+	}
+
+	// the identity provider has verified the address on our behalf
+	err = db.MarkUserEmailVerified(userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = db.CreateUserIdentity(database.CreateUserIdentityParams{
+		Provider: providerName,
+		Subject:  identity.Subject,
+		UserID:   userID,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, nil
+}
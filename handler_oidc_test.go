@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+)
+
+func newOIDCTestConfig(t *testing.T) (*apiConfig, *oidctest.IdP, *http.ServeMux) {
+	t.Helper()
+
+	db := newTestDB(t)
+
+	idp, err := oidctest.NewIdP("tubely", "shh")
+	if err != nil {
+		t.Fatalf("couldn't start identity provider: %v", err)
+	}
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.ProviderConfig{
+		Name:         "corp",
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "tubely",
+		ClientSecret: "shh",
+		RedirectURL:  "http://tubely.test/api/oidc/corp/callback",
+	})
+	if err != nil {
+		t.Fatalf("couldn't configure provider: %v", err)
+	}
+
+	cfg := &apiConfig{
+		db:            db,
+		keyring:       newTestKeyring(t),
+		oidcProviders: map[string]*oidc.Provider{"corp": provider},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/oidc/{provider}/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", cfg.handlerOIDCCallback)
+
+	return cfg, idp, mux
+}
+
+// ssoLogin drives a full login through the identity provider and returns the
+// final response from the callback handler
+func ssoLogin(t *testing.T, mux *http.ServeMux) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/corp/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	loginCookies := rec.Result().Cookies()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback redirect: %v", err)
+	}
+
+	return ssoCallback(t, mux, callback.RequestURI(), loginCookies)
+}
+
+// ssoCallback sends the identity provider's redirect back to the callback
+// handler with the cookies the browser holds
+func ssoCallback(t *testing.T, mux *http.ServeMux, target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func tokenFromRedirect(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback status = %d, want %d: %s", rec.Code, http.StatusFound, rec.Body.String())
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid app redirect: %v", err)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatalf("invalid app redirect fragment: %v", err)
+	}
+	if fragment.Get("refresh_token") == "" {
+		t.Error("app redirect has no refresh token")
+	}
+	return fragment.Get("token")
+}
+
+func TestOIDCLoginLinksExistingUserByVerifiedEmail(t *testing.T) {
+	cfg, idp, mux := newOIDCTestConfig(t)
+
+	hash, err := auth.HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := cfg.db.CreateUser(database.CreateUserParams{Email: "boots@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "boots@example.com", EmailVerified: true})
+	token := tokenFromRedirect(t, ssoLogin(t, mux))
+
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		t.Fatalf("issued token is invalid: %v", err)
+	}
+	if userID != existing.ID {
+		t.Errorf("token user = %s, want existing user %s", userID, existing.ID)
+	}
+
+	// later logins resolve through the stored identity even if the email changes
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "renamed@example.com", EmailVerified: true})
+	userID, err = auth.ValidateJWT(tokenFromRedirect(t, ssoLogin(t, mux)), cfg.keyring)
+	if err != nil {
+		t.Fatalf("issued token is invalid: %v", err)
+	}
+	if userID != existing.ID {
+		t.Errorf("token user = %s, want existing user %s", userID, existing.ID)
+	}
+}
+
+func TestOIDCLoginTakesOverUnverifiedAccount(t *testing.T) {
+	cfg, idp, mux := newOIDCTestConfig(t)
+
+	// someone signs up with the victim's address first and never verifies it
+	hash, err := auth.HashPassword("attacker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	squatted, err := cfg.db.CreateUser(database.CreateUserParams{Email: "victim@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "attacker-session", UserID: squatted.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-4", Email: "victim@example.com", EmailVerified: true})
+	userID, err := auth.ValidateJWT(tokenFromRedirect(t, ssoLogin(t, mux)), cfg.keyring)
+	if err != nil {
+		t.Fatalf("issued token is invalid: %v", err)
+	}
+	if userID != squatted.ID {
+		t.Fatalf("token user = %s, want %s", userID, squatted.ID)
+	}
+
+	// the squatter's password and session no longer get in
+	user, err := cfg.db.GetUserByEmail("victim@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "" {
+		t.Error("expected the unverified account's password to be cleared")
+	}
+	session, err := cfg.db.GetUserByRefreshToken("attacker-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != nil {
+		t.Error("expected the unverified account's sessions to be revoked")
+	}
+}
+
+func TestOIDCLoginKeepsVerifiedAccountPassword(t *testing.T) {
+	cfg, idp, mux := newOIDCTestConfig(t)
+
+	hash, err := auth.HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := cfg.db.CreateUser(database.CreateUserParams{Email: "boots@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.MarkUserEmailVerified(existing.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-5", Email: "boots@example.com", EmailVerified: true})
+	tokenFromRedirect(t, ssoLogin(t, mux))
+
+	user, err := cfg.db.GetUserByEmail("boots@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, _ := auth.CheckPasswordHash("hunter2", user.Password); !match {
+		t.Error("expected a verified account to keep its password")
+	}
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	cfg, idp, mux := newOIDCTestConfig(t)
+
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-2", Email: "new@example.com", EmailVerified: true})
+	userID, err := auth.ValidateJWT(tokenFromRedirect(t, ssoLogin(t, mux)), cfg.keyring)
+	if err != nil {
+		t.Fatalf("issued token is invalid: %v", err)
+	}
+
+	user, err := cfg.db.GetUserByEmail("new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != userID {
+		t.Errorf("created user = %s, want %s", user.ID, userID)
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	_, idp, mux := newOIDCTestConfig(t)
+
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-3", Email: "boots@example.com", EmailVerified: false})
+	rec := ssoLogin(t, mux)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("callback status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestOIDCCallbackRejectsUnknownState(t *testing.T) {
+	_, _, mux := newOIDCTestConfig(t)
+
+	rec := ssoCallback(t, mux, "/api/oidc/corp/callback?state=unknown&code=x", []*http.Cookie{{Name: oidcStateCookie, Value: "unknown"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("callback status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCCallbackRejectsStateFromAnotherBrowser(t *testing.T) {
+	_, idp, mux := newOIDCTestConfig(t)
+	idp.SetIdentity(oidctest.Identity{Subject: "sub-4", Email: "boots@example.com", EmailVerified: true})
+
+	// the attacker starts a login and gets a callback URL for their own account
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/corp/login", nil))
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	res.Body.Close()
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback redirect: %v", err)
+	}
+
+	// the victim's browser follows it holding its own login's cookie, or none
+	victim := httptest.NewRecorder()
+	mux.ServeHTTP(victim, httptest.NewRequest(http.MethodGet, "/api/oidc/corp/login", nil))
+	for _, cookies := range [][]*http.Cookie{nil, victim.Result().Cookies()} {
+		rec := ssoCallback(t, mux, callback.RequestURI(), cookies)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("callback status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestOIDCLoginDeletesExpiredStates(t *testing.T) {
+	cfg, _, mux := newOIDCTestConfig(t)
+
+	for state, expiresAt := range map[string]time.Time{
+		"expired": time.Now().Add(-time.Minute),
+		"pending": time.Now().Add(time.Minute),
+	} {
+		err := cfg.db.CreateOIDCState(database.CreateOIDCStateParams{State: state, Provider: "corp", ExpiresAt: expiresAt.UTC()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/corp/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if state, err := cfg.db.ConsumeOIDCState("expired"); err != nil || state != nil {
+		t.Errorf("expired state = %+v, %v, want it deleted", state, err)
+	}
+	if state, err := cfg.db.ConsumeOIDCState("pending"); err != nil || state == nil {
+		t.Errorf("pending state = %+v, %v, want it kept", state, err)
+	}
+}
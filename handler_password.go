@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// always answer the same way so the endpoint can't be used to discover accounts
+	user, err := cfg.db.WithContext(r.Context()).GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if user.ID != uuid.Nil {
+		// sent after responding, so how long the response takes doesn't
+		// give the account away either
+		ctx := context.WithoutCancel(r.Context())
+		cfg.background.Add(1)
+		go func() {
+			defer cfg.background.Done()
+			err := cfg.sendPasswordResetEmail(ctx, user)
+			if err != nil {
+				slog.ErrorContext(ctx, "couldn't send password reset email", "user_id", user.ID, "error", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required", nil)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	token, err := db.UseUserToken(auth.HashToken(params.Token), database.UserTokenPasswordReset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check reset token", err)
+		return
+	}
+	if token == nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	err = db.UpdateUserPassword(token.UserID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// a lockout guards the old password, so the new one works straight away
+	err = db.ResetFailedLogins(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't clear failed logins", err)
+		return
+	}
+
+	// the reset link proves control of the inbox, so the address is verified too
+	err = db.MarkUserEmailVerified(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email", err)
+		return
+	}
+
+	err = db.RevokeUserRefreshTokens(token.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
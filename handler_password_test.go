@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+var emailLinkRe = regexp.MustCompile(`https?://\S+`)
+
+func newPasswordTestConfig(t *testing.T) (*apiConfig, *recordingMailer, *http.ServeMux) {
+	t.Helper()
+
+	mail := &recordingMailer{}
+	cfg := &apiConfig{
+		db:      newTestDB(t),
+		keyring: newTestKeyring(t),
+		mailer:  mail,
+		baseURL: "http://tubely.test",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/users/verify", cfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerPasswordReset)
+
+	return cfg, mail, mux
+}
+
+// emailedToken extracts the token query parameter from the link in the last email
+func emailedToken(t *testing.T, mail *recordingMailer, param string) string {
+	t.Helper()
+
+	link, err := url.Parse(emailLinkRe.FindString(mail.last().Body))
+	if err != nil {
+		t.Fatalf("invalid emailed link: %v", err)
+	}
+	token := link.Query().Get(param)
+	if token == "" {
+		t.Fatalf("emailed link %q has no %s", link, param)
+	}
+	return token
+}
+
+func TestSignupSendsVerificationEmail(t *testing.T) {
+	cfg, mail, mux := newPasswordTestConfig(t)
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/users", map[string]string{"email": "boots@example.com", "password": "hunter2"}, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("signup status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if mail.last().To != "boots@example.com" {
+		t.Fatalf("verification email sent to %q", mail.last().To)
+	}
+
+	token := emailedToken(t, mail, "verify_token")
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/users/verify", map[string]string{"token": token}, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("verify status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/users/verify", map[string]string{"token": token}, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("reused verify status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	user, err := cfg.db.GetUserByEmail("boots@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.EmailVerifiedAt == nil {
+		t.Error("user email was not marked verified")
+	}
+}
+
+func TestSignupRejectsInvalidEmail(t *testing.T) {
+	_, _, mux := newPasswordTestConfig(t)
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/users", map[string]string{"email": "not an email", "password": "hunter2"}, "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("signup status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPasswordResetRevokesSessions(t *testing.T) {
+	cfg, mail, mux := newPasswordTestConfig(t)
+
+	hash, err := auth.HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateUser(database.CreateUserParams{Email: "boots@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "boots@example.com", "password": "hunter2"}, "")
+	var login struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/password/forgot", map[string]string{"email": "boots@example.com"}, ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("forgot status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	// the email is sent after the response
+	cfg.background.Wait()
+	token := emailedToken(t, mail, "reset_token")
+
+	rec = sendJSON(t, mux, http.MethodPost, "/api/password/reset", map[string]string{"token": token, "password": "correct horse"}, "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("reset status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	rec = sendJSON(t, mux, http.MethodPost, "/api/password/reset", map[string]string{"token": token, "password": "again"}, "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("reused reset status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/refresh", nil, login.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after reset status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	rec = sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "boots@example.com", "password": "correct horse"}, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("login with new password status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestPasswordResetUnlocksAccount(t *testing.T) {
+	cfg, mail, mux := newPasswordTestConfig(t)
+
+	hash, err := auth.HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateUser(database.CreateUserParams{Email: "boots@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < loginLockoutThreshold; i++ {
+		sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "boots@example.com", "password": "wrong"}, "")
+	}
+
+	sendJSON(t, mux, http.MethodPost, "/api/password/forgot", map[string]string{"email": "boots@example.com"}, "")
+	cfg.background.Wait()
+	token := emailedToken(t, mail, "reset_token")
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/password/reset", map[string]string{"token": token, "password": "correct horse"}, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("reset status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": "boots@example.com", "password": "correct horse"}, "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("login after resetting a locked account status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestPasswordForgotUnknownEmail(t *testing.T) {
+	cfg, mail, mux := newPasswordTestConfig(t)
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/password/forgot", map[string]string{"email": "nobody@example.com"}, "")
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("forgot status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	cfg.background.Wait()
+	if len(mail.messages) != 0 {
+		t.Errorf("sent %d emails for an unknown account", len(mail.messages))
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxPlaylistVideos = 500
+
+type playlistResponse struct {
+	database.Playlist
+	Videos []database.Video `json:"videos"`
+}
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       string              `json:"title"`
+		Description string              `json:"description"`
+		Visibility  database.Visibility `json:"visibility"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// playlists are private until their owner says otherwise
+	if params.Visibility == "" {
+		params.Visibility = database.VisibilityPrivate
+	}
+	title := strings.TrimSpace(params.Title)
+	if title == "" || len(title) > maxTitleLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Title must be 1 to %d characters", maxTitleLength), nil)
+		return
+	}
+	if len(params.Description) > maxDescriptionLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength), nil)
+		return
+	}
+	if !validVisibility(params.Visibility) {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+
+	playlist, err := cfg.db.WithContext(r.Context()).CreatePlaylist(database.CreatePlaylistParams{
+		Title:       title,
+		Description: params.Description,
+		Visibility:  params.Visibility,
+		UserID:      userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlistResponse{Playlist: playlist, Videos: []database.Video{}})
+}
+
+func (cfg *apiConfig) handlerPlaylistsRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	playlists, err := cfg.db.WithContext(r.Context()).GetPlaylists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlists)
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+
+	// anyone may view playlists that aren't private
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	playlist, err := db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	// private playlists look the same as missing ones to everyone but the owner
+	if playlist.ID == uuid.Nil || !playlistVisibleTo(playlist, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+
+	videos, err := db.GetPlaylistVideos(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+
+	// a playlist never reveals videos the viewer couldn't open directly
+	visible := []database.Video{}
+	for _, video := range videos {
+		if videoVisibleTo(video, viewerID) {
+			visible = append(visible, video)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, playlistResponse{Playlist: playlist, Videos: visible})
+}
+
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	// only the fields that are present are changed
+	type parameters struct {
+		Title       *string              `json:"title"`
+		Description *string              `json:"description"`
+		Visibility  *database.Visibility `json:"visibility"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if params.Title != nil {
+		title := strings.TrimSpace(*params.Title)
+		if title == "" || len(title) > maxTitleLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Title must be 1 to %d characters", maxTitleLength), nil)
+			return
+		}
+		playlist.Title = title
+	}
+	if params.Description != nil {
+		if len(*params.Description) > maxDescriptionLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength), nil)
+			return
+		}
+		playlist.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		if !validVisibility(*params.Visibility) {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+			return
+		}
+		playlist.Visibility = *params.Visibility
+	}
+
+	updated, err := cfg.db.WithContext(r.Context()).UpdatePlaylist(playlist)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).DeletePlaylist(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPlaylistAddVideo(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	// owners can add their own videos and anyone else's they're able to see
+	video, err := db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !videoVisibleTo(video, playlist.UserID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	videos, err := db.GetPlaylistVideos(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+	if len(videos) >= maxPlaylistVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("A playlist can hold at most %d videos", maxPlaylistVideos), nil)
+		return
+	}
+
+	// without a position the video goes at the end
+	position := -1
+	if params.Position != nil {
+		position = *params.Position
+	}
+	err = db.AddPlaylistVideo(playlist.ID, video.ID, position)
+	if errors.Is(err, database.ErrVideoInPlaylist) {
+		respondWithError(w, http.StatusConflict, "Video is already in the playlist", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistRemoveVideo(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	removed, err := cfg.db.WithContext(r.Context()).RemovePlaylistVideo(playlist.ID, videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video", err)
+		return
+	}
+	if !removed {
+		respondWithError(w, http.StatusNotFound, "Video is not in the playlist", nil)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, ok := cfg.ownedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	// videos hidden from the owner keep their place at the end
+	videos, err := db.GetPlaylistVideos(playlist.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+	order := params.VideoIDs
+	for _, video := range videos {
+		if !videoVisibleTo(video, playlist.UserID) {
+			order = append(order, video.ID)
+		}
+	}
+
+	err = db.ReorderPlaylist(playlist.ID, order)
+	if errors.Is(err, database.ErrPlaylistOrderMismatch) {
+		respondWithError(w, http.StatusBadRequest, "video_ids must list every video in the playlist exactly once", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reorder playlist", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, r, playlist.ID)
+}
+
+// ownedPlaylist loads the playlist named in the path and checks the caller
+// owns it, responding with an error and returning false if not
+func (cfg *apiConfig) ownedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Playlist{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Playlist{}, false
+	}
+
+	playlist, err := cfg.db.WithContext(r.Context()).GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil || !playlistVisibleTo(playlist, userID) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return database.Playlist{}, false
+	}
+	if playlist.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+// respondWithPlaylist sends a playlist as its owner sees it
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, r *http.Request, playlistID uuid.UUID) {
+	db := cfg.db.WithContext(r.Context())
+	playlist, err := db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	videos, err := db.GetPlaylistVideos(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist videos", err)
+		return
+	}
+
+	// videos that went private since being added stay hidden, even from the owner
+	visible := []database.Video{}
+	for _, video := range videos {
+		if videoVisibleTo(video, playlist.UserID) {
+			visible = append(visible, video)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, playlistResponse{Playlist: playlist, Videos: visible})
+}
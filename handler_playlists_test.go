@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func newPlaylistTestConfig(t *testing.T) (*apiConfig, *http.ServeMux) {
+	t.Helper()
+
+	cfg, mux := newAccountTestConfig(t)
+	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsRetrieve)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistAddVideo)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistRemoveVideo)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/order", cfg.handlerPlaylistReorder)
+	return cfg, mux
+}
+
+func decodePlaylist(t *testing.T, body []byte) playlistResponse {
+	t.Helper()
+
+	var playlist playlistResponse
+	if err := json.Unmarshal(body, &playlist); err != nil {
+		t.Fatal(err)
+	}
+	return playlist
+}
+
+func playlistVideoIDs(playlist playlistResponse) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for _, video := range playlist.Videos {
+		ids = append(ids, video.ID)
+	}
+	return ids
+}
+
+// createTestVideo creates a video with the given visibility, publishing it
+// unless it's private
+func createTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID, title string, visibility database.Visibility) database.Video {
+	t.Helper()
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: title, UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.Visibility = visibility
+	if visibility != database.VisibilityPrivate {
+		// at the precision handlerVideoMetaUpdate publishes at
+		publishedAt := time.Now().UTC().Truncate(time.Millisecond)
+		video.PublishedAt = &publishedAt
+	}
+	video, err = cfg.db.UpdateVideoMetadata(video, video.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestPlaylists(t *testing.T) {
+	cfg, mux := newPlaylistTestConfig(t)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+
+	a := createTestVideo(t, cfg, owner.ID, "a", database.VisibilityPublic)
+	b := createTestVideo(t, cfg, owner.ID, "b", database.VisibilityPublic)
+	private := createTestVideo(t, cfg, owner.ID, "private", database.VisibilityPrivate)
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/playlists", map[string]string{"title": "Favourites"}, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	playlist := decodePlaylist(t, rec.Body.Bytes())
+	if playlist.Visibility != database.VisibilityPrivate {
+		t.Errorf("new playlist visibility = %q, want private", playlist.Visibility)
+	}
+	path := "/api/playlists/" + playlist.ID.String()
+
+	for _, video := range []database.Video{a, b, private} {
+		rec = sendJSON(t, mux, http.MethodPost, path+"/videos", map[string]any{"video_id": video.ID}, token)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("add video status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+	rec = sendJSON(t, mux, http.MethodPost, path+"/videos", map[string]any{"video_id": a.ID}, token)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("adding a video twice status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	// private playlists are hidden from other users
+	if rec := sendJSON(t, mux, http.MethodGet, path, nil, otherToken); rec.Code != http.StatusNotFound {
+		t.Errorf("other user GET private playlist status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = sendJSON(t, mux, http.MethodPut, path+"/order", map[string]any{"video_ids": []uuid.UUID{b.ID, a.ID}}, token)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("reorder missing a video status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = sendJSON(t, mux, http.MethodPut, path+"/order", map[string]any{"video_ids": []uuid.UUID{private.ID, b.ID, a.ID}}, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reorder status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if got := playlistVideoIDs(decodePlaylist(t, rec.Body.Bytes())); len(got) != 3 || got[0] != private.ID || got[1] != b.ID || got[2] != a.ID {
+		t.Errorf("reordered videos = %v", got)
+	}
+
+	rec = sendJSON(t, mux, http.MethodPatch, path, map[string]string{"visibility": "public"}, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := sendJSON(t, mux, http.MethodPatch, path, map[string]string{"title": "mine now"}, otherToken); rec.Code != http.StatusForbidden {
+		t.Errorf("other user PATCH status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// public playlists are visible to anyone, minus the owner's private videos
+	rec = sendJSON(t, mux, http.MethodGet, path, nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("anonymous GET status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := playlistVideoIDs(decodePlaylist(t, rec.Body.Bytes())); len(got) != 2 || got[0] != b.ID || got[1] != a.ID {
+		t.Errorf("anonymous playlist videos = %v, want [%s %s]", got, b.ID, a.ID)
+	}
+
+	rec = sendJSON(t, mux, http.MethodDelete, path+"/videos/"+b.ID.String(), nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove video status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := sendJSON(t, mux, http.MethodDelete, "/api/videos/"+private.ID.String(), nil, token); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete video status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	rec = sendJSON(t, mux, http.MethodGet, path, nil, token)
+	if got := playlistVideoIDs(decodePlaylist(t, rec.Body.Bytes())); len(got) != 1 || got[0] != a.ID {
+		t.Errorf("playlist videos after removals = %v, want [%s]", got, a.ID)
+	}
+
+	if rec := sendJSON(t, mux, http.MethodDelete, path, nil, token); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := sendJSON(t, mux, http.MethodGet, path, nil, token); rec.Code != http.StatusNotFound {
+		t.Errorf("GET deleted playlist status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPlaylistAddOtherUsersVideo(t *testing.T) {
+	cfg, mux := newPlaylistTestConfig(t)
+	_, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	other, _, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+
+	unlisted := createTestVideo(t, cfg, other.ID, "unlisted", database.VisibilityUnlisted)
+	private := createTestVideo(t, cfg, other.ID, "private", database.VisibilityPrivate)
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/playlists", map[string]string{"title": "Mix"}, token)
+	path := "/api/playlists/" + decodePlaylist(t, rec.Body.Bytes()).ID.String()
+
+	if rec := sendJSON(t, mux, http.MethodPost, path+"/videos", map[string]any{"video_id": unlisted.ID}, token); rec.Code != http.StatusOK {
+		t.Errorf("add unlisted video status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, path+"/videos", map[string]any{"video_id": private.ID}, token); rec.Code != http.StatusNotFound {
+		t.Errorf("add another user's private video status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestVideosRetrieveFilters(t *testing.T) {
+	cfg, mux := newPlaylistTestConfig(t)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	cats := createTestVideo(t, cfg, owner.ID, "cats", database.VisibilityPrivate)
+	createTestVideo(t, cfg, owner.ID, "dogs", database.VisibilityPrivate)
+
+	rec := patchVideo(t, mux, cats.ID.String(), token, "*", map[string]any{"tags": []string{"Cute"}, "category": "pets"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec := patchVideo(t, mux, cats.ID.String(), token, "*", map[string]any{"category": "cats"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("PATCH unknown category status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	for _, query := range []string{"?tag=cute", "?category=pets", "?tag=CUTE&category=pets"} {
+		rec := sendJSON(t, mux, http.MethodGet, "/api/videos"+query, nil, token)
+		var videos []database.Video
+		if err := json.NewDecoder(rec.Body).Decode(&videos); err != nil {
+			t.Fatal(err)
+		}
+		if len(videos) != 1 || videos[0].ID != cats.ID {
+			t.Errorf("GET /api/videos%s = %d videos, want only %q", query, len(videos), cats.Title)
+		}
+	}
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/videos?category=cats", nil, token); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown category filter status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPublicFeedLimit = 20
+	maxPublicFeedLimit     = 100
+)
+
+type publicFeedResponse struct {
+	Videos []database.Video `json:"videos"`
+	// NextCursor is passed back as ?cursor= to fetch the next page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) handlerPublicVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultPublicFeedLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxPublicFeedLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and 100", err)
+			return
+		}
+		limit = parsed
+	}
+
+	params := database.ListVideosParams{
+		Public:   true,
+		Tag:      strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Category: query.Get("category"),
+		Limit:    limit,
+	}
+	if params.Category != "" && !database.ValidCategory(params.Category) {
+		respondWithError(w, http.StatusBadRequest, "Unknown category", nil)
+		return
+	}
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := decodeVideoCursor(raw)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+			return
+		}
+		params.After = &cursor
+	}
+
+	videos, err := cfg.db.WithContext(r.Context()).ListVideos(params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	response := publicFeedResponse{Videos: videos}
+	if len(videos) == limit {
+		last := videos[len(videos)-1]
+		response.NextCursor = encodeVideoCursor(database.VideoCursor{PublishedAt: *last.PublishedAt, ID: last.ID})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerPublicVideoGet(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// the public API ignores credentials, so owners don't see their own drafts here
+	if video.ID == uuid.Nil || !videoPubliclyVisible(video) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// video cursors are opaque to clients like comment cursors: the last video's
+// publish time and ID
+func encodeVideoCursor(cursor database.VideoCursor) string {
+	raw := fmt.Sprintf("%d:%s", cursor.PublishedAt.UnixMilli(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeVideoCursor(s string) (database.VideoCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	millis, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return database.VideoCursor{}, fmt.Errorf("malformed cursor")
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		return database.VideoCursor{}, err
+	}
+	return database.VideoCursor{PublishedAt: time.UnixMilli(ms).UTC(), ID: videoID}, nil
+}
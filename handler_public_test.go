@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestVideoPublishing(t *testing.T) {
+	cfg, mux := newPlaylistTestConfig(t)
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/public/videos/{videoID}", cfg.handlerPublicVideoGet)
+
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Boots", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := video.ID.String()
+
+	rec := patchVideo(t, mux, id, token, "*", map[string]any{"published": true, "visibility": "unlisted"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("publishing without a video file status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// drafts are only visible to their owner
+	for name, bearer := range map[string]string{"anonymous": "", "other user": otherToken} {
+		if rec := sendJSON(t, mux, http.MethodGet, "/api/videos/"+id, nil, bearer); rec.Code != http.StatusNotFound {
+			t.Errorf("%s GET draft status = %d, want %d", name, rec.Code, http.StatusNotFound)
+		}
+	}
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/videos/"+id, nil, token); rec.Code != http.StatusOK {
+		t.Errorf("owner GET draft status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/public/videos/"+id, nil, token); rec.Code != http.StatusNotFound {
+		t.Errorf("public GET draft status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	videoURL := "http://cdn.test/video.mp4"
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	rec = patchVideo(t, mux, id, token, "*", map[string]any{"published": true, "visibility": "unlisted"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("publish status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var published database.Video
+	if err := json.NewDecoder(rec.Body).Decode(&published); err != nil {
+		t.Fatal(err)
+	}
+	if published.PublishedAt == nil {
+		t.Fatal("published video has no published_at")
+	}
+
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/videos/"+id, nil, ""); rec.Code != http.StatusOK {
+		t.Errorf("anonymous GET unlisted video status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/public/videos/"+id, nil, ""); rec.Code != http.StatusOK {
+		t.Errorf("public GET unlisted video status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// making a published video private hides it again
+	if rec := patchVideo(t, mux, id, token, "*", map[string]any{"visibility": "private"}); rec.Code != http.StatusOK {
+		t.Fatalf("PATCH status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/public/videos/"+id, nil, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("public GET private video status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPublicVideosFeed(t *testing.T) {
+	cfg, mux := newPlaylistTestConfig(t)
+	mux.HandleFunc("GET /api/public/videos", cfg.handlerPublicVideosRetrieve)
+
+	owner, _, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	first := createTestVideo(t, cfg, owner.ID, "first", database.VisibilityPublic)
+	// published_at is compared at millisecond precision
+	time.Sleep(2 * time.Millisecond)
+	second := createTestVideo(t, cfg, owner.ID, "second", database.VisibilityPublic)
+	createTestVideo(t, cfg, owner.ID, "unlisted", database.VisibilityUnlisted)
+	createTestVideo(t, cfg, owner.ID, "private", database.VisibilityPrivate)
+
+	getFeed := func(query url.Values) publicFeedResponse {
+		t.Helper()
+		rec := sendJSON(t, mux, http.MethodGet, "/api/public/videos?"+query.Encode(), nil, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("feed status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var feed publicFeedResponse
+		if err := json.NewDecoder(rec.Body).Decode(&feed); err != nil {
+			t.Fatal(err)
+		}
+		return feed
+	}
+
+	// a video published in the same millisecond as second is paged by its ID
+	tied := createTestVideo(t, cfg, owner.ID, "tied", database.VisibilityPrivate)
+	tied.Visibility, tied.PublishedAt = database.VisibilityPublic, second.PublishedAt
+	tied, err := cfg.db.UpdateVideoMetadata(tied, tied.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, older := second, tied
+	if tied.ID.String() > second.ID.String() {
+		newer, older = tied, second
+	}
+
+	var seen []uuid.UUID
+	feed := publicFeedResponse{}
+	for page := 0; page == 0 || feed.NextCursor != ""; page++ {
+		if page > 3 {
+			t.Fatal("the feed doesn't end")
+		}
+		feed = getFeed(url.Values{"limit": {"1"}, "cursor": {feed.NextCursor}})
+		for _, video := range feed.Videos {
+			seen = append(seen, video.ID)
+		}
+	}
+	want := []uuid.UUID{newer.ID, older.ID, first.ID}
+	if !slices.Equal(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+
+	if rec := sendJSON(t, mux, http.MethodGet, "/api/public/videos?cursor=nope", nil, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad cursor status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
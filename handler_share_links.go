@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	maxShareLinkTTL = 365 * 24 * time.Hour
+	// shareLinkURLTTL is how long a playback URL handed out by a share link works
+	shareLinkURLTTL = 15 * time.Minute
+)
+
+type shareLinkResponse struct {
+	database.ShareLink
+	HasPassword bool `json:"has_password"`
+}
+
+type shareLinkCreatedResponse struct {
+	shareLinkResponse
+	// Token and URL are only shown when the link is created
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+type sharePlaybackResponse struct {
+	VideoID      uuid.UUID `json:"video_id"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	VideoURL     string    `json:"video_url"`
+	// VideoURLExpiresAt is when VideoURL stops working
+	VideoURLExpiresAt time.Time          `json:"video_url_expires_at"`
+	ViewsRemaining    *int               `json:"views_remaining"`
+	Captions          []database.Caption `json:"captions"`
+}
+
+func newShareLinkResponse(link database.ShareLink) shareLinkResponse {
+	return shareLinkResponse{ShareLink: link, HasPassword: link.HasPassword()}
+}
+
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ExpiresInSeconds *int   `json:"expires_in_seconds"`
+		MaxViews         *int   `json:"max_views"`
+		Password         string `json:"password"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	linkParams := database.CreateShareLinkParams{
+		VideoID:  video.ID,
+		UserID:   video.UserID,
+		MaxViews: params.MaxViews,
+	}
+	if params.ExpiresInSeconds != nil {
+		ttl := time.Duration(*params.ExpiresInSeconds) * time.Second
+		if ttl <= 0 || ttl > maxShareLinkTTL {
+			respondWithError(w, http.StatusBadRequest, "expires_in_seconds must be between 1 and one year", nil)
+			return
+		}
+		expiresAt := time.Now().UTC().Add(ttl)
+		linkParams.ExpiresAt = &expiresAt
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		respondWithError(w, http.StatusBadRequest, "max_views must be at least 1", nil)
+		return
+	}
+	if params.Password != "" {
+		linkParams.PasswordHash, err = auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+	linkParams.TokenHash = auth.HashToken(token)
+
+	link, err := cfg.db.WithContext(r.Context()).CreateShareLink(linkParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, shareLinkCreatedResponse{
+		shareLinkResponse: newShareLinkResponse(link),
+		Token:             token,
+		URL:               cfg.baseURL + "/app/watch.html?share=" + url.QueryEscape(token),
+	})
+}
+
+func (cfg *apiConfig) handlerShareLinksRetrieve(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.WithContext(r.Context()).GetShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve share links", err)
+		return
+	}
+
+	response := []shareLinkResponse{}
+	for _, link := range links {
+		response = append(response, newShareLinkResponse(link))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+	linkID, err := uuid.Parse(r.PathValue("linkID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	link, err := db.GetShareLink(linkID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+
+	err = db.RevokeShareLink(link.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerShareLinkPlay(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	// the body is optional for links without a password
+	params := parameters{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	link, err := db.GetShareLinkByToken(auth.HashToken(r.PathValue("token")))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	if !link.Usable(time.Now().UTC()) {
+		respondWithError(w, http.StatusGone, "Share link has expired", nil)
+		return
+	}
+	if link.HasPassword() {
+		match, err := auth.CheckPasswordHash(params.Password, link.PasswordHash)
+		if err != nil || !match {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect share link password", err)
+			return
+		}
+	}
+
+	video, err := db.GetVideo(link.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video isn't available", nil)
+		return
+	}
+
+	// the view is only counted once we know the viewer can watch
+	link, ok, err := db.RecordShareLinkView(link.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusGone, "Share link has expired", nil)
+		return
+	}
+
+	// playback URLs never outlive the link itself
+	ttl := shareLinkURLTTL
+	if link.ExpiresAt != nil && time.Until(*link.ExpiresAt) < ttl {
+		ttl = time.Until(*link.ExpiresAt)
+	}
+	videoURL, err := cfg.presignVideoURL(video, ttl)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+
+	response := sharePlaybackResponse{
+		VideoID:           video.ID,
+		Title:             video.Title,
+		Description:       video.Description,
+		ThumbnailURL:      video.ThumbnailURL,
+		VideoURL:          videoURL,
+		VideoURLExpiresAt: time.Now().UTC().Add(ttl),
+		Captions:          video.Captions,
+	}
+	if link.MaxViews != nil {
+		remaining := *link.MaxViews - link.ViewCount
+		response.ViewsRemaining = &remaining
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// presignVideoURL returns a time-limited URL for a video's file. Videos kept
+// outside S3 have no signed form and are returned as stored.
+func (cfg *apiConfig) presignVideoURL(video database.Video, ttl time.Duration) (string, error) {
+	if video.VideoURL == nil {
+		return "", errors.New("video has no file")
+	}
+
+	s3Store, ok := storage.Unwrap(cfg.videoStore).(storage.S3Store)
+	if !ok {
+		return *video.VideoURL, nil
+	}
+	key, ok := storage.KeyFromURL(s3Store, *video.VideoURL)
+	if !ok {
+		return "", fmt.Errorf("video URL %q isn't in the video store", *video.VideoURL)
+	}
+	return generatePresignedURL(s3Store.Client, s3Store.Bucket, key, ttl)
+}
+
+// ownedVideo loads the video named in the path and checks the caller owns it,
+// responding with an error and returning false if not
+func (cfg *apiConfig) ownedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	video, userID, ok := cfg.visibleVideoForUser(w, r)
+	if !ok {
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
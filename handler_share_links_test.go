@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func newShareLinkTestConfig(t *testing.T) (*apiConfig, *http.ServeMux) {
+	t.Helper()
+
+	cfg, mux := newAccountTestConfig(t)
+	mux.HandleFunc("POST /api/videos/{videoID}/share_links", cfg.handlerShareLinkCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/share_links", cfg.handlerShareLinksRetrieve)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share_links/{linkID}", cfg.handlerShareLinkRevoke)
+	mux.HandleFunc("POST /api/share/{token}", cfg.handlerShareLinkPlay)
+	return cfg, mux
+}
+
+func TestShareLinks(t *testing.T) {
+	cfg, mux := newShareLinkTestConfig(t)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+
+	video := createTestVideo(t, cfg, owner.ID, "private", database.VisibilityPrivate)
+	videoURL := cfg.videoStore.URL("private.mp4")
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	linksPath := "/api/videos/" + video.ID.String() + "/share_links"
+
+	if rec := sendJSON(t, mux, http.MethodPost, linksPath, map[string]any{}, otherToken); rec.Code != http.StatusNotFound {
+		t.Errorf("other user create link status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, linksPath, map[string]any{"max_views": 0}, token); rec.Code != http.StatusBadRequest {
+		t.Errorf("create link with max_views 0 status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := sendJSON(t, mux, http.MethodPost, linksPath, map[string]any{
+		"expires_in_seconds": 3600,
+		"max_views":          2,
+		"password":           "opensesame",
+	}, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create link status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var created shareLinkCreatedResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Token == "" || !created.HasPassword || created.ExpiresAt == nil {
+		t.Fatalf("created link = %+v", created)
+	}
+	playPath := "/api/share/" + created.Token
+
+	if rec := sendJSON(t, mux, http.MethodPost, playPath, map[string]string{"password": "wrong"}, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	for i := 0; i < 2; i++ {
+		rec := sendJSON(t, mux, http.MethodPost, playPath, map[string]string{"password": "opensesame"}, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("view %d status = %d, want %d: %s", i+1, rec.Code, http.StatusOK, rec.Body.String())
+		}
+		var playback sharePlaybackResponse
+		if err := json.NewDecoder(rec.Body).Decode(&playback); err != nil {
+			t.Fatal(err)
+		}
+		if playback.VideoURL != videoURL || playback.ViewsRemaining == nil || *playback.ViewsRemaining != 1-i {
+			t.Errorf("view %d playback = %+v", i+1, playback)
+		}
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, playPath, map[string]string{"password": "opensesame"}, ""); rec.Code != http.StatusGone {
+		t.Errorf("view past max_views status = %d, want %d", rec.Code, http.StatusGone)
+	}
+
+	// failed password attempts aren't counted as views
+	rec = sendJSON(t, mux, http.MethodGet, linksPath, nil, token)
+	if strings.Contains(rec.Body.String(), auth.HashToken(created.Token)) {
+		t.Error("listed links expose the token hash")
+	}
+	var links []shareLinkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 || links[0].ViewCount != 2 || links[0].LastViewedAt == nil {
+		t.Errorf("listed links = %+v, want one link viewed twice", links)
+	}
+}
+
+func TestShareLinkRevokeAndExpiry(t *testing.T) {
+	cfg, mux := newShareLinkTestConfig(t)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	video := createTestVideo(t, cfg, owner.ID, "private", database.VisibilityPrivate)
+	videoURL := cfg.videoStore.URL("private.mp4")
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	linksPath := "/api/videos/" + video.ID.String() + "/share_links"
+
+	rec := sendJSON(t, mux, http.MethodPost, linksPath, map[string]any{}, token)
+	var created shareLinkCreatedResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/share/"+created.Token, nil, ""); rec.Code != http.StatusOK {
+		t.Fatalf("view status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if rec := sendJSON(t, mux, http.MethodDelete, linksPath+"/"+created.ID.String(), nil, token); rec.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/share/"+created.Token, nil, ""); rec.Code != http.StatusGone {
+		t.Errorf("revoked link status = %d, want %d", rec.Code, http.StatusGone)
+	}
+
+	expiredToken := "expired-token"
+	expiresAt := time.Now().UTC().Add(-time.Minute)
+	_, err := cfg.db.CreateShareLink(database.CreateShareLinkParams{
+		TokenHash: auth.HashToken(expiredToken),
+		VideoID:   video.ID,
+		UserID:    owner.ID,
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/share/"+expiredToken, nil, ""); rec.Code != http.StatusGone {
+		t.Errorf("expired link status = %d, want %d", rec.Code, http.StatusGone)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/share/unknown", nil, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown link status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
+)
+
+const (
+	// stagedUploadPath is where a local staging store accepts presigned uploads
+	stagedUploadPath = "/api/uploads/staged"
+	// stagingPrefix is where direct uploads wait in the staging store
+	stagingPrefix = "staging/"
+	// uploadURLTTL is how long the presigned URLs work for
+	uploadURLTTL = time.Hour
+	// stagedUploadTTL is how long a client has to finish a direct upload
+	stagedUploadTTL = 24 * time.Hour
+	// stagedUploadSweepInterval is how often expired uploads are cleaned up
+	stagedUploadSweepInterval = time.Hour
+	// minUploadPartSize is the part size for multipart uploads, raised when
+	// a file would need more parts than S3 allows
+	minUploadPartSize = 64 << 20
+	maxUploadParts    = 10000
+)
+
+type uploadPartURL struct {
+	PartNumber int32  `json:"part_number"`
+	URL        string `json:"url"`
+	Size       int64  `json:"size"`
+}
+
+type uploadURLResponse struct {
+	// Method, URL and Headers are set for a single request upload
+	Method  string            `json:"method"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers"`
+	// Parts are set for a multipart upload, each sent with Method and Headers
+	PartSize  int64           `json:"part_size,omitempty"`
+	Parts     []uploadPartURL `json:"parts,omitempty"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// handlerVideoUploadURL lets the owner of a video upload its file straight to
+// the staging store, to be processed by handlerVideoUploadComplete
+func (cfg *apiConfig) handlerVideoUploadURL(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Size        int64  `json:"size"`
+		ContentType string `json:"content_type"`
+		Multipart   bool   `json:"multipart"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.ContentType != "video/mp4" {
+		respondWithError(w, http.StatusBadRequest, "Invalid content type", nil)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "size must be positive", nil)
+		return
+	}
+	if params.Size > cfg.directUploadMaxBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Videos can be at most %d bytes", cfg.directUploadMaxBytes), nil)
+		return
+	}
+
+	// check the store can take the upload before recording it
+	store := storage.Unwrap(cfg.stagingStore)
+	presigner, canPresign := store.(storage.Presigner)
+	multipart, canMultipart := store.(storage.MultipartPresigner)
+	if params.Multipart && !canMultipart {
+		respondWithError(w, http.StatusBadRequest, "The video store doesn't support multipart uploads", nil)
+		return
+	}
+	if !params.Multipart && !canPresign {
+		respondWithError(w, http.StatusBadRequest, "The video store doesn't support direct uploads", nil)
+		return
+	}
+
+	// each upload gets its own key so a stale URL can't overwrite a new one
+	randomBytes := make([]byte, 16)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create random string for file name", err)
+		return
+	}
+	key := fmt.Sprintf("%s%s/%s.mp4", stagingPrefix, video.ID, base64.RawURLEncoding.EncodeToString(randomBytes))
+
+	now := time.Now().UTC()
+	staged := database.StagedUpload{
+		VideoID:     video.ID,
+		Key:         key,
+		ContentType: params.ContentType,
+		Size:        params.Size,
+		ExpiresAt:   now.Add(stagedUploadTTL),
+	}
+	response := uploadURLResponse{ExpiresAt: now.Add(uploadURLTTL)}
+
+	if params.Multipart {
+		staged.UploadID, err = multipart.CreateMultipartUpload(r.Context(), key, params.ContentType)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start multipart upload", err)
+			return
+		}
+		response.PartSize = uploadPartSize(params.Size)
+		for offset, number := int64(0), int32(1); offset < params.Size; offset, number = offset+response.PartSize, number+1 {
+			size := min(response.PartSize, params.Size-offset)
+			req, err := multipart.PresignUploadPart(r.Context(), key, staged.UploadID, number, size, uploadURLTTL)
+			if err != nil {
+				cfg.discardStagedUpload(r.Context(), staged)
+				respondWithError(w, http.StatusInternalServerError, "Couldn't sign upload part", err)
+				return
+			}
+			response.Method, response.Headers = req.Method, flattenHeader(req.Header)
+			response.Parts = append(response.Parts, uploadPartURL{PartNumber: number, URL: req.URL, Size: size})
+		}
+	} else {
+		req, err := presigner.PresignPut(r.Context(), key, params.ContentType, params.Size, uploadURLTTL)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign upload", err)
+			return
+		}
+		response.Method, response.URL, response.Headers = req.Method, req.URL, flattenHeader(req.Header)
+	}
+
+	// a video has one upload in flight, so a new one replaces the last
+	previous, err := cfg.db.WithContext(r.Context()).PutStagedUpload(staged)
+	if err != nil {
+		cfg.discardStagedUpload(r.Context(), staged)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload", err)
+		return
+	}
+	if previous != nil {
+		cfg.discardStagedUpload(r.Context(), *previous)
+	}
+
+	slog.InfoContext(r.Context(), "signed direct upload", "key", key, "size", params.Size, "multipart", params.Multipart)
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerVideoUploadComplete processes a video's direct upload like one sent
+// to handlerUploadVideo
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type part struct {
+		PartNumber int32  `json:"part_number"`
+		ETag       string `json:"etag"`
+	}
+	type parameters struct {
+		Parts []part `json:"parts"`
+	}
+
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	// the body is only needed for multipart uploads
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	staged, err := db.GetStagedUpload(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve upload", err)
+		return
+	}
+	if staged == nil {
+		respondWithError(w, http.StatusNotFound, "No upload in progress for this video", nil)
+		return
+	}
+	if time.Now().After(staged.ExpiresAt) {
+		cfg.discardStagedUpload(r.Context(), *staged)
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "completing direct upload", "key", staged.Key)
+	report := cfg.reportUpload(r.Context(), video.ID)
+	defer report.failIfUnfinished()
+
+	if staged.UploadID != "" {
+		if len(params.Parts) == 0 {
+			respondWithError(w, http.StatusBadRequest, "parts must be given for a multipart upload", nil)
+			return
+		}
+		multipart, ok := storage.Unwrap(cfg.stagingStore).(storage.MultipartPresigner)
+		if !ok {
+			respondWithError(w, http.StatusInternalServerError, "The video store doesn't support multipart uploads", nil)
+			return
+		}
+		parts := make([]storage.CompletedPart, 0, len(params.Parts))
+		for _, p := range params.Parts {
+			parts = append(parts, storage.CompletedPart{PartNumber: p.PartNumber, ETag: p.ETag})
+		}
+		err = multipart.CompleteMultipartUpload(r.Context(), staged.Key, staged.UploadID, parts)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't complete multipart upload", err)
+			return
+		}
+		// the parts are now one object, which is all that's left to clean up
+		staged.UploadID = ""
+	}
+
+	// bring the staged file here to be processed, hashing it on the way
+	body, err := storage.Open(r.Context(), cfg.stagingStore, staged.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "Video hasn't been uploaded", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open uploaded video", err)
+		return
+	}
+	defer body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	_, span := tracer.Start(r.Context(), "copy to temp file")
+	hasher := sha256.New()
+	received := progress.NewReader(io.LimitReader(body, staged.Size+1), func(n int64) {
+		report.publish(progress.Update{Stage: progress.StageReceiving, BytesDone: n, BytesTotal: staged.Size, Percent: percentOf(n, staged.Size)})
+	})
+	n, err := io.Copy(io.MultiWriter(tempFile, hasher), received)
+	endSpan(span, err)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save video file", err)
+		return
+	}
+	if n != staged.Size {
+		cfg.discardStagedUpload(r.Context(), *staged)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Uploaded video isn't the declared %d bytes", staged.Size), nil)
+		return
+	}
+	sourceSHA256 := hex.EncodeToString(hasher.Sum(nil))
+
+	video, ok = cfg.saveUploadedVideo(w, r, video, tempFile.Name(), sourceSHA256, staged.ContentType, report.publish)
+	if !ok {
+		return
+	}
+	cfg.discardStagedUpload(r.Context(), *staged)
+	cfg.notifyWebhooks(r.Context(), video.UserID, webhooks.EventVideoProcessed, video)
+	report.done()
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// handlerStagedUpload receives uploads presigned by a local staging store,
+// standing in for S3 when videos are kept locally
+func (cfg *apiConfig) handlerStagedUpload(w http.ResponseWriter, r *http.Request) {
+	store, ok := storage.Unwrap(cfg.stagingStore).(storage.LocalStore)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Not found", nil)
+		return
+	}
+
+	key := r.PathValue("key")
+	if !strings.HasPrefix(key, stagingPrefix) {
+		respondWithError(w, http.StatusForbidden, "Invalid upload signature", nil)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	size, err := store.VerifyPut(key, contentType, r.URL.Query(), time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Invalid upload signature", err)
+		return
+	}
+	if r.ContentLength != size {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Content-Length must be %d", size), nil)
+		return
+	}
+
+	err = cfg.stagingStore.Put(r.Context(), key, http.MaxBytesReader(w, r.Body, size), contentType)
+	if err != nil {
+		cfg.stagingStore.Delete(r.Context(), key)
+		respondWithError(w, http.StatusBadRequest, "Couldn't save upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// discardStagedUpload removes a direct upload's file and record. Failures
+// are logged, and the record is kept when its file couldn't be removed so
+// sweepStagedUploads tries again.
+func (cfg *apiConfig) discardStagedUpload(ctx context.Context, staged database.StagedUpload) {
+	var err error
+	if staged.UploadID != "" {
+		if multipart, ok := storage.Unwrap(cfg.stagingStore).(storage.MultipartPresigner); ok {
+			err = multipart.AbortMultipartUpload(ctx, staged.Key, staged.UploadID)
+		}
+	}
+	// the parts may have been completed into an object before processing failed
+	if err == nil {
+		err = cfg.stagingStore.Delete(ctx, staged.Key)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "couldn't delete staged upload", "key", staged.Key, "error", err)
+		return
+	}
+
+	// only forget the upload if it hasn't been replaced
+	db := cfg.db.WithContext(ctx)
+	current, err := db.GetStagedUpload(staged.VideoID)
+	if err == nil && current != nil && current.Key == staged.Key {
+		err = db.DeleteStagedUpload(staged.VideoID)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "couldn't delete staged upload record", "video_id", staged.VideoID, "error", err)
+	}
+}
+
+// sweepStagedUploads discards direct uploads that were never completed,
+// including those whose video was deleted
+func (cfg *apiConfig) sweepStagedUploads(ctx context.Context) {
+	expired, err := cfg.db.WithContext(ctx).ListExpiredStagedUploads(time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "couldn't list expired staged uploads", "error", err)
+		return
+	}
+	for _, staged := range expired {
+		cfg.discardStagedUpload(ctx, staged)
+	}
+	if len(expired) > 0 {
+		slog.InfoContext(ctx, "discarded expired staged uploads", "count", len(expired))
+	}
+}
+
+// sweepStagedUploadsEvery runs sweepStagedUploads every interval with ctx
+// until the server shuts down
+func (cfg *apiConfig) sweepStagedUploadsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cfg.sweepStagedUploads(ctx)
+		case <-cfg.shutdown:
+			return
+		}
+	}
+}
+
+// uploadPartSize picks a part size that fits size in S3's part limit
+func uploadPartSize(size int64) int64 {
+	partSize := int64(minUploadPartSize)
+	if needed := (size + maxUploadParts - 1) / maxUploadParts; needed > partSize {
+		partSize = needed
+	}
+	return partSize
+}
+
+// flattenHeader gives the headers a client must send as a JSON object
+func flattenHeader(header http.Header) map[string]string {
+	flat := make(map[string]string, len(header))
+	for name := range header {
+		flat[name] = header.Get(name)
+	}
+	return flat
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media/mediatest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// putUpload sends contents to a presigned upload URL with its headers
+func (s *testServer) putUpload(t *testing.T, signed uploadURLResponse, url string, contents []byte, want int) {
+	t.Helper()
+
+	req, err := http.NewRequest(signed.Method, url, bytes.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range signed.Headers {
+		req.Header.Set(name, value)
+	}
+	s.do(t, req, "", want, nil)
+}
+
+// stagedFileExists reports whether the file a presigned upload URL writes to
+// is in the local staging store
+func (s *testServer) stagedFileExists(t *testing.T, signed uploadURLResponse) bool {
+	t.Helper()
+
+	key := strings.TrimPrefix(strings.Split(signed.URL, "?")[0], s.URL+stagedUploadPath+"/")
+	_, err := os.Stat(filepath.Join(s.cfg.stagingStore.(storage.LocalStore).Root, key))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestDirectUpload(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.signup(t, "boots@example.com", "hunter2")
+	other := s.signup(t, "other@example.com", "hunter2")
+
+	var video database.Video
+	s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Boots"}, http.StatusCreated, &video)
+	videoPath := "/api/videos/" + video.ID.String()
+	contents := []byte("mp4 bytes")
+	params := map[string]any{"size": len(contents), "content_type": "video/mp4"}
+
+	// only the owner can upload, and only an mp4 under the size limit
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", other.Token, params, http.StatusNotFound, nil)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", owner.Token, map[string]any{"size": 10, "content_type": "text/plain"}, http.StatusBadRequest, nil)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", owner.Token, map[string]any{"size": 2 << 20, "content_type": "video/mp4"}, http.StatusRequestEntityTooLarge, nil)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", owner.Token, map[string]any{"size": 10, "content_type": "video/mp4", "multipart": true}, http.StatusBadRequest, nil)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", owner.Token, nil, http.StatusNotFound, nil)
+
+	var signed uploadURLResponse
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", owner.Token, params, http.StatusOK, &signed)
+	if signed.Method != http.MethodPut || !strings.HasPrefix(signed.URL, s.URL+stagedUploadPath+"/staging/"+video.ID.String()+"/") {
+		t.Fatalf("unexpected upload URL: %+v", signed)
+	}
+
+	// the signature covers the body's size and type and the key
+	s.putUpload(t, signed, signed.URL, []byte("too many bytes"), http.StatusBadRequest)
+	s.putUpload(t, uploadURLResponse{Method: http.MethodPut, Headers: map[string]string{"Content-Type": "video/webm"}}, signed.URL, contents, http.StatusForbidden)
+	s.putUpload(t, signed, strings.Replace(signed.URL, "/staging/", "/staging/x", 1), contents, http.StatusForbidden)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", owner.Token, nil, http.StatusBadRequest, nil)
+
+	s.putUpload(t, signed, signed.URL, contents, http.StatusOK)
+
+	// staged files aren't kept with the served videos
+	stagedAsset := strings.Replace(strings.Split(signed.URL, "?")[0], stagedUploadPath, "/assets/videos", 1)
+	s.fetch(t, stagedAsset, http.StatusNotFound)
+	if !s.stagedFileExists(t, signed) {
+		t.Fatal("expected the upload to be staged")
+	}
+
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", other.Token, nil, http.StatusNotFound, nil)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", owner.Token, nil, http.StatusOK, &video)
+	if video.VideoURL == nil || !strings.Contains(*video.VideoURL, "/assets/videos/landscape/") {
+		t.Fatalf("expected the upload to be processed like any other, got %+v", video)
+	}
+	if got := s.fetch(t, *video.VideoURL, http.StatusOK); string(got) != "mp4 bytes" {
+		t.Errorf("video = %q", got)
+	}
+
+	// the staged file is cleaned up, so the upload can't be completed twice
+	if s.stagedFileExists(t, signed) {
+		t.Error("expected the staged file to be deleted")
+	}
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", owner.Token, nil, http.StatusNotFound, nil)
+
+	// the same file uploaded directly is deduplicated with the first
+	var second database.Video
+	s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Again"}, http.StatusCreated, &second)
+	s.doJSON(t, http.MethodPost, "/api/videos/"+second.ID.String()+"/upload-url", owner.Token, params, http.StatusOK, &signed)
+	s.putUpload(t, signed, signed.URL, contents, http.StatusOK)
+	s.doJSON(t, http.MethodPost, "/api/videos/"+second.ID.String()+"/upload-complete", owner.Token, nil, http.StatusOK, &second)
+	if second.VideoURL == nil || *second.VideoURL != *video.VideoURL {
+		t.Errorf("expected the videos to share a file, got %v and %v", deref(second.VideoURL), deref(video.VideoURL))
+	}
+	remuxes := 0
+	for _, call := range s.media.Calls() {
+		if call.Op == mediatest.OpRemux {
+			remuxes++
+		}
+	}
+	if remuxes != 1 {
+		t.Errorf("expected one remux, got %d", remuxes)
+	}
+}
+
+func TestDirectUploadReplacesStagedUpload(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.signup(t, "boots@example.com", "hunter2")
+
+	var video database.Video
+	s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Boots"}, http.StatusCreated, &video)
+	videoPath := "/api/videos/" + video.ID.String()
+	params := map[string]any{"size": 5, "content_type": "video/mp4"}
+
+	var first, second uploadURLResponse
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", owner.Token, params, http.StatusOK, &first)
+	s.putUpload(t, first, first.URL, []byte("first"), http.StatusOK)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-url", owner.Token, params, http.StatusOK, &second)
+
+	// asking again drops the first upload and only the new one is processed
+	if s.stagedFileExists(t, first) {
+		t.Error("expected the first staged file to be deleted")
+	}
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", owner.Token, nil, http.StatusBadRequest, nil)
+	s.putUpload(t, second, second.URL, []byte("again"), http.StatusOK)
+	s.doJSON(t, http.MethodPost, videoPath+"/upload-complete", owner.Token, nil, http.StatusOK, &video)
+	if got := s.fetch(t, *video.VideoURL, http.StatusOK); string(got) != "again" {
+		t.Errorf("video = %q", got)
+	}
+}
+
+func TestSweepStagedUploads(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.signup(t, "boots@example.com", "hunter2")
+	params := map[string]any{"size": 5, "content_type": "video/mp4"}
+
+	var deleted, expired, pending database.Video
+	var deletedUpload, expiredUpload, pendingUpload uploadURLResponse
+	for _, upload := range []struct {
+		video  *database.Video
+		signed *uploadURLResponse
+	}{{&deleted, &deletedUpload}, {&expired, &expiredUpload}, {&pending, &pendingUpload}} {
+		s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Boots"}, http.StatusCreated, upload.video)
+		s.doJSON(t, http.MethodPost, "/api/videos/"+upload.video.ID.String()+"/upload-url", owner.Token, params, http.StatusOK, upload.signed)
+		s.putUpload(t, *upload.signed, upload.signed.URL, []byte("video"), http.StatusOK)
+	}
+
+	// deleting a video leaves its staged file for the sweep
+	s.doJSON(t, http.MethodDelete, "/api/videos/"+deleted.ID.String(), owner.Token, nil, http.StatusNoContent, nil)
+	if !s.stagedFileExists(t, deletedUpload) {
+		t.Fatal("expected the staged file to wait for the sweep")
+	}
+	staged, err := s.cfg.db.GetStagedUpload(expired.ID)
+	if err != nil || staged == nil {
+		t.Fatalf("GetStagedUpload = %v, %v", staged, err)
+	}
+	staged.ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err := s.cfg.db.PutStagedUpload(*staged); err != nil {
+		t.Fatal(err)
+	}
+
+	s.cfg.sweepStagedUploads(context.Background())
+	if s.stagedFileExists(t, deletedUpload) || s.stagedFileExists(t, expiredUpload) {
+		t.Error("expected expired staged files to be deleted")
+	}
+	if !s.stagedFileExists(t, pendingUpload) {
+		t.Error("expected an upload in progress to be kept")
+	}
+	if left, err := s.cfg.db.ListExpiredStagedUploads(time.Now().Add(stagedUploadTTL)); err != nil || len(left) != 1 || left[0].VideoID != pending.ID {
+		t.Errorf("staged uploads left = %+v, %v", left, err)
+	}
+}
+
+func TestUploadPartSize(t *testing.T) {
+	if got := uploadPartSize(100); got != minUploadPartSize {
+		t.Errorf("uploadPartSize(100) = %d", got)
+	}
+	size := int64(maxUploadParts)*minUploadPartSize + 1
+	if got := uploadPartSize(size); got*maxUploadParts < size {
+		t.Errorf("uploadPartSize(%d) = %d needs more than %d parts", size, got, maxUploadParts)
+	}
+}
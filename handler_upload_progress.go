@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// progressHeartbeat keeps idle progress streams from being closed by proxies
+const progressHeartbeat = 15 * time.Second
+
+// handlerVideoProgress streams a video's upload progress as server-sent
+// events. The latest update is sent on connect, so a client can open the
+// stream before or after starting the upload. The stream ends once an upload
+// that was running while connected finishes, or when the server shuts down
+// so it doesn't hold up the shutdown.
+func (cfg *apiConfig) handlerVideoProgress(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.ownedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	changed, stop := cfg.uploadProgress.Watch(video.ID)
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	// stop nginx buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(progressHeartbeat)
+	defer heartbeat.Stop()
+
+	_, connectedSeq, _ := cfg.uploadProgress.Latest(video.ID)
+	var sentSeq uint64
+	for {
+		update, seq, ok := cfg.uploadProgress.Latest(video.ID)
+		if ok && seq != sentSeq {
+			dat, err := json.Marshal(update)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: progress\nid: %d\ndata: %s\n\n", seq, dat)
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			sentSeq = seq
+			// a finished upload from before we connected is only reported
+			if update.Stage.Finished() && seq != connectedSeq {
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-cfg.shutdown:
+			return
+		}
+	}
+}
+
+// percentOf returns done as a percentage of total, or 0 if the total isn't known
+func percentOf(done, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return min(float64(done)/float64(total)*100, 100)
+}
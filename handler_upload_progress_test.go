@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+)
+
+func TestVideoProgressStream(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	cfg.uploadProgress = progress.NewTracker()
+	mux.HandleFunc("GET /api/videos/{videoID}/progress", cfg.handlerVideoProgress)
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video := createTestVideo(t, cfg, owner.ID, "a", database.VisibilityPrivate)
+	path := "/api/videos/" + video.ID.String() + "/progress"
+
+	rec := sendJSON(t, mux, http.MethodGet, path, nil, otherToken)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("another user's progress: expected 404, got %d", rec.Code)
+	}
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// an earlier upload's result is reported but doesn't end the stream
+	cfg.uploadProgress.Publish(video.ID, progress.Update{Stage: progress.StageDone, Percent: 100})
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	events := make(chan progress.Update)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var update progress.Update
+			if err := json.Unmarshal([]byte(data), &update); err == nil {
+				events <- update
+			}
+		}
+	}()
+	next := func() (progress.Update, bool) {
+		t.Helper()
+		select {
+		case update, ok := <-events:
+			return update, ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a progress event")
+		}
+		return progress.Update{}, false
+	}
+
+	if update, _ := next(); update.Stage != progress.StageDone {
+		t.Fatalf("expected the earlier upload's result first, got %+v", update)
+	}
+
+	cfg.uploadProgress.Publish(video.ID, progress.Update{Stage: progress.StageReceiving, BytesDone: 50, BytesTotal: 100, Percent: 50})
+	if update, _ := next(); update.Stage != progress.StageReceiving || update.BytesDone != 50 {
+		t.Fatalf("expected a receiving update, got %+v", update)
+	}
+
+	cfg.uploadProgress.Publish(video.ID, progress.Update{Stage: progress.StageDone, Percent: 100})
+	if update, _ := next(); update.Stage != progress.StageDone {
+		t.Fatalf("expected the upload to finish, got %+v", update)
+	}
+	if _, ok := next(); ok {
+		t.Fatal("expected the stream to end once the upload finished")
+	}
+}
@@ -6,14 +6,13 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
 	"github.com/google/uuid"
 )
 
@@ -33,14 +32,14 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
 	// log that we are starting the upload
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	slog.InfoContext(r.Context(), "uploading thumbnail")
 
 	// set a limit on the size of the upload
 	const maxMemory = 10 << 20
@@ -73,7 +72,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 
 	// retrieve video record from the database
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if errors.Is(err, sql.ErrNoRows) {
 		respondWithError(w, http.StatusNotFound, "Video not found", err)
 		return
@@ -107,31 +106,21 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
 
-	// create the file
+	// store the file with the other assets
 	filename := fmt.Sprintf("%s.%s", randomString, ext)
-	filePath := filepath.Join(cfg.assetsRoot, filename)
-	fileptr, err := os.Create(filePath)
+	err = cfg.assetStore.Put(r.Context(), filename, file, contentType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create thumbnail file", err)
-		return
-	}
-	defer fileptr.Close()
-
-	// copy the data to the file
-	_, err = io.Copy(fileptr, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't copy thumbnail file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail file", err)
 		return
 	}
 
 	// update video record with the thumbnail url
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
-	video.ThumbnailURL = &thumbnailURL
-	err = cfg.db.UpdateVideo(video)
+	video, err = cfg.db.WithContext(r.Context()).UpdateVideoThumbnail(video.ID, cfg.assetStore.URL(filename))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	cfg.notifyWebhooks(r.Context(), video.UserID, webhooks.EventVideoThumbnail, video)
 
 	// send a response cuz we done
 	respondWithJSON(w, http.StatusOK, video)
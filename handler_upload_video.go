@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"os"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediatool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
 	"github.com/google/uuid"
 )
 
@@ -37,14 +42,14 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
 	// retrieve video record from the database
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if errors.Is(err, sql.ErrNoRows) {
 		respondWithError(w, http.StatusNotFound, "Video not found", err)
 		return
@@ -61,10 +66,24 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// log that we are starting the upload
-	fmt.Println("uploading video", videoID, "by user", userID)
+	slog.InfoContext(r.Context(), "uploading video")
+
+	// report each stage to anyone following the upload, and a failure if we
+	// return before the end
+	report := cfg.reportUpload(r.Context(), videoID)
+	defer report.failIfUnfinished()
+	publish := report.publish
+
+	// count the body as it's read so the client can see it arriving
+	bodyTotal := r.ContentLength
+	r.Body = io.NopCloser(progress.NewReader(r.Body, func(n int64) {
+		publish(progress.Update{Stage: progress.StageReceiving, BytesDone: n, BytesTotal: bodyTotal, Percent: percentOf(n, bodyTotal)})
+	}))
 
 	// parse the request body
+	_, span := tracer.Start(r.Context(), "parse multipart form")
 	err = r.ParseMultipartForm(maxMemory)
+	endSpan(span, err)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Couldn't parse request", err)
 		return
@@ -99,77 +118,231 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
-	// copy from multipart file to temporary file
-	io.Copy(tempFile, multipartFile)
-	tempFile.Seek(0, io.SeekStart)
+	// copy from multipart file to temporary file, hashing it on the way
+	_, span = tracer.Start(r.Context(), "copy to temp file")
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tempFile, hasher), multipartFile)
+	endSpan(span, err)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save video file", err)
+		return
+	}
+	sourceSHA256 := hex.EncodeToString(hasher.Sum(nil))
+
+	video, ok := cfg.saveUploadedVideo(w, r, video, tempFile.Name(), sourceSHA256, contentType, publish)
+	if !ok {
+		return
+	}
+	cfg.notifyWebhooks(r.Context(), video.UserID, webhooks.EventVideoProcessed, video)
+	report.done()
+
+	// success response
+	respondWithJSON(w, http.StatusOK, video)
+
+}
+
+// uploadReport reports each stage of a video upload to anyone following it
+// and in the logs
+type uploadReport struct {
+	ctx      context.Context
+	tracker  *progress.Tracker
+	videoID  uuid.UUID
+	stage    progress.Stage
+	finished bool
+}
 
-	// derive 'folder' from aspect ratio
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
+func (cfg *apiConfig) reportUpload(ctx context.Context, videoID uuid.UUID) *uploadReport {
+	return &uploadReport{ctx: ctx, tracker: cfg.uploadProgress, videoID: videoID}
+}
+
+func (u *uploadReport) publish(update progress.Update) {
+	if update.Stage != u.stage {
+		u.stage = update.Stage
+		setLogStage(u.ctx, string(u.stage))
+		slog.DebugContext(u.ctx, "upload stage started")
+	}
+	u.tracker.Publish(u.videoID, update)
+}
+
+// done reports the upload finished
+func (u *uploadReport) done() {
+	u.finished = true
+	u.publish(progress.Update{Stage: progress.StageDone, Percent: 100})
+}
+
+// failIfUnfinished reports a failure unless the upload finished. Defer it
+// so every early return is covered.
+func (u *uploadReport) failIfUnfinished() {
+	if !u.finished {
+		u.publish(progress.Update{Stage: progress.StageFailed})
+	}
+}
+
+// saveUploadedVideo stores the upload at path, hashed as sourceSHA256, and
+// saves it on the video, letting go of the file it replaces. Identical
+// uploads share one stored file, so a repeat skips processing. It responds
+// with an error and returns false on failure.
+func (cfg *apiConfig) saveUploadedVideo(w http.ResponseWriter, r *http.Request, video database.Video, path, sourceSHA256, contentType string, publish func(progress.Update)) (database.Video, bool) {
+	db := cfg.db.WithContext(r.Context())
+	object, err := db.GetStoredObjectBySource(sourceSHA256)
 	if err != nil {
-		log.Printf("Failed to obtain aspect ratio: %s", err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up stored video", err)
+		return database.Video{}, false
+	}
+	reused := false
+	if object != nil {
+		reused, err = db.RetainStoredObject(object.Key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't reuse stored video", err)
+			return database.Video{}, false
+		}
 	}
-	var folder string
-	switch aspectRatio {
-	case "16:9":
-		folder = "landscape/"
-	case "9:16":
-		folder = "portrait/"
-	default:
-		folder = "other/"
+	if reused {
+		slog.DebugContext(r.Context(), "reusing stored video", "key", object.Key)
+	} else {
+		object = cfg.storeUploadedVideo(w, r, path, sourceSHA256, contentType, publish)
+		if object == nil {
+			return database.Video{}, false
+		}
 	}
 
-	// create a randomized string for the file name to prevent caching
+	// update video record with the video url, then let go of the file it
+	// replaces, which may be this one if the same file was uploaded again.
+	// Another upload may have replaced the file since the video was read,
+	// so swap against the current one until nobody else got there first.
+	previousURL := video.VideoURL
+	videoURL := cfg.videoStore.URL(object.Key)
+	for {
+		updated, err := db.UpdateVideoMedia(video.ID, previousURL, videoURL, object.AspectRatio, object.Orientation)
+		if errors.Is(err, database.ErrVideoModified) {
+			var current database.Video
+			current, err = db.GetVideo(video.ID)
+			if err == nil && current.ID != uuid.Nil {
+				previousURL = current.VideoURL
+				continue
+			}
+			if err == nil {
+				cfg.releaseVideoFile(r.Context(), videoURL)
+				respondWithError(w, http.StatusNotFound, "Video not found", nil)
+				return database.Video{}, false
+			}
+		}
+		if err != nil {
+			cfg.releaseVideoFile(r.Context(), videoURL)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return database.Video{}, false
+		}
+		video = updated
+		break
+	}
+	if previousURL != nil {
+		cfg.releaseVideoFile(r.Context(), *previousURL)
+	}
+	slog.DebugContext(r.Context(), "saved video url", "url", videoURL)
+	return video, true
+}
+
+// storeUploadedVideo probes the upload at path, processes it for fast start
+// and puts it in the video store under a new key, recording the stored
+// object. It responds with an error and returns nil on failure.
+func (cfg *apiConfig) storeUploadedVideo(w http.ResponseWriter, r *http.Request, path, sourceSHA256, contentType string, publish func(progress.Update)) *database.StoredObject {
+	// file the video under the bucket for its shape as it's displayed,
+	// without a duration fast-start progress just isn't reported
+	publish(progress.Update{Stage: progress.StageProbing})
+	probe, err := cfg.media.Probe(r.Context(), path)
+	if err != nil {
+		slog.WarnContext(r.Context(), "couldn't probe video", "error", err)
+	}
+	buckets := cfg.aspectBuckets
+	if buckets == nil {
+		buckets = media.DefaultBuckets
+	}
+	aspect := probe.DisplayAspect()
+
+	// a released key may still be deleted from the store, so never reuse one
 	randomBytes := make([]byte, 8)
 	_, err = rand.Read(randomBytes)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create random string for file name", err)
-		return
+		return nil
+	}
+	object := &database.StoredObject{
+		Key:          buckets.Classify(aspect) + "/" + sourceSHA256 + "-" + base64.RawURLEncoding.EncodeToString(randomBytes) + ".mp4",
+		SourceSHA256: sourceSHA256,
+	}
+	if aspect.Valid() {
+		ratio, orientation := aspect.String(), aspect.Orientation()
+		object.AspectRatio, object.Orientation = &ratio, &orientation
 	}
-	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
-	key := folder + randomString + ".mp4"
 
 	// process video for fast start
-	processedPath, err := processVideoForFastStart(tempFile.Name())
+	publish(progress.Update{Stage: progress.StageFastStart})
+	processedPath := path + ".processing"
+	err = cfg.media.Remux(r.Context(), path, processedPath, probe.Duration, func(percent float64) {
+		publish(progress.Update{Stage: progress.StageFastStart, Percent: percent})
+	})
+	var toolErr *mediatool.Error
+	if errors.As(err, &toolErr) && toolErr.TimedOut {
+		respondWithError(w, http.StatusUnprocessableEntity, "Video took too long to process", err)
+		return nil
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't process video for fast start", err)
-		return
+		return nil
+	}
+	defer os.Remove(processedPath)
+
+	// the stored file's hash is kept so it can be checked for corruption later
+	object.SHA256, object.Size, err = hashFile(processedPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash processed video", err)
+		return nil
 	}
 
 	// open processed video
 	uploadFile, err := os.Open(processedPath)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't open processed file for upload", err)
-		return
+		return nil
 	}
 	defer uploadFile.Close()
-	defer os.Remove(processedPath)
 
 	// put video in the bucket
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        uploadFile,
-		ContentType: &contentType,
+	body := progress.NewReader(uploadFile, func(n int64) {
+		publish(progress.Update{Stage: progress.StageUploading, BytesDone: n, BytesTotal: object.Size, Percent: percentOf(n, object.Size)})
 	})
+	err = cfg.videoStore.Put(r.Context(), object.Key, body, contentType)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to upload to S3", err)
-		return
+		return nil
 	}
 
-	// update video record with the video url
-	videoURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key) // new url
-	video.VideoURL = &videoURL
-
-	log.Printf("about to update id=%s url=%q", video.ID, videoURL)
-	err = cfg.db.UpdateVideo(video)
+	recorded, err := cfg.db.WithContext(r.Context()).AddStoredObject(*object)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record stored video", err)
+		return nil
+	}
+	if recorded.Key != object.Key {
+		// an identical upload was stored first, so share its file
+		if err := cfg.videoStore.Delete(r.Context(), object.Key); err != nil {
+			slog.ErrorContext(r.Context(), "couldn't delete duplicate video", "key", object.Key, "error", err)
+		}
 	}
-	v2, _ := cfg.db.GetVideo(video.ID)
-	log.Printf("after update id=%s url=%v", v2.ID, v2.VideoURL)
+	return &recorded
+}
 
-	// success response
-	respondWithJSON(w, http.StatusOK, video)
+// hashFile returns the hex SHA-256 and size of a file
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
 
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
 }
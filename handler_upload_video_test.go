@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media/mediatest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func newUploadTestConfig(t *testing.T) (*apiConfig, *http.ServeMux, *mediatest.Fake) {
+	t.Helper()
+
+	cfg, mux := newAccountTestConfig(t)
+	fake := mediatest.NewFake()
+	cfg.media = fake
+	cfg.uploadProgress = progress.NewTracker()
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	return cfg, mux, fake
+}
+
+// uploadVideo posts contents as the video file of a multipart form
+func uploadVideo(t *testing.T, mux *http.ServeMux, videoID, token, contentType string, contents []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="video.mp4"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(contents)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+videoID, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadVideoFolderRouting(t *testing.T) {
+	cfg, mux, fake := newUploadTestConfig(t)
+	user, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	tests := []struct {
+		name        string
+		probe       media.Probe
+		err         error
+		folder      string
+		aspectRatio string
+		orientation string
+	}{
+		{"landscape", media.Probe{Width: 1920, Height: 1080}, nil, "landscape/", "16:9", "landscape"},
+		{"portrait", media.Probe{Width: 1080, Height: 1920}, nil, "portrait/", "9:16", "portrait"},
+		{"sideways phone", media.Probe{Width: 1920, Height: 1080, Rotation: 90}, nil, "portrait/", "9:16", "portrait"},
+		{"square", media.Probe{Width: 720, Height: 720}, nil, "square/", "1:1", "square"},
+		{"four by three", media.Probe{Width: 640, Height: 480}, nil, "standard/", "4:3", "landscape"},
+		{"ultrawide", media.Probe{Width: 2560, Height: 1080}, nil, "ultrawide/", "64:27", "landscape"},
+		{"flat", media.Probe{Width: 1998, Height: 1080}, nil, "other/", "37:20", "landscape"},
+		{"unprobeable", media.Probe{}, errors.New("no video stream found"), "other/", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video := createTestVideo(t, cfg, user.ID, tt.name, database.VisibilityPrivate)
+			fake.QueueProbe(tt.probe, tt.err)
+
+			contents := []byte("pretend " + tt.name + " mp4")
+			rec := uploadVideo(t, mux, video.ID.String(), token, "video/mp4", contents)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("upload status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+			}
+
+			video, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if video.VideoURL == nil || !strings.HasPrefix(*video.VideoURL, "http://cdn.test/"+tt.folder) {
+				t.Fatalf("video URL = %v, want one under %s", video.VideoURL, tt.folder)
+			}
+			if deref(video.AspectRatio) != tt.aspectRatio || deref(video.Orientation) != tt.orientation {
+				t.Errorf("aspect ratio, orientation = %q, %q, want %q, %q", deref(video.AspectRatio), deref(video.Orientation), tt.aspectRatio, tt.orientation)
+			}
+
+			// the fake's remux copies the upload, so the stored file is what was sent
+			key := strings.TrimPrefix(*video.VideoURL, "http://cdn.test/")
+			stored, err := os.ReadFile(filepath.Join(cfg.videoStore.(storage.LocalStore).Root, key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, contents) {
+				t.Errorf("stored video = %q, want %q", stored, contents)
+			}
+		})
+	}
+}
+
+func TestUploadVideoCustomBuckets(t *testing.T) {
+	cfg, mux, fake := newUploadTestConfig(t)
+	user, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	buckets, err := media.ParseBuckets("wide=16:9,cinema=2.39:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.aspectBuckets = buckets
+
+	video := createTestVideo(t, cfg, user.ID, "Boots", database.VisibilityPrivate)
+	fake.QueueProbe(media.Probe{Width: 1920, Height: 804}, nil)
+	if rec := uploadVideo(t, mux, video.ID.String(), token, "video/mp4", []byte("mp4")); rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.VideoURL == nil || !strings.HasPrefix(*video.VideoURL, "http://cdn.test/cinema/") {
+		t.Errorf("video URL = %v, want one under cinema/", video.VideoURL)
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func TestUploadVideoRemuxFailure(t *testing.T) {
+	cfg, mux, fake := newUploadTestConfig(t)
+	user, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	video := createTestVideo(t, cfg, user.ID, "Boots", database.VisibilityPrivate)
+
+	if rec := uploadVideo(t, mux, video.ID.String(), token, "video/quicktime", []byte("mov")); rec.Code != http.StatusBadRequest {
+		t.Errorf("upload of a non-mp4 status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if len(fake.Calls()) != 0 {
+		t.Errorf("expected a rejected upload not to be processed, got %+v", fake.Calls())
+	}
+
+	fake.Fail(mediatest.OpRemux, errors.New("ffmpeg exited with status 1"))
+	if rec := uploadVideo(t, mux, video.ID.String(), token, "video/mp4", []byte("mp4")); rec.Code != http.StatusInternalServerError {
+		t.Errorf("upload with a failing remux status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if update, _, _ := cfg.uploadProgress.Latest(video.ID); update.Stage != progress.StageFailed {
+		t.Errorf("expected the upload to be reported as failed, got %+v", update)
+	}
+	if video, _ := cfg.db.GetVideo(video.ID); video.VideoURL != nil {
+		t.Errorf("expected no video URL after a failed upload, got %q", *video.VideoURL)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[0].Op != mediatest.OpProbe || calls[1].Op != mediatest.OpRemux {
+		t.Errorf("expected a probe then a remux, got %+v", calls)
+	}
+}
+
+func TestUploadVideoDeduplicates(t *testing.T) {
+	cfg, mux, fake := newUploadTestConfig(t)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	user, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	first := createTestVideo(t, cfg, user.ID, "First", database.VisibilityPrivate)
+	second := createTestVideo(t, cfg, user.ID, "Second", database.VisibilityPrivate)
+
+	contents := []byte("the same mp4")
+	for _, video := range []database.Video{first, second, second} {
+		if rec := uploadVideo(t, mux, video.ID.String(), token, "video/mp4", contents); rec.Code != http.StatusOK {
+			t.Fatalf("upload status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+		}
+	}
+
+	// only the first upload was processed and stored
+	remuxes := 0
+	for _, call := range fake.Calls() {
+		if call.Op == mediatest.OpRemux {
+			remuxes++
+		}
+	}
+	if remuxes != 1 {
+		t.Errorf("expected one remux, got %d", remuxes)
+	}
+	first, _ = cfg.db.GetVideo(first.ID)
+	second, _ = cfg.db.GetVideo(second.ID)
+	if first.VideoURL == nil || second.VideoURL == nil || *first.VideoURL != *second.VideoURL {
+		t.Fatalf("expected both videos to share a file, got %v and %v", first.VideoURL, second.VideoURL)
+	}
+	if deref(second.AspectRatio) != "16:9" {
+		t.Errorf("expected the shared file's aspect ratio to be copied, got %q", deref(second.AspectRatio))
+	}
+
+	objects, err := cfg.db.ListStoredObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].RefCount != 2 || objects[0].Size != int64(len(contents)) {
+		t.Fatalf("stored objects = %+v, want one with two references", objects)
+	}
+	path := filepath.Join(cfg.videoStore.(storage.LocalStore).Root, objects[0].Key)
+
+	// the file is kept until the last video using it is deleted
+	if rec := sendJSON(t, mux, http.MethodDelete, "/api/videos/"+first.ID.String(), nil, token); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the shared file to be kept: %v", err)
+	}
+	if rec := sendJSON(t, mux, http.MethodDelete, "/api/videos/"+second.ID.String(), nil, token); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the file to be deleted with its last video, got %v", err)
+	}
+	if objects, _ := cfg.db.ListStoredObjects(); len(objects) != 0 {
+		t.Errorf("expected no stored objects left, got %+v", objects)
+	}
+}
+
+func TestUploadVideoAfterReleaseGetsNewKey(t *testing.T) {
+	cfg, mux, _ := newUploadTestConfig(t)
+	user, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	first := createTestVideo(t, cfg, user.ID, "First", database.VisibilityPrivate)
+	second := createTestVideo(t, cfg, user.ID, "Second", database.VisibilityPrivate)
+	root := cfg.videoStore.(storage.LocalStore).Root
+
+	contents := []byte("the same mp4")
+	if rec := uploadVideo(t, mux, first.ID.String(), token, "video/mp4", contents); rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	first, _ = cfg.db.GetVideo(first.ID)
+	oldKey := strings.TrimPrefix(*first.VideoURL, "http://cdn.test/")
+
+	// the last reference goes, but the file's delete hasn't happened yet when
+	// the same content is uploaded again
+	unused, err := cfg.db.ReleaseStoredObject(oldKey)
+	if err != nil || !unused {
+		t.Fatalf("ReleaseStoredObject = %v, %v", unused, err)
+	}
+	if rec := uploadVideo(t, mux, second.ID.String(), token, "video/mp4", contents); rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if err := cfg.videoStore.Delete(context.Background(), oldKey); err != nil {
+		t.Fatal(err)
+	}
+
+	second, _ = cfg.db.GetVideo(second.ID)
+	newKey := strings.TrimPrefix(*second.VideoURL, "http://cdn.test/")
+	if newKey == oldKey {
+		t.Fatalf("expected the new upload to get a new key, got %s again", newKey)
+	}
+	if stored, err := os.ReadFile(filepath.Join(root, newKey)); err != nil || !bytes.Equal(stored, contents) {
+		t.Errorf("stored video = %q, %v, want %q", stored, err, contents)
+	}
+}
+
+func TestOverlappingUploadsReleaseTheFileTheyReplace(t *testing.T) {
+	cfg, mux, _ := newUploadTestConfig(t)
+	user, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	video := createTestVideo(t, cfg, user.ID, "Boots", database.VisibilityPrivate)
+	root := cfg.videoStore.(storage.LocalStore).Root
+
+	// a second upload reads the video before the first one saves its file
+	stale, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := uploadVideo(t, mux, video.ID.String(), token, "video/mp4", []byte("first mp4")); rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	first, _ := cfg.db.GetVideo(video.ID)
+	firstKey := strings.TrimPrefix(*first.VideoURL, "http://cdn.test/")
+
+	path := filepath.Join(t.TempDir(), "second.mp4")
+	if err := os.WriteFile(path, []byte("second mp4"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+video.ID.String(), nil)
+	saved, ok := cfg.saveUploadedVideo(rec, req, stale, path, "second-sha", "video/mp4", func(progress.Update) {})
+	if !ok {
+		t.Fatalf("saveUploadedVideo failed: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// the first upload's file is the one replaced, so it's the one let go
+	if saved.VideoURL == nil || *saved.VideoURL == *first.VideoURL {
+		t.Fatalf("video url = %v, want the second upload", saved.VideoURL)
+	}
+	if _, err := os.Stat(filepath.Join(root, firstKey)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("replaced file still stored: %v", err)
+	}
+}
+
+func TestAddStoredObjectSharesConcurrentUpload(t *testing.T) {
+	cfg, _ := newAccountTestConfig(t)
+
+	object := database.StoredObject{Key: "landscape/abc-1.mp4", SourceSHA256: "abc", SHA256: "def", Size: 3}
+	recorded, err := cfg.db.AddStoredObject(object)
+	if err != nil || recorded.Key != object.Key || recorded.RefCount != 1 {
+		t.Fatalf("AddStoredObject = %+v, %v", recorded, err)
+	}
+
+	// an identical upload stored at the same time references the first
+	object.Key = "landscape/abc-2.mp4"
+	recorded, err = cfg.db.AddStoredObject(object)
+	if err != nil || recorded.Key != "landscape/abc-1.mp4" || recorded.RefCount != 2 {
+		t.Fatalf("AddStoredObject = %+v, %v", recorded, err)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const maxDisplayNameLength = 100
+
+func (cfg *apiConfig) handlerUsersMeGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+func (cfg *apiConfig) handlerUsersMeUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email       *string `json:"email"`
+		DisplayName *string `json:"display_name"`
+		// CurrentPassword is needed to change the email
+		CurrentPassword string `json:"current_password"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	// only change the fields that were sent
+	update := database.UpdateUserProfileParams{
+		ID:          user.ID,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+	}
+	if params.DisplayName != nil {
+		if len(*params.DisplayName) > maxDisplayNameLength {
+			respondWithError(w, http.StatusBadRequest, "Display name is too long", nil)
+			return
+		}
+		update.DisplayName = *params.DisplayName
+	}
+	emailChanged := params.Email != nil && *params.Email != user.Email
+	if emailChanged {
+		// password resets go to the new address, so a stolen access token
+		// mustn't be enough to move the account
+		match, err := auth.CheckPasswordHash(params.CurrentPassword, user.Password)
+		if err != nil || !match {
+			respondWithError(w, http.StatusUnauthorized, "Current password is incorrect", err)
+			return
+		}
+		if !validEmail(*params.Email) {
+			respondWithError(w, http.StatusBadRequest, "Invalid email address", nil)
+			return
+		}
+		existing, err := db.GetUserByEmail(*params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check email", err)
+			return
+		}
+		if existing.ID != user.ID && existing.Email != "" {
+			respondWithError(w, http.StatusConflict, "Email is already in use", nil)
+			return
+		}
+		update.Email = *params.Email
+	}
+
+	updated, err := db.UpdateUserProfile(update)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	// a new address has to be verified again
+	if emailChanged {
+		err = cfg.sendVerificationEmail(r.Context(), *updated)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "couldn't send verification email", "user_id", updated.ID, "error", err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (cfg *apiConfig) handlerUsersMeChangePassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "New password is required", nil)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	match, err := auth.CheckPasswordHash(params.CurrentPassword, user.Password)
+	if err != nil || !match {
+		respondWithError(w, http.StatusUnauthorized, "Current password is incorrect", err)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	err = db.UpdateUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+
+	// sign out everywhere else, as a password reset does
+	err = db.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerUsersMeDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	user, err := db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	// accounts with a password must confirm it; SSO-only accounts have none
+	if user.Password != "" {
+		params := parameters{}
+		err = json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+		match, err := auth.CheckPasswordHash(params.Password, user.Password)
+		if err != nil || !match {
+			respondWithError(w, http.StatusUnauthorized, "Password is incorrect", err)
+			return
+		}
+	}
+
+	videos, err := db.DeleteUserAccount(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+	for _, video := range videos {
+		cfg.deleteVideoMedia(r.Context(), video)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func newAccountTestConfig(t *testing.T) (*apiConfig, *http.ServeMux) {
+	t.Helper()
+
+	root := t.TempDir()
+	cfg := &apiConfig{
+		db:         newTestDB(t),
+		keyring:    newTestKeyring(t),
+		mailer:     &recordingMailer{},
+		baseURL:    "http://tubely.test",
+		videoStore: storage.LocalStore{Root: filepath.Join(root, "videos"), BaseURL: "http://cdn.test"},
+		assetStore: storage.LocalStore{Root: filepath.Join(root, "assets"), BaseURL: "http://tubely.test/assets"},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("GET /api/users/me", cfg.handlerUsersMeGet)
+	mux.HandleFunc("PATCH /api/users/me", cfg.handlerUsersMeUpdate)
+	mux.HandleFunc("POST /api/users/me/password", cfg.handlerUsersMeChangePassword)
+	mux.HandleFunc("DELETE /api/users/me", cfg.handlerUsersMeDelete)
+
+	return cfg, mux
+}
+
+// createTestUser creates a user with the given password and logs them in
+func createTestUser(t *testing.T, cfg *apiConfig, mux *http.ServeMux, email, password string) (database.User, string, string) {
+	t.Helper()
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hash}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/login", map[string]string{"email": email, "password": password}, "")
+	var login struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&login); err != nil {
+		t.Fatal(err)
+	}
+	return login.User, login.Token, login.RefreshToken
+}
+
+func TestUsersMeUpdate(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	_, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	createTestUser(t, cfg, mux, "taken@example.com", "hunter2")
+
+	rec := sendJSON(t, mux, http.MethodPatch, "/api/users/me", map[string]string{"display_name": "Boots"}, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), "password") {
+		t.Error("profile response exposes the password hash")
+	}
+
+	// changing the email takes the current password as well as the token
+	for _, password := range []string{"", "wrong"} {
+		rec = sendJSON(t, mux, http.MethodPatch, "/api/users/me", map[string]string{"email": "new@example.com", "current_password": password}, token)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("email change with password %q status = %d, want %d", password, rec.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rec = sendJSON(t, mux, http.MethodPatch, "/api/users/me", map[string]string{"email": "taken@example.com", "current_password": "hunter2"}, token)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("duplicate email status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	rec = sendJSON(t, mux, http.MethodGet, "/api/users/me", nil, token)
+	var me database.User
+	if err := json.NewDecoder(rec.Body).Decode(&me); err != nil {
+		t.Fatal(err)
+	}
+	if me.DisplayName != "Boots" || me.Email != "boots@example.com" {
+		t.Errorf("profile = %q <%s>", me.DisplayName, me.Email)
+	}
+}
+
+func TestUsersMeChangePasswordChecksCurrentPassword(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	_, token, refreshToken := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/users/me/password", map[string]string{"current_password": "wrong", "new_password": "new"}, token)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = sendJSON(t, mux, http.MethodPost, "/api/users/me/password", map[string]string{"current_password": "hunter2", "new_password": "new"}, token)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("change password status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/refresh", nil, refreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after password change status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUsersMeDeleteRemovesContent(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	user, token, refreshToken := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "boots", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := cfg.assetStore.Put(ctx, "thumb.png", strings.NewReader("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.videoStore.Put(ctx, "landscape/video.mp4", strings.NewReader("mp4"), "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	thumbnailURL := cfg.assetStore.URL("thumb.png")
+	videoURL := cfg.videoStore.URL("landscape/video.mp4")
+	video.ThumbnailURL = &thumbnailURL
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.videoStore.Put(ctx, "captions/en.vtt", strings.NewReader("WEBVTT"), "text/vtt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.PutCaption(database.Caption{VideoID: video.ID, Language: "en", Label: "en", URL: cfg.videoStore.URL("captions/en.vtt")}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := sendJSON(t, mux, http.MethodDelete, "/api/users/me", map[string]string{"password": "wrong"}, token)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("delete with wrong password status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = sendJSON(t, mux, http.MethodDelete, "/api/users/me", map[string]string{"password": "hunter2"}, token)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	if got, err := cfg.db.GetUser(user.ID); err != nil || got != nil {
+		t.Errorf("user still exists after delete: %v %v", got, err)
+	}
+	if videos, err := cfg.db.GetVideos(user.ID); err != nil || len(videos) != 0 {
+		t.Errorf("videos after delete = %d, err %v", len(videos), err)
+	}
+	if rec := sendJSON(t, mux, http.MethodPost, "/api/refresh", nil, refreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after delete status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	for _, path := range []string{
+		filepath.Join(cfg.assetStore.(storage.LocalStore).Root, "thumb.png"),
+		filepath.Join(cfg.videoStore.(storage.LocalStore).Root, "landscape", "video.mp4"),
+		filepath.Join(cfg.videoStore.(storage.LocalStore).Root, "captions", "en.vtt"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after delete", path)
+		}
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
 	"github.com/google/uuid"
 )
 
@@ -20,7 +24,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -35,11 +39,12 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	}
 	params.UserID = userID
 
-	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
+	video, err := cfg.db.WithContext(r.Context()).CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.notifyWebhooks(r.Context(), userID, webhooks.EventVideoCreated, video)
 
 	respondWithJSON(w, http.StatusCreated, video)
 }
@@ -57,13 +62,14 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	db := cfg.db.WithContext(r.Context())
+	video, err := db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
@@ -73,11 +79,14 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = db.DeleteVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	// the video file may still be shared with other uploads of the same content
+	cfg.deleteVideoMedia(r.Context(), video)
+	cfg.notifyWebhooks(r.Context(), userID, webhooks.EventVideoDeleted, video)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -92,19 +101,26 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// anonymous viewers can only see published videos
+	viewerID, err := cfg.optionalUserID(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
 	// retrieve video from db
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-	if video.VideoURL == nil || !strings.Contains(*video.VideoURL, ",") {
-		// Draft or old-format URL: return as-is so the page can open
-		respondWithJSON(w, http.StatusOK, video)
+	// drafts and private videos look the same as missing ones to everyone but the owner
+	if video.ID == uuid.Nil || !videoVisibleTo(video, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
 
-	// respond with the signed video
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
@@ -116,14 +132,26 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
+	// optionally filter by tag or category
+	query := r.URL.Query()
+	category := query.Get("category")
+	if category != "" && !database.ValidCategory(category) {
+		respondWithError(w, http.StatusBadRequest, "Unknown category", nil)
+		return
+	}
+
 	// get the user's videos
-	videos, err := cfg.db.GetVideos(userID)
+	videos, err := cfg.db.WithContext(r.Context()).ListVideos(database.ListVideosParams{
+		UserID:   userID,
+		Tag:      strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Category: category,
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
@@ -133,3 +161,176 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 	respondWithJSON(w, http.StatusOK, videos)
 
 }
+
+func (cfg *apiConfig) handlerCategoriesGet(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, database.Categories)
+}
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 5000
+	maxTags              = 20
+	maxTagLength         = 50
+)
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	// only the fields that are present are changed
+	type parameters struct {
+		Title       *string              `json:"title"`
+		Description *string              `json:"description"`
+		Tags        *[]string            `json:"tags"`
+		Visibility  *database.Visibility `json:"visibility"`
+		Category    *string              `json:"category"`
+		Published   *bool                `json:"published"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	video, err := db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+
+	// clients must prove they've seen the current version before changing it
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respondWithError(w, http.StatusPreconditionRequired, "If-Match header is required", nil)
+		return
+	}
+	if ifMatch != "*" && ifMatch != videoETag(video) {
+		w.Header().Set("ETag", videoETag(video))
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", nil)
+		return
+	}
+
+	if params.Title != nil {
+		title := strings.TrimSpace(*params.Title)
+		if title == "" || len(title) > maxTitleLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Title must be 1 to %d characters", maxTitleLength), nil)
+			return
+		}
+		video.Title = title
+	}
+	if params.Description != nil {
+		if len(*params.Description) > maxDescriptionLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Description must be at most %d characters", maxDescriptionLength), nil)
+			return
+		}
+		video.Description = *params.Description
+	}
+	if params.Visibility != nil {
+		if !validVisibility(*params.Visibility) {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+			return
+		}
+		video.Visibility = *params.Visibility
+	}
+	if params.Category != nil {
+		// an empty category clears it
+		switch {
+		case *params.Category == "":
+			video.Category = nil
+		case database.ValidCategory(*params.Category):
+			video.Category = params.Category
+		default:
+			respondWithError(w, http.StatusBadRequest, "Unknown category", nil)
+			return
+		}
+	}
+	if params.Published != nil {
+		switch {
+		case !*params.Published:
+			video.PublishedAt = nil
+		case video.VideoURL == nil:
+			respondWithError(w, http.StatusBadRequest, "Upload a video file before publishing", nil)
+			return
+		case video.PublishedAt == nil:
+			// republishing an already published video keeps its original date
+			now := time.Now().UTC().Truncate(time.Millisecond)
+			video.PublishedAt = &now
+		}
+	}
+	if params.Tags != nil {
+		tags, err := normalizeTags(*params.Tags)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid tags: "+err.Error(), nil)
+			return
+		}
+		video.Tags = tags
+	}
+
+	updated, err := db.UpdateVideoMetadata(video, video.UpdatedAt)
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(updated))
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// videoETag identifies a version of a video by its last update time
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%x"`, video.UpdatedAt.UnixMilli())
+}
+
+// normalizeTags lowercases, trims and de-duplicates tags, rejecting any that
+// are empty, too long or contain commas
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > maxTagLength || strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tags must be 1 to %d characters without commas", maxTagLength)
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("a video can have at most %d tags", maxTags)
+	}
+	return normalized, nil
+}
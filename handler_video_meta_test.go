@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func patchVideo(t *testing.T, mux *http.ServeMux, videoID, token, ifMatch string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	dat, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/api/videos/"+videoID, bytes.NewReader(dat))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestVideoMetaUpdate(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+
+	owner, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Boots", Description: "typo", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := video.ID.String()
+
+	rec := sendJSON(t, mux, http.MethodGet, "/api/videos/"+id, nil, token)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET video has no ETag")
+	}
+
+	if rec := patchVideo(t, mux, id, token, "", map[string]string{"title": "x"}); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without If-Match status = %d, want %d", rec.Code, http.StatusPreconditionRequired)
+	}
+	if rec := patchVideo(t, mux, id, otherToken, etag, map[string]string{"title": "x"}); rec.Code != http.StatusForbidden {
+		t.Errorf("PATCH by another user status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := patchVideo(t, mux, id, token, etag, map[string]string{"visibility": "everyone"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("PATCH with invalid visibility status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := patchVideo(t, mux, id, token, etag, map[string]string{"titel": "x"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("PATCH with unknown field status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = patchVideo(t, mux, id, token, etag, map[string]any{
+		"description": "fixed",
+		"tags":        []string{"Cats", " dogs ", "cats"},
+		"visibility":  "unlisted",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var updated database.Video
+	if err := json.NewDecoder(rec.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "Boots" || updated.Description != "fixed" || updated.Visibility != database.VisibilityUnlisted {
+		t.Errorf("updated video = %+v", updated)
+	}
+	if !reflect.DeepEqual(updated.Tags, []string{"cats", "dogs"}) {
+		t.Errorf("updated tags = %v, want [cats dogs]", updated.Tags)
+	}
+	if !updated.UpdatedAt.After(video.UpdatedAt) {
+		t.Errorf("updated_at = %v, not after %v", updated.UpdatedAt, video.UpdatedAt)
+	}
+	newETag := rec.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("ETag after update = %q, previous %q", newETag, etag)
+	}
+
+	// the old ETag is now stale
+	if rec := patchVideo(t, mux, id, token, etag, map[string]string{"title": "lost update"}); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with stale ETag status = %d, want %d", rec.Code, http.StatusPreconditionFailed)
+	}
+	if rec := patchVideo(t, mux, id, token, newETag, map[string]any{"tags": []string{}}); rec.Code != http.StatusOK {
+		t.Errorf("PATCH with current ETag status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestMediaUpdatesKeepMetadata(t *testing.T) {
+	cfg, mux := newAccountTestConfig(t)
+	owner, _, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Boots", Description: "typo", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a PATCH lands while an upload that read the video earlier is running
+	patched := video
+	patched.Title = "Fixed"
+	if _, err := cfg.db.UpdateVideoMetadata(patched, video.UpdatedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cfg.db.UpdateVideoThumbnail(video.ID, "http://localhost/assets/thumb.png"); err != nil {
+		t.Fatal(err)
+	}
+	ratio, orientation := "16:9", "landscape"
+	got, err := cfg.db.UpdateVideoMedia(video.ID, nil, "http://localhost/assets/video.mp4", &ratio, &orientation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Fixed" {
+		t.Errorf("title = %q after media updates, want the patched %q", got.Title, "Fixed")
+	}
+	if got.ThumbnailURL == nil || got.VideoURL == nil || got.AspectRatio == nil || *got.AspectRatio != ratio {
+		t.Errorf("media not saved: %+v", got)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWebhooksPerUser       = 10
+	minWebhookSecretLength   = 16
+	defaultWebhookDeliveries = 50
+	maxWebhookDeliveries     = 200
+)
+
+type webhookCreatedResponse struct {
+	database.Webhook
+	// Secret is only shown when the webhook is created
+	Secret string `json:"secret"`
+}
+
+// notifyWebhooks queues an event for the user's webhooks. Webhooks are best
+// effort, so a failure is logged rather than failing the request.
+func (cfg *apiConfig) notifyWebhooks(ctx context.Context, userID uuid.UUID, event string, video database.Video) {
+	if cfg.webhooks == nil {
+		return
+	}
+	if err := cfg.webhooks.Enqueue(userID, event, video); err != nil {
+		slog.ErrorContext(ctx, "couldn't queue webhooks", "event", event, "video_id", video.ID, "error", err)
+	}
+}
+
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		// Secret is generated if it isn't given
+		Secret string `json:"secret"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = cfg.webhooks.CheckURL(r.Context(), params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	endpoint, _ := url.Parse(params.URL)
+
+	events := []string{}
+	for _, event := range params.Events {
+		if !slices.Contains(webhooks.Events, event) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event %q", event), nil)
+			return
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "A webhook needs at least one event", nil)
+		return
+	}
+
+	secret := params.Secret
+	if secret == "" {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook secret", err)
+			return
+		}
+	} else if len(secret) < minWebhookSecretLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("secret must be at least %d characters", minWebhookSecretLength), nil)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	existing, err := db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can have at most %d webhooks", maxWebhooksPerUser), nil)
+		return
+	}
+
+	webhook, err := db.CreateWebhook(database.CreateWebhookParams{
+		UserID: userID,
+		URL:    endpoint.String(),
+		Secret: secret,
+		Events: events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, webhookCreatedResponse{Webhook: webhook, Secret: secret})
+}
+
+func (cfg *apiConfig) handlerWebhooksRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	hooks, err := cfg.db.WithContext(r.Context()).GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, hooks)
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).DeleteWebhook(webhook.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveriesRetrieve(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultWebhookDeliveries
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxWebhookDeliveries {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxWebhookDeliveries), err)
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := cfg.db.WithContext(r.Context()).GetWebhookDeliveries(webhook.ID, limit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve deliveries", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// handlerWebhookRedeliver queues a fresh copy of an earlier delivery, keeping
+// the original in the log
+func (cfg *apiConfig) handlerWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return
+	}
+
+	db := cfg.db.WithContext(r.Context())
+	delivery, err := db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get delivery", err)
+		return
+	}
+	if delivery.ID == uuid.Nil || delivery.WebhookID != webhook.ID {
+		respondWithError(w, http.StatusNotFound, "Delivery not found", nil)
+		return
+	}
+
+	redelivery, err := db.CreateWebhookDelivery(webhook.ID, delivery.Event, delivery.Payload)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue delivery", err)
+		return
+	}
+	if cfg.webhooks != nil {
+		cfg.webhooks.Wake()
+	}
+
+	respondWithJSON(w, http.StatusAccepted, redelivery)
+}
+
+// ownedWebhook loads the webhook named in the path if it belongs to the
+// caller, responding with an error and returning false if not. Other users'
+// webhooks are reported as missing.
+func (cfg *apiConfig) ownedWebhook(w http.ResponseWriter, r *http.Request) (database.Webhook, bool) {
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return database.Webhook{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Webhook{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.keyring)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Webhook{}, false
+	}
+
+	webhook, err := cfg.db.WithContext(r.Context()).GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return database.Webhook{}, false
+	}
+	if webhook.ID == uuid.Nil || webhook.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return database.Webhook{}, false
+	}
+	return webhook, true
+}
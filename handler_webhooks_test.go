@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
+)
+
+func newWebhookTestConfig(t *testing.T) (*apiConfig, *http.ServeMux) {
+	t.Helper()
+
+	cfg, mux := newAccountTestConfig(t)
+	cfg.webhooks = webhooks.NewDispatcher(cfg.db, webhooks.Config{
+		Interval:    time.Hour,
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		Backoff:     time.Hour,
+		MaxBackoff:  time.Hour,
+
+		AllowPrivateNetworks: true,
+	})
+	t.Cleanup(cfg.webhooks.Close)
+
+	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/webhooks", cfg.handlerWebhookCreate)
+	mux.HandleFunc("GET /api/webhooks", cfg.handlerWebhooksRetrieve)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.handlerWebhookDelete)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.handlerWebhookDeliveriesRetrieve)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", cfg.handlerWebhookRedeliver)
+	return cfg, mux
+}
+
+func TestWebhookCreateValidation(t *testing.T) {
+	cfg, mux := newWebhookTestConfig(t)
+	_, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+
+	for name, body := range map[string]map[string]any{
+		"relative url":  {"url": "/hook", "events": []string{webhooks.EventVideoCreated}},
+		"ftp url":       {"url": "ftp://example.com/hook", "events": []string{webhooks.EventVideoCreated}},
+		"unknown event": {"url": "https://example.com/hook", "events": []string{"video.exploded"}},
+		"no events":     {"url": "https://example.com/hook", "events": []string{}},
+		"short secret":  {"url": "https://example.com/hook", "events": []string{webhooks.EventVideoCreated}, "secret": "short"},
+	} {
+		rec := sendJSON(t, mux, http.MethodPost, "/api/webhooks", body, token)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/webhooks", map[string]any{
+		"url":    "https://example.com/hook",
+		"events": []string{webhooks.EventVideoCreated, webhooks.EventVideoCreated},
+	}, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created webhookCreatedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" || len(created.Events) != 1 {
+		t.Fatalf("expected a generated secret and deduplicated events, got %+v", created)
+	}
+
+	// the secret is never shown again
+	rec = sendJSON(t, mux, http.MethodGet, "/api/webhooks", nil, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d", rec.Code)
+	}
+	var listed []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0]["secret"] != nil {
+		t.Fatalf("expected one webhook without its secret, got %v", listed)
+	}
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	cfg, mux := newWebhookTestConfig(t)
+	_, token, _ := createTestUser(t, cfg, mux, "boots@example.com", "hunter2")
+	_, otherToken, _ := createTestUser(t, cfg, mux, "other@example.com", "hunter2")
+
+	received := make(chan webhooks.Payload, 4)
+	var signature, timestamp string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(webhooks.SignatureHeader)
+		timestamp = r.Header.Get(webhooks.TimestampHeader)
+		var payload webhooks.Payload
+		json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	rec := sendJSON(t, mux, http.MethodPost, "/api/webhooks", map[string]any{
+		"url":    server.URL,
+		"events": []string{webhooks.EventVideoCreated},
+		"secret": "a-long-enough-secret",
+	}, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var hook webhookCreatedResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &hook); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = sendJSON(t, mux, http.MethodPost, "/api/videos", map[string]string{"title": "hooked"}, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create video: expected 201, got %d", rec.Code)
+	}
+
+	waitForPayload := func() webhooks.Payload {
+		t.Helper()
+		select {
+		case payload := <-received:
+			return payload
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook wasn't delivered")
+		}
+		return webhooks.Payload{}
+	}
+	payload := waitForPayload()
+	if payload.Event != webhooks.EventVideoCreated {
+		t.Fatalf("expected a %s payload, got %q", webhooks.EventVideoCreated, payload.Event)
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != webhooks.Sign("a-long-enough-secret", ts, body) {
+		t.Fatal("delivery signature doesn't verify with the webhook secret")
+	}
+
+	deliveriesPath := "/api/webhooks/" + hook.ID.String() + "/deliveries"
+	rec = sendJSON(t, mux, http.MethodGet, deliveriesPath, nil, otherToken)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("another user's deliveries: expected 404, got %d", rec.Code)
+	}
+
+	var deliveries []database.WebhookDelivery
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		rec = sendJSON(t, mux, http.MethodGet, deliveriesPath, nil, token)
+		deliveries = nil
+		if err := json.Unmarshal(rec.Body.Bytes(), &deliveries); err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Status == database.WebhookDeliverySucceeded {
+			break
+		}
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != database.WebhookDeliverySucceeded {
+		t.Fatalf("expected one successful delivery in the log, got %+v", deliveries)
+	}
+
+	rec = sendJSON(t, mux, http.MethodPost, deliveriesPath+"/"+deliveries[0].ID.String()+"/redeliver", nil, token)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("redeliver: expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	if redelivered := waitForPayload(); redelivered.Event != webhooks.EventVideoCreated {
+		t.Fatalf("expected the redelivery to resend the payload, got %+v", redelivered)
+	}
+
+	rec = sendJSON(t, mux, http.MethodDelete, "/api/webhooks/"+hook.ID.String(), nil, token)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/analytics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media/mediatest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
+)
+
+// testServer is the whole app, routes and middleware, served over HTTP with
+// a temporary database, videos kept on disk and a fake media processor
+type testServer struct {
+	*httptest.Server
+	cfg    *apiConfig
+	media  *mediatest.Fake
+	mailer *recordingMailer
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	// the URL is needed to configure the stores before the server starts
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseURL := "http://" + listener.Addr().String()
+
+	rateLimits, err := parseRateLimits(defaultRateLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+	root := t.TempDir()
+	assetsRoot := filepath.Join(root, "assets")
+	fake := mediatest.NewFake()
+	mail := &recordingMailer{}
+	videoStore := storage.LocalStore{
+		Root:    filepath.Join(assetsRoot, "videos"),
+		BaseURL: baseURL + "/assets/videos",
+	}
+	stagingStore := storage.LocalStore{
+		Root:      filepath.Join(root, "staging"),
+		UploadURL: baseURL + stagedUploadPath,
+		Secret:    []byte("upload secret"),
+	}
+	cfg := &apiConfig{
+		db:           db,
+		keyring:      newTestKeyring(t),
+		platform:     "dev",
+		filepathRoot: filepath.Join(root, "app"),
+		assetsRoot:   assetsRoot,
+		videoStore:   videoStore,
+		stagingStore: stagingStore,
+		assetStore:   storage.LocalStore{Root: assetsRoot, BaseURL: baseURL + "/assets"},
+		mailer:       mail,
+		baseURL:      baseURL,
+		analytics:    analytics.NewRecorder(db, time.Hour, 100),
+		webhooks:     webhooks.NewDispatcher(db, webhooks.DefaultConfig),
+
+		uploadProgress: progress.NewTracker(),
+		metrics:        metrics.New(),
+		shutdown:       make(chan struct{}),
+		rateLimiter:    ratelimit.NewLimiter(ratelimit.NewMemoryStore()),
+		rateLimits:     rateLimits,
+		media:          fake,
+
+		directUploadMaxBytes: 1 << 20,
+	}
+	if err := cfg.ensureAssetsDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(cfg.routes())
+	srv.Listener.Close()
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		cfg.webhooks.Close()
+		cfg.analytics.Close()
+	})
+	return &testServer{Server: srv, cfg: cfg, media: fake, mailer: mail}
+}
+
+// do sends a request, decoding a JSON response into out if it's not nil,
+// and fails the test unless the response has the wanted status
+func (s *testServer) do(t *testing.T, req *http.Request, token string, want int, out any) *http.Response {
+	t.Helper()
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s status = %d, want %d: %s", req.Method, req.URL.Path, resp.StatusCode, want, body)
+	}
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			t.Fatalf("%s %s: couldn't decode %s: %v", req.Method, req.URL.Path, body, err)
+		}
+	}
+	return resp
+}
+
+func (s *testServer) doJSON(t *testing.T, method, path, token string, body any, want int, out any) *http.Response {
+	t.Helper()
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(dat)
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(t, req, token, want, out)
+}
+
+func (s *testServer) upload(t *testing.T, path, token, field, contentType string, contents []byte, want int) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="`+field+`"; filename="upload"`)
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(contents)
+	form.Close()
+
+	req, err := http.NewRequest(http.MethodPost, s.URL+path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.do(t, req, token, want, nil)
+}
+
+// fetch GETs an absolute URL served by the app and returns the body
+func (s *testServer) fetch(t *testing.T, url string, want int) []byte {
+	t.Helper()
+
+	resp, err := s.Client().Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want {
+		t.Fatalf("GET %s status = %d, want %d", url, resp.StatusCode, want)
+	}
+	return body
+}
+
+type loginResponse struct {
+	database.User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// signup creates an account, verifies its email from the sent link and logs in
+func (s *testServer) signup(t *testing.T, email, password string) loginResponse {
+	t.Helper()
+
+	creds := map[string]string{"email": email, "password": password}
+	s.doJSON(t, http.MethodPost, "/api/users", "", creds, http.StatusCreated, nil)
+
+	token := regexp.MustCompile(`verify_token=([\w-]+)`).FindStringSubmatch(s.mailer.last().Body)
+	if token == nil {
+		t.Fatalf("no verification link in %q", s.mailer.last().Body)
+	}
+	s.doJSON(t, http.MethodPost, "/api/users/verify", "", map[string]string{"token": token[1]}, http.StatusNoContent, nil)
+
+	var login loginResponse
+	s.doJSON(t, http.MethodPost, "/api/login", "", creds, http.StatusOK, &login)
+	return login
+}
+
+func TestIntegrationAuth(t *testing.T) {
+	s := newTestServer(t)
+
+	s.doJSON(t, http.MethodPost, "/api/users", "", map[string]string{"email": "boots@example.com"}, http.StatusBadRequest, nil)
+	login := s.signup(t, "boots@example.com", "hunter2")
+	if login.Token == "" || login.RefreshToken == "" || login.EmailVerifiedAt == nil {
+		t.Fatalf("unexpected login response: %+v", login)
+	}
+
+	s.doJSON(t, http.MethodPost, "/api/login", "", map[string]string{"email": "boots@example.com", "password": "wrong"}, http.StatusUnauthorized, nil)
+	s.doJSON(t, http.MethodGet, "/api/users/me", "", nil, http.StatusUnauthorized, nil)
+	s.doJSON(t, http.MethodGet, "/api/users/me", "not-a-jwt", nil, http.StatusUnauthorized, nil)
+	s.doJSON(t, http.MethodGet, "/api/users/me", login.Token, nil, http.StatusOK, nil)
+
+	// the refresh token gets new access tokens until it's revoked
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	s.doJSON(t, http.MethodPost, "/api/refresh", login.RefreshToken, nil, http.StatusOK, &refreshed)
+	s.doJSON(t, http.MethodGet, "/api/users/me", refreshed.Token, nil, http.StatusOK, nil)
+	s.doJSON(t, http.MethodPost, "/api/refresh", login.Token, nil, http.StatusUnauthorized, nil)
+	s.doJSON(t, http.MethodPost, "/api/revoke", login.RefreshToken, nil, http.StatusNoContent, nil)
+	s.doJSON(t, http.MethodPost, "/api/refresh", login.RefreshToken, nil, http.StatusUnauthorized, nil)
+}
+
+func TestIntegrationVideos(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.signup(t, "boots@example.com", "hunter2")
+	other := s.signup(t, "other@example.com", "hunter2")
+
+	var video database.Video
+	s.doJSON(t, http.MethodPost, "/api/videos", "", map[string]string{"title": "Boots"}, http.StatusUnauthorized, nil)
+	s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Boots", "description": "A bear"}, http.StatusCreated, &video)
+	videoPath := "/api/videos/" + video.ID.String()
+
+	// only the owner can see, upload to or delete a private video
+	s.doJSON(t, http.MethodGet, videoPath, other.Token, nil, http.StatusNotFound, nil)
+	s.upload(t, "/api/thumbnail_upload/"+video.ID.String(), other.Token, "thumbnail", "image/png", []byte("png"), http.StatusUnauthorized)
+	s.upload(t, "/api/video_upload/"+video.ID.String(), other.Token, "video", "video/mp4", []byte("mp4"), http.StatusForbidden)
+	s.upload(t, "/api/video_upload/"+video.ID.String(), "", "video", "video/mp4", []byte("mp4"), http.StatusUnauthorized)
+	s.doJSON(t, http.MethodDelete, videoPath, other.Token, nil, http.StatusForbidden, nil)
+
+	s.upload(t, "/api/thumbnail_upload/"+video.ID.String(), owner.Token, "thumbnail", "text/plain", []byte("txt"), http.StatusBadRequest)
+	s.upload(t, "/api/thumbnail_upload/"+video.ID.String(), owner.Token, "thumbnail", "image/png", []byte("png bytes"), http.StatusOK)
+
+	s.media.QueueProbe(media.Probe{Width: 1080, Height: 1920, Duration: 30 * time.Second}, nil)
+	s.upload(t, "/api/video_upload/"+video.ID.String(), owner.Token, "video", "video/mp4", []byte("mp4 bytes"), http.StatusOK)
+
+	s.doJSON(t, http.MethodGet, videoPath, owner.Token, nil, http.StatusOK, &video)
+	if video.ThumbnailURL == nil || video.VideoURL == nil {
+		t.Fatalf("expected the uploads to be saved on the video, got %+v", video)
+	}
+	if !strings.Contains(*video.VideoURL, "/assets/videos/portrait/") {
+		t.Errorf("expected a portrait video, got %s", *video.VideoURL)
+	}
+	if got := s.fetch(t, *video.ThumbnailURL, http.StatusOK); string(got) != "png bytes" {
+		t.Errorf("thumbnail = %q", got)
+	}
+	if got := s.fetch(t, *video.VideoURL, http.StatusOK); string(got) != "mp4 bytes" {
+		t.Errorf("video = %q", got)
+	}
+
+	var videos []database.Video
+	s.doJSON(t, http.MethodGet, "/api/videos", owner.Token, nil, http.StatusOK, &videos)
+	if len(videos) != 1 || videos[0].ID != video.ID {
+		t.Errorf("owner's videos = %+v", videos)
+	}
+	s.doJSON(t, http.MethodGet, "/api/videos", other.Token, nil, http.StatusOK, &videos)
+	if len(videos) != 0 {
+		t.Errorf("expected another user to have no videos, got %+v", videos)
+	}
+
+	// deleting the video removes its files too
+	s.doJSON(t, http.MethodDelete, videoPath, owner.Token, nil, http.StatusNoContent, nil)
+	s.doJSON(t, http.MethodGet, videoPath, owner.Token, nil, http.StatusNotFound, nil)
+	s.fetch(t, *video.ThumbnailURL, http.StatusNotFound)
+	s.fetch(t, *video.VideoURL, http.StatusNotFound)
+}
+
+func TestIntegrationReset(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.signup(t, "boots@example.com", "hunter2")
+	s.doJSON(t, http.MethodPost, "/api/videos", owner.Token, map[string]string{"title": "Boots"}, http.StatusCreated, nil)
+
+	s.cfg.platform = "production"
+	s.doJSON(t, http.MethodPost, "/admin/reset", "", nil, http.StatusForbidden, nil)
+
+	s.cfg.platform = "dev"
+	s.doJSON(t, http.MethodPost, "/admin/reset", "", nil, http.StatusOK, nil)
+	s.doJSON(t, http.MethodPost, "/api/login", "", map[string]string{"email": "boots@example.com", "password": "hunter2"}, http.StatusUnauthorized, nil)
+	if _, err := os.Stat(s.cfg.assetsRoot); err != nil {
+		t.Errorf("expected the assets directory to survive a reset: %v", err)
+	}
+}
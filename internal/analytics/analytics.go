@@ -0,0 +1,186 @@
+// Package analytics batches playback events in memory and writes them to the
+// database as per-video daily totals, so recording an event never waits on
+// the database.
+package analytics
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EventPlay     EventType = "play"
+	EventProgress EventType = "progress"
+	EventComplete EventType = "complete"
+)
+
+type Event struct {
+	VideoID uuid.UUID
+	Type    EventType
+	// Quartile is set for progress events
+	Quartile int
+	// WatchedSeconds is the time watched since the viewer's previous event
+	WatchedSeconds float64
+	// ViewerHash identifies the viewer without storing who they are
+	ViewerHash string
+	Time       time.Time
+}
+
+// Store is where batches are written. database.Client implements it.
+type Store interface {
+	RecordVideoStats(stats []database.VideoDailyStats, viewers []database.VideoDailyViewer) error
+}
+
+type statsKey struct {
+	videoID uuid.UUID
+	day     string
+}
+
+// Recorder aggregates events and flushes them to the store every interval or
+// when maxPending events are waiting, whichever comes first
+type Recorder struct {
+	store      Store
+	interval   time.Duration
+	maxPending int
+
+	events chan Event
+	flush  chan chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewRecorder(store Store, interval time.Duration, maxPending int) *Recorder {
+	r := &Recorder{
+		store:      store,
+		interval:   interval,
+		maxPending: maxPending,
+		events:     make(chan Event, maxPending),
+		flush:      make(chan chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record queues an event. It never blocks, and reports false if the queue is
+// full and the event was dropped.
+func (r *Recorder) Record(event Event) bool {
+	select {
+	case r.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Flush writes every event recorded so far and waits for the write to finish
+func (r *Recorder) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case r.flush <- flushed:
+		<-flushed
+	case <-r.done:
+	}
+}
+
+// Close flushes pending events and stops the recorder
+func (r *Recorder) Close() {
+	r.once.Do(func() {
+		r.Flush()
+		close(r.done)
+	})
+}
+
+func (r *Recorder) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	stats := map[statsKey]*database.VideoDailyStats{}
+	viewers := map[database.VideoDailyViewer]bool{}
+	pending := 0
+
+	write := func() {
+		if pending == 0 {
+			return
+		}
+		batchStats := make([]database.VideoDailyStats, 0, len(stats))
+		for _, s := range stats {
+			batchStats = append(batchStats, *s)
+		}
+		batchViewers := make([]database.VideoDailyViewer, 0, len(viewers))
+		for v := range viewers {
+			batchViewers = append(batchViewers, v)
+		}
+		if err := r.store.RecordVideoStats(batchStats, batchViewers); err != nil {
+			// analytics are best effort, a failed batch is dropped rather than retried forever
+			slog.Error("couldn't record playback events", "events", pending, "error", err)
+		}
+		stats = map[statsKey]*database.VideoDailyStats{}
+		viewers = map[database.VideoDailyViewer]bool{}
+		pending = 0
+	}
+
+	for {
+		select {
+		case event := <-r.events:
+			add(stats, viewers, event)
+			pending++
+			if pending >= r.maxPending {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		case flushed := <-r.flush:
+			// drain anything already queued so Flush covers every earlier Record
+			for draining := true; draining; {
+				select {
+				case event := <-r.events:
+					add(stats, viewers, event)
+					pending++
+				default:
+					draining = false
+				}
+			}
+			write()
+			close(flushed)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func add(stats map[statsKey]*database.VideoDailyStats, viewers map[database.VideoDailyViewer]bool, event Event) {
+	day := database.StatsDay(event.Time)
+	key := statsKey{videoID: event.VideoID, day: day}
+	s, ok := stats[key]
+	if !ok {
+		s = &database.VideoDailyStats{VideoID: event.VideoID, Day: day}
+		stats[key] = s
+	}
+
+	switch event.Type {
+	case EventPlay:
+		s.Plays++
+		if event.ViewerHash != "" {
+			viewers[database.VideoDailyViewer{VideoID: event.VideoID, Day: day, ViewerHash: event.ViewerHash}] = true
+		}
+	case EventProgress:
+		switch event.Quartile {
+		case 25:
+			s.Quartile25++
+		case 50:
+			s.Quartile50++
+		case 75:
+			s.Quartile75++
+		}
+	case EventComplete:
+		s.Completes++
+	}
+	s.WatchSeconds += event.WatchedSeconds
+}
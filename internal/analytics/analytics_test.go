@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type recordingStore struct {
+	mu      sync.Mutex
+	batches int
+	stats   []database.VideoDailyStats
+	viewers []database.VideoDailyViewer
+}
+
+func (s *recordingStore) RecordVideoStats(stats []database.VideoDailyStats, viewers []database.VideoDailyViewer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	s.stats = append(s.stats, stats...)
+	s.viewers = append(s.viewers, viewers...)
+	return nil
+}
+
+func TestRecorderAggregates(t *testing.T) {
+	store := &recordingStore{}
+	recorder := NewRecorder(store, time.Hour, 100)
+	defer recorder.Close()
+
+	videoID := uuid.New()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := []Event{
+		{VideoID: videoID, Type: EventPlay, ViewerHash: "a", Time: now},
+		{VideoID: videoID, Type: EventPlay, ViewerHash: "a", Time: now},
+		{VideoID: videoID, Type: EventPlay, ViewerHash: "b", Time: now},
+		{VideoID: videoID, Type: EventProgress, Quartile: 25, WatchedSeconds: 10, Time: now},
+		{VideoID: videoID, Type: EventProgress, Quartile: 50, WatchedSeconds: 10, Time: now},
+		{VideoID: videoID, Type: EventComplete, WatchedSeconds: 20, Time: now},
+		{VideoID: videoID, Type: EventPlay, ViewerHash: "a", Time: now.AddDate(0, 0, 1)},
+	}
+	for _, event := range events {
+		if !recorder.Record(event) {
+			t.Fatal("Record dropped an event")
+		}
+	}
+	recorder.Flush()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.batches != 1 {
+		t.Errorf("batches = %d, want 1", store.batches)
+	}
+	byDay := map[string]database.VideoDailyStats{}
+	for _, s := range store.stats {
+		byDay[s.Day] = s
+	}
+	first := byDay["2026-03-01"]
+	if first.Plays != 3 || first.Quartile25 != 1 || first.Quartile50 != 1 || first.Completes != 1 || first.WatchSeconds != 40 {
+		t.Errorf("2026-03-01 stats = %+v", first)
+	}
+	if byDay["2026-03-02"].Plays != 1 {
+		t.Errorf("2026-03-02 stats = %+v", byDay["2026-03-02"])
+	}
+	// a viewer is recorded once per day
+	if len(store.viewers) != 3 {
+		t.Errorf("viewers = %v, want a and b on the first day and a on the second", store.viewers)
+	}
+}
+
+func TestRecorderFlushesFullBatch(t *testing.T) {
+	store := &recordingStore{}
+	recorder := NewRecorder(store, time.Hour, 2)
+	defer recorder.Close()
+
+	videoID := uuid.New()
+	recorder.Record(Event{VideoID: videoID, Type: EventPlay, Time: time.Now()})
+	recorder.Record(Event{VideoID: videoID, Type: EventPlay, Time: time.Now()})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		store.mu.Lock()
+		batches := store.batches
+		store.mu.Unlock()
+		if batches == 1 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("a full batch wasn't written before the flush interval")
+}
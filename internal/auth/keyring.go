@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is a key that can sign and verify access tokens
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey is the []byte secret for HMAC or the private key for RSA/EdDSA
+	signKey any
+	// verifyKey is the []byte secret for HMAC or the public key for RSA/EdDSA
+	verifyKey any
+}
+
+// NewHMACKey wraps a shared secret as an HS256 key with the given ID. The ID
+// goes out in every token, so it must not be derived from the secret.
+func NewHMACKey(id, secret string) SigningKey {
+	return SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// ParsePrivateKeyPEM reads a PKCS#1 or PKCS#8 RSA key (RS256) or a PKCS#8
+// Ed25519 key (EdDSA)
+func ParsePrivateKeyPEM(id string, data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("key %s: no PEM block found", id)
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("key %s: unsupported PEM block %q", id, block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("key %s: %w", id, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return SigningKey{}, fmt.Errorf("key %s: RSA keys must be at least 2048 bits", id)
+		}
+		return SigningKey{ID: id, Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, signKey: key, verifyKey: key.Public()}, nil
+	default:
+		return SigningKey{}, fmt.Errorf("key %s: unsupported key type %T", id, parsed)
+	}
+}
+
+// Keyring holds every key tokens may be verified with and the one new tokens
+// are signed with. Rotating keys means adding a new key, making it the signing
+// key, and removing the old one once the tokens it signed have expired.
+type Keyring struct {
+	signing SigningKey
+	keys    map[string]SigningKey
+}
+
+func NewKeyring(signingKeyID string, keys ...SigningKey) (*Keyring, error) {
+	keyring := &Keyring{keys: map[string]SigningKey{}}
+	for _, key := range keys {
+		if _, exists := keyring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		keyring.keys[key.ID] = key
+	}
+
+	signing, ok := keyring.keys[signingKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not in the keyring", signingKeyID)
+	}
+	keyring.signing = signing
+	return keyring, nil
+}
+
+// LoadKeyring builds a keyring from an optional shared secret, with the key
+// ID secretID, and every *.pem file in keysDir, named <key id>.pem. If
+// signingKeyID is empty the only asymmetric key is used, falling back to the
+// shared secret.
+func LoadKeyring(secret, secretID, keysDir, signingKeyID string) (*Keyring, error) {
+	keys := []SigningKey{}
+	if secret != "" {
+		keys = append(keys, NewHMACKey(secretID, secret))
+	}
+
+	asymmetric := []string{}
+	if keysDir != "" {
+		paths, err := filepath.Glob(filepath.Join(keysDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			id := strings.TrimSuffix(filepath.Base(path), ".pem")
+			key, err := ParsePrivateKeyPEM(id, data)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			asymmetric = append(asymmetric, id)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT keys configured")
+	}
+	if signingKeyID == "" {
+		switch {
+		case len(asymmetric) == 1:
+			signingKeyID = asymmetric[0]
+		case len(asymmetric) > 1:
+			return nil, fmt.Errorf("several keys found in %s, choose the signing key by id", keysDir)
+		default:
+			signingKeyID = keys[0].ID
+		}
+	}
+
+	return NewKeyring(signingKeyID, keys...)
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.signKey)
+}
+
+// verifyKey finds the key a token claims to be signed with, refusing tokens
+// whose algorithm doesn't match that key
+func (k *Keyring) verifyKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		// tokens issued before key IDs existed were signed with the shared secret
+		for _, key := range k.keys {
+			if key.Method == token.Method {
+				if _, ok := key.verifyKey.([]byte); ok {
+					return key.verifyKey, nil
+				}
+			}
+		}
+		return nil, errors.New("token has no key id")
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if key.Method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not use %s", kid, token.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys in the keyring. Shared secrets are never published.
+func (k *Keyring) JWKS() JWKS {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := k.keys[id]
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     id,
+				Algorithm: key.Method.Alg(),
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     id,
+				Algorithm: key.Method.Alg(),
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func rsaKeyPEM(t *testing.T) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func ed25519KeyPEM(t *testing.T) []byte {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestMakeAndValidateJWT(t *testing.T) {
+	rsaKey, err := ParsePrivateKeyPEM("rsa-1", rsaKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := ParsePrivateKeyPEM("ed-1", ed25519KeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := NewHMACKey("hs256", "secret")
+
+	for _, key := range []SigningKey{rsaKey, edKey, hmacKey} {
+		t.Run(key.Method.Alg(), func(t *testing.T) {
+			keyring, err := NewKeyring(key.ID, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userID := uuid.New()
+			token, err := MakeJWT(userID, keyring, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT() error = %v", err)
+			}
+			got, err := ValidateJWT(token, keyring)
+			if err != nil {
+				t.Fatalf("ValidateJWT() error = %v", err)
+			}
+			if got != userID {
+				t.Errorf("ValidateJWT() = %s, want %s", got, userID)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := ParsePrivateKeyPEM("old", rsaKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ParsePrivateKeyPEM("new", ed25519KeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := NewKeyring("old", oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := MakeJWT(uuid.New(), before, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// during rotation both keys are active and only the new one signs
+	during, err := NewKeyring("new", oldKey, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(oldToken, during); err != nil {
+		t.Errorf("token from the previous key rejected during rotation: %v", err)
+	}
+	newToken, err := MakeJWT(uuid.New(), during, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] != "new" {
+		t.Errorf("new token kid = %v, want new", parsed.Header["kid"])
+	}
+
+	// once the old key is retired its tokens stop working
+	after, err := NewKeyring("new", newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(oldToken, after); err == nil {
+		t.Error("token from a retired key was accepted")
+	}
+}
+
+func TestValidateJWTRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, err := ParsePrivateKeyPEM("rsa-1", rsaKeyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := NewKeyring("rsa-1", rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an HS256 token claiming the RSA key's id must not be checked as HMAC
+	pubDER, err := x509.MarshalPKIXPublicKey(rsaKey.verifyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	token.Header["kid"] = "rsa-1"
+	forged, err := token.SignedString(pubDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(forged, keyring); err == nil {
+		t.Error("HS256 token for an RSA key id was accepted")
+	}
+}
+
+func TestHMACKeyIDIsConfigured(t *testing.T) {
+	keyring, err := LoadKeyring("secret", "2026-shared", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := MakeJWT(uuid.New(), keyring, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header carries the configured ID, nothing derived from the secret
+	token, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != "2026-shared" {
+		t.Errorf("kid = %v, want 2026-shared", kid)
+	}
+}
+
+func TestValidateJWTAcceptsLegacySecretTokens(t *testing.T) {
+	keyring, err := LoadKeyring("secret", "hs256", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID := uuid.New()
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ValidateJWT(legacy, keyring)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if got != userID {
+		t.Errorf("ValidateJWT() = %s, want %s", got, userID)
+	}
+}
+
+func TestLoadKeyringAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "2026-rsa.pem"), rsaKeyPEM(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2026-ed.pem"), ed25519KeyPEM(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyring("secret", "hs256", dir, ""); err == nil {
+		t.Error("LoadKeyring() with several keys and no signing key id succeeded")
+	}
+
+	keyring, err := LoadKeyring("secret", "hs256", dir, "2026-ed")
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+
+	jwks := keyring.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want 2 (secrets must not be published)", len(jwks.Keys))
+	}
+	if jwks.Keys[0].KeyID != "2026-ed" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[0].X == "" {
+		t.Errorf("Ed25519 JWK = %+v", jwks.Keys[0])
+	}
+	if jwks.Keys[1].KeyID != "2026-rsa" || jwks.Keys[1].Algorithm != "RS256" || jwks.Keys[1].N == "" {
+		t.Errorf("RSA JWK = %+v", jwks.Keys[1])
+	}
+}
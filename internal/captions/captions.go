@@ -0,0 +1,262 @@
+// Package captions checks WebVTT caption files and converts SRT ones to WebVTT
+package captions
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Formats a caption file can be uploaded in, as media types
+const (
+	FormatWebVTT = "text/vtt"
+	FormatSRT    = "application/x-subrip"
+)
+
+// Cue is a caption shown from Start until End
+type Cue struct {
+	ID    string
+	Start time.Duration
+	End   time.Duration
+	// Text is the cue's lines, joined with newlines
+	Text string
+}
+
+// SyntaxError reports where a caption file is malformed
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ToWebVTT checks a caption file in format and returns it as WebVTT. WebVTT
+// files are returned as they are so their styling survives.
+func ToWebVTT(data []byte, format string) ([]byte, error) {
+	if !utf8.Valid(data) {
+		return nil, errors.New("caption files must be UTF-8")
+	}
+	switch format {
+	case FormatWebVTT:
+		if _, err := ParseWebVTT(data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case FormatSRT:
+		cues, err := ParseSRT(data)
+		if err != nil {
+			return nil, err
+		}
+		return WriteWebVTT(cues), nil
+	default:
+		return nil, fmt.Errorf("unsupported caption format %q", format)
+	}
+}
+
+// FormatFromFilename guesses a caption file's format from its extension, for
+// clients that don't send a media type
+func FormatFromFilename(name string) (string, bool) {
+	switch {
+	case strings.HasSuffix(strings.ToLower(name), ".vtt"):
+		return FormatWebVTT, true
+	case strings.HasSuffix(strings.ToLower(name), ".srt"):
+		return FormatSRT, true
+	}
+	return "", false
+}
+
+// ParseWebVTT reads the cues of a WebVTT file, skipping notes, styles and
+// regions. Cues must be in order of their start times and end after they
+// start.
+func ParseWebVTT(data []byte) ([]Cue, error) {
+	blocks := splitBlocks(data)
+	if len(blocks) == 0 || !isWebVTTHeader(blocks[0].lines[0]) {
+		return nil, &SyntaxError{Line: 1, Msg: `file must start with "WEBVTT"`}
+	}
+
+	var cues []Cue
+	for _, block := range blocks[1:] {
+		first := block.lines[0]
+		if isKeyword(first, "NOTE") || isKeyword(first, "STYLE") || isKeyword(first, "REGION") {
+			continue
+		}
+
+		cue := Cue{}
+		timing, line := first, block.line
+		rest := block.lines[1:]
+		if !strings.Contains(first, "-->") {
+			if len(rest) == 0 {
+				return nil, &SyntaxError{Line: block.line, Msg: "cue has no timing"}
+			}
+			cue.ID = first
+			timing, line, rest = rest[0], line+1, rest[1:]
+		}
+		start, end, err := parseTiming(timing, webVTTTimestamp)
+		if err != nil {
+			return nil, &SyntaxError{Line: line, Msg: err.Error()}
+		}
+		if len(cues) > 0 && start < cues[len(cues)-1].Start {
+			return nil, &SyntaxError{Line: line, Msg: "cue starts before the one above it"}
+		}
+		for i, text := range rest {
+			if strings.Contains(text, "-->") {
+				return nil, &SyntaxError{Line: line + 1 + i, Msg: `cue text can't contain "-->"`}
+			}
+		}
+		cue.Start, cue.End, cue.Text = start, end, strings.Join(rest, "\n")
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 {
+		return nil, &SyntaxError{Line: 1, Msg: "file has no cues"}
+	}
+	return cues, nil
+}
+
+// ParseSRT reads the cues of a SubRip file, sorted by start time. Font and
+// positioning tags WebVTT doesn't understand are dropped from the text.
+func ParseSRT(data []byte) ([]Cue, error) {
+	var cues []Cue
+	for _, block := range splitBlocks(data) {
+		if _, err := strconv.Atoi(strings.TrimSpace(block.lines[0])); err != nil {
+			return nil, &SyntaxError{Line: block.line, Msg: fmt.Sprintf("expected a cue number, got %q", block.lines[0])}
+		}
+		if len(block.lines) < 2 {
+			return nil, &SyntaxError{Line: block.line, Msg: "cue has no timing"}
+		}
+		start, end, err := parseTiming(block.lines[1], srtTimestamp)
+		if err != nil {
+			return nil, &SyntaxError{Line: block.line + 1, Msg: err.Error()}
+		}
+
+		lines := make([]string, 0, len(block.lines)-2)
+		for _, text := range block.lines[2:] {
+			text = strings.TrimSpace(unsupportedSRTTags.ReplaceAllString(text, ""))
+			if text != "" {
+				lines = append(lines, strings.ReplaceAll(text, "-->", "->"))
+			}
+		}
+		cues = append(cues, Cue{Start: start, End: end, Text: strings.Join(lines, "\n")})
+	}
+	if len(cues) == 0 {
+		return nil, &SyntaxError{Line: 1, Msg: "file has no cues"}
+	}
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].Start < cues[j].Start })
+	return cues, nil
+}
+
+// WriteWebVTT writes cues as a WebVTT file
+func WriteWebVTT(cues []Cue) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		b.WriteString("\n")
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+		fmt.Fprintf(&b, "%s --> %s\n", formatTimestamp(cue.Start), formatTimestamp(cue.End))
+		if cue.Text != "" {
+			b.WriteString(cue.Text + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+var (
+	// webVTTTimestamp is [hh:]mm:ss.ttt, with as many hour digits as needed
+	webVTTTimestamp = regexp.MustCompile(`^(?:(\d{2,}):)?(\d{2}):(\d{2})\.(\d{3})$`)
+	// srtTimestamp is hh:mm:ss,ttt, though some tools write a dot
+	srtTimestamp = regexp.MustCompile(`^(\d{1,}):(\d{2}):(\d{2})[,.](\d{3})$`)
+	// unsupportedSRTTags are font tags and {\an8}-style positioning
+	unsupportedSRTTags = regexp.MustCompile(`(?i)</?font[^>]*>|\{\\[^}]*\}`)
+)
+
+// parseTiming reads a "start --> end" line, ignoring anything after end such
+// as WebVTT cue settings or SRT coordinates
+func parseTiming(line string, timestamp *regexp.Regexp) (time.Duration, time.Duration, error) {
+	startText, endText, ok := strings.Cut(line, "-->")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a cue timing, got %q", line)
+	}
+	endFields := strings.Fields(endText)
+	if len(endFields) == 0 {
+		return 0, 0, fmt.Errorf("cue timing %q has no end", line)
+	}
+	start, err := parseTimestamp(strings.TrimSpace(startText), timestamp)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimestamp(endFields[0], timestamp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("cue ends at %s, before it starts at %s", formatTimestamp(end), formatTimestamp(start))
+	}
+	return start, end, nil
+}
+
+func parseTimestamp(text string, timestamp *regexp.Regexp) (time.Duration, error) {
+	match := timestamp.FindStringSubmatch(text)
+	if match == nil {
+		return 0, fmt.Errorf("invalid timestamp %q", text)
+	}
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.Atoi(match[3])
+	millis, _ := strconv.Atoi(match[4])
+	if minutes > 59 || seconds > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", text)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond, nil
+}
+
+func formatTimestamp(d time.Duration) string {
+	millis := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3_600_000, millis/60_000%60, millis/1000%60, millis%1000)
+}
+
+// block is a run of non-blank lines, starting at line
+type block struct {
+	line  int
+	lines []string
+}
+
+// splitBlocks splits a file into blocks separated by blank lines, after
+// dropping a byte order mark and normalizing line endings
+func splitBlocks(data []byte) []block {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var blocks []block
+	inBlock := false
+	for i, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			blocks = append(blocks, block{line: i + 1})
+			inBlock = true
+		}
+		blocks[len(blocks)-1].lines = append(blocks[len(blocks)-1].lines, line)
+	}
+	return blocks
+}
+
+func isWebVTTHeader(line string) bool {
+	return line == "WEBVTT" || strings.HasPrefix(line, "WEBVTT ") || strings.HasPrefix(line, "WEBVTT\t")
+}
+
+// isKeyword reports whether line is keyword alone or followed by a space
+func isKeyword(line, keyword string) bool {
+	return line == keyword || strings.HasPrefix(line, keyword+" ") || strings.HasPrefix(line, keyword+"\t")
+}
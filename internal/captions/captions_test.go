@@ -0,0 +1,115 @@
+package captions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToWebVTTConvertsSRT(t *testing.T) {
+	srt := "\ufeff2\r\n00:00:05,000 --> 00:00:07,250\r\n<font color=\"red\">Second</font>\r\n\r\n" +
+		"1\r\n00:00:01,000 --> 00:00:03,500 X1:10 X2:20\r\n{\\an8}<i>First</i>\r\nline two\r\n\r\n\r\n" +
+		"3\n01:02:03,004 --> 01:02:04,000\n\n"
+
+	got, err := ToWebVTT([]byte(srt), FormatSRT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n" +
+		"00:00:01.000 --> 00:00:03.500\n<i>First</i>\nline two\n\n" +
+		"00:00:05.000 --> 00:00:07.250\nSecond\n\n" +
+		"01:02:03.004 --> 01:02:04.000\n"
+	if string(got) != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// the result is valid WebVTT
+	if _, err := ParseWebVTT(got); err != nil {
+		t.Errorf("converted file doesn't parse: %v", err)
+	}
+}
+
+func TestToWebVTTKeepsWebVTT(t *testing.T) {
+	vtt := "WEBVTT - with a title\nKind: captions\n\n" +
+		"STYLE\n::cue { color: yellow }\n\n" +
+		"NOTE a comment\n\n" +
+		"intro\n00:01.000 --> 00:02.000 align:start\nHello\n\n" +
+		"100:00:02.000 --> 100:00:03.000\n<v Boots>Bye</v>\n"
+
+	got, err := ToWebVTT([]byte(vtt), FormatWebVTT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != vtt {
+		t.Errorf("expected the file unchanged, got\n%s", got)
+	}
+
+	cues, err := ParseWebVTT([]byte(vtt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Cue{
+		{ID: "intro", Start: time.Second, End: 2 * time.Second, Text: "Hello"},
+		{Start: 100*time.Hour + 2*time.Second, End: 100*time.Hour + 3*time.Second, Text: "<v Boots>Bye</v>"},
+	}
+	if len(cues) != len(want) {
+		t.Fatalf("got %+v, want %+v", cues, want)
+	}
+	for i := range want {
+		if cues[i] != want[i] {
+			t.Errorf("cue %d = %+v, want %+v", i, cues[i], want[i])
+		}
+	}
+}
+
+func TestToWebVTTRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		line   int
+	}{
+		{"no header", FormatWebVTT, "00:01.000 --> 00:02.000\nHi\n", 1},
+		{"header prefix", FormatWebVTT, "WEBVTTX\n\n00:01.000 --> 00:02.000\nHi\n", 1},
+		{"no cues", FormatWebVTT, "WEBVTT\n\nNOTE nothing here\n", 1},
+		{"bad timestamp", FormatWebVTT, "WEBVTT\n\n00:01,000 --> 00:02.000\nHi\n", 3},
+		{"minutes out of range", FormatWebVTT, "WEBVTT\n\n00:61.000 --> 01:02.000\nHi\n", 3},
+		{"ends before start", FormatWebVTT, "WEBVTT\n\n00:02.000 --> 00:01.000\nHi\n", 3},
+		{"out of order", FormatWebVTT, "WEBVTT\n\n00:05.000 --> 00:06.000\nA\n\n00:01.000 --> 00:02.000\nB\n", 6},
+		{"id without timing", FormatWebVTT, "WEBVTT\n\nintro\n", 3},
+		{"arrow in text", FormatWebVTT, "WEBVTT\n\n00:01.000 --> 00:02.000\nA --> B\n", 4},
+		{"srt no number", FormatSRT, "00:00:01,000 --> 00:00:02,000\nHi\n", 1},
+		{"srt no timing", FormatSRT, "1\n\n2\n00:00:01,000 --> 00:00:02,000\nHi\n", 1},
+		{"srt bad timing", FormatSRT, "1\n00:00:01 --> 00:00:02\nHi\n", 2},
+		{"srt empty", FormatSRT, "\n\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToWebVTT([]byte(tt.data), tt.format)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected a syntax error, got %v", err)
+			}
+			if syntaxErr.Line != tt.line {
+				t.Errorf("error %q is on line %d, want %d", err, syntaxErr.Line, tt.line)
+			}
+		})
+	}
+
+	if _, err := ToWebVTT([]byte("WEBVTT\n\n00:01.000 --> 00:02.000\n\xff\n"), FormatWebVTT); err == nil || !strings.Contains(err.Error(), "UTF-8") {
+		t.Errorf("expected invalid UTF-8 to be rejected, got %v", err)
+	}
+	if _, err := ToWebVTT([]byte("WEBVTT\n"), "text/plain"); err == nil {
+		t.Error("expected an unsupported format to be rejected")
+	}
+}
+
+func TestFormatFromFilename(t *testing.T) {
+	for name, want := range map[string]string{"en.vtt": FormatWebVTT, "EN.SRT": FormatSRT, "en.txt": ""} {
+		got, ok := FormatFromFilename(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("FormatFromFilename(%q) = %q, %v", name, got, ok)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+// Package config loads the server's settings from an optional YAML or TOML
+// file, environment variables and command line flags, in increasing order of
+// precedence, and checks them all before the server starts.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// Every setting that can come from the environment has an env tag. Its flag
+// is the same name in lower case with dashes, e.g. DB_PATH is -db-path.
+// Fields tagged secret are redacted when the config is printed.
+type Config struct {
+	// Platform is "dev" locally, which enables /admin/reset and text logs
+	Platform        string        `yaml:"platform" env:"PLATFORM"`
+	Port            string        `yaml:"port" env:"PORT"`
+	BaseURL         string        `yaml:"base_url" env:"BASE_URL"`
+	FilepathRoot    string        `yaml:"filepath_root" env:"FILEPATH_ROOT"`
+	AssetsRoot      string        `yaml:"assets_root" env:"ASSETS_ROOT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// TrustedProxies is how many proxies in front of the server append to
+	// X-Forwarded-For, so the client is that many entries from the right
+	TrustedProxies int `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+
+	Database  Database       `yaml:"database"`
+	Auth      Auth           `yaml:"auth"`
+	Storage   Storage        `yaml:"storage"`
+	Mail      Mail           `yaml:"mail"`
+	OIDC      []OIDCProvider `yaml:"oidc_providers"`
+	RateLimit RateLimit      `yaml:"rate_limit"`
+	Log       Log            `yaml:"log"`
+	Tracing   Tracing        `yaml:"tracing"`
+	Metrics   Metrics        `yaml:"metrics"`
+	Media     Media          `yaml:"media"`
+}
+
+type Database struct {
+	Path string `yaml:"path" env:"DB_PATH"`
+}
+
+// Auth needs a shared JWT secret, a directory of asymmetric keys, or both
+type Auth struct {
+	JWTSecret string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	// JWTSecretID is the shared secret's key ID, sent in every token it signs
+	JWTSecretID  string `yaml:"jwt_secret_id" env:"JWT_SECRET_ID"`
+	KeysDir      string `yaml:"keys_dir" env:"JWT_KEYS_DIR"`
+	SigningKeyID string `yaml:"signing_key_id" env:"JWT_SIGNING_KEY_ID"`
+}
+
+const (
+	VideoStoreS3    = "s3"
+	VideoStoreLocal = "local"
+)
+
+type Storage struct {
+	// Videos is where video files are kept: "s3", or "local" to keep them
+	// with the assets, in which case the S3 section isn't needed
+	Videos string `yaml:"videos" env:"VIDEO_STORE"`
+	S3     S3     `yaml:"s3"`
+	// StagingDir is where a local video store keeps direct uploads until
+	// they're processed. It must be outside the served assets_root and
+	// filepath_root.
+	StagingDir string `yaml:"staging_dir" env:"STAGING_DIR"`
+	// UploadSecret signs a local video store's direct upload URLs, so they
+	// keep working across restarts
+	UploadSecret string `yaml:"upload_secret" env:"UPLOAD_SECRET" secret:"true"`
+	// DirectUploadMaxBytes caps the size of a video uploaded straight to the
+	// store with a presigned URL
+	DirectUploadMaxBytes int `yaml:"direct_upload_max_bytes" env:"DIRECT_UPLOAD_MAX_BYTES"`
+}
+
+type S3 struct {
+	Bucket string `yaml:"bucket" env:"S3_BUCKET"`
+	// StagingBucket keeps direct uploads until they're processed. It must
+	// be a different bucket from the one the distribution serves.
+	StagingBucket string `yaml:"staging_bucket" env:"S3_STAGING_BUCKET"`
+	Region        string `yaml:"region" env:"S3_REGION"`
+	Distribution  string `yaml:"distribution" env:"S3_CF_DISTRO"`
+}
+
+type Mail struct {
+	// Kind is "log", "file" or "smtp"
+	Kind         string `yaml:"kind" env:"MAILER"`
+	From         string `yaml:"from" env:"MAIL_FROM"`
+	Dir          string `yaml:"dir" env:"MAIL_DIR"`
+	SMTPHost     string `yaml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     string `yaml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+// OIDCProvider is a single sign-on provider. In the environment they're
+// listed in OIDC_PROVIDERS and configured with OIDC_<NAME>_ISSUER,
+// _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and _SCOPES.
+type OIDCProvider struct {
+	Name         string   `yaml:"name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" secret:"true"`
+	RedirectURL  string   `yaml:"redirect_url,omitempty"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+}
+
+type RateLimit struct {
+	// Rules are "<pattern>=<ip|user|route>:<count>/<period>,...;..." and
+	// replace the built in limits when set
+	Rules string `yaml:"rules" env:"RATE_LIMITS"`
+	// Store is "memory" or "database"
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE"`
+}
+
+type Log struct {
+	// Format is "json" or "text", text by default on the dev platform
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	Level  string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+type Tracing struct {
+	// Exporter is "otlp", "console" or "none"
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+}
+
+type Metrics struct {
+	// Token is the bearer token scrapers send to /metrics, which is off
+	// without one
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+// Media bounds the ffmpeg and ffprobe runs
+type Media struct {
+	MaxConcurrent  int           `yaml:"max_concurrent" env:"MEDIA_MAX_CONCURRENT"`
+	ProbeTimeout   time.Duration `yaml:"probe_timeout" env:"MEDIA_PROBE_TIMEOUT"`
+	ProcessTimeout time.Duration `yaml:"process_timeout" env:"MEDIA_PROCESS_TIMEOUT"`
+	// AspectBuckets are "name=W:H,..." and replace the built in folders
+	// videos are filed under by shape when set
+	AspectBuckets string `yaml:"aspect_buckets" env:"MEDIA_ASPECT_BUCKETS"`
+}
+
+// Default returns the settings used when nothing else sets them
+func Default() Config {
+	return Config{
+		ShutdownTimeout: 30 * time.Second,
+		Auth:            Auth{JWTSecretID: "hs256"},
+		Storage:         Storage{Videos: VideoStoreS3, DirectUploadMaxBytes: 5 << 30},
+		Mail:            Mail{Kind: "log"},
+		RateLimit:       RateLimit{Store: "memory"},
+		Log:             Log{Level: "info"},
+		Tracing:         Tracing{Exporter: "none"},
+		Media: Media{
+			MaxConcurrent:  runtime.NumCPU(),
+			ProbeTimeout:   30 * time.Second,
+			ProcessTimeout: 10 * time.Minute,
+		},
+	}
+}
+
+// fillDerived sets the defaults that depend on other settings
+func (c *Config) fillDerived() {
+	if _, err := strconv.Atoi(c.Port); c.BaseURL == "" && err == nil {
+		c.BaseURL = "http://localhost:" + c.Port
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "json"
+		if c.Platform == "dev" {
+			c.Log.Format = "text"
+		}
+	}
+	for i, provider := range c.OIDC {
+		if provider.RedirectURL == "" && c.BaseURL != "" {
+			c.OIDC[i].RedirectURL = strings.TrimSuffix(c.BaseURL, "/") + "/api/oidc/" + provider.Name + "/callback"
+		}
+	}
+}
+
+// Error lists every problem found with a config
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid config: " + e.Problems[0]
+	}
+	return fmt.Sprintf("invalid config, %d problems:\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// Validate checks every setting, returning an *Error listing all the
+// problems found
+func (c Config) Validate() error {
+	var problems []string
+	problem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	required := func(value, key, env string) {
+		if value == "" {
+			problem("%s (%s) must be set", key, env)
+		}
+	}
+	oneOf := func(value, key, env string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			problem("%s (%s) must be one of %s, got %q", key, env, strings.Join(allowed, ", "), value)
+		}
+	}
+
+	required(c.Platform, "platform", "PLATFORM")
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problem("port (PORT) must be a port number, got %q", c.Port)
+	}
+	if u, err := url.Parse(c.BaseURL); c.BaseURL != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		problem("base_url (BASE_URL) must be an absolute URL, got %q", c.BaseURL)
+	}
+	required(c.FilepathRoot, "filepath_root", "FILEPATH_ROOT")
+	required(c.AssetsRoot, "assets_root", "ASSETS_ROOT")
+	if c.ShutdownTimeout <= 0 {
+		problem("shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive, got %s", c.ShutdownTimeout)
+	}
+	if c.TrustedProxies < 0 {
+		problem("trusted_proxies (TRUSTED_PROXIES) can't be negative, got %d", c.TrustedProxies)
+	}
+
+	required(c.Database.Path, "database.path", "DB_PATH")
+
+	if c.Auth.JWTSecret == "" && c.Auth.KeysDir == "" {
+		problem("auth.jwt_secret (JWT_SECRET) or auth.keys_dir (JWT_KEYS_DIR) must be set")
+	}
+	if c.Auth.JWTSecret != "" {
+		required(c.Auth.JWTSecretID, "auth.jwt_secret_id", "JWT_SECRET_ID")
+	}
+
+	oneOf(c.Storage.Videos, "storage.videos", "VIDEO_STORE", VideoStoreS3, VideoStoreLocal)
+	if c.Storage.Videos == VideoStoreS3 {
+		required(c.Storage.S3.Bucket, "storage.s3.bucket", "S3_BUCKET")
+		required(c.Storage.S3.StagingBucket, "storage.s3.staging_bucket", "S3_STAGING_BUCKET")
+		if c.Storage.S3.StagingBucket != "" && c.Storage.S3.StagingBucket == c.Storage.S3.Bucket {
+			problem("storage.s3.staging_bucket (S3_STAGING_BUCKET) must not be the bucket the distribution serves")
+		}
+		required(c.Storage.S3.Region, "storage.s3.region", "S3_REGION")
+		required(c.Storage.S3.Distribution, "storage.s3.distribution", "S3_CF_DISTRO")
+	}
+	if c.Storage.Videos == VideoStoreLocal {
+		required(c.Storage.StagingDir, "storage.staging_dir", "STAGING_DIR")
+		required(c.Storage.UploadSecret, "storage.upload_secret", "UPLOAD_SECRET")
+		for _, served := range []string{c.AssetsRoot, c.FilepathRoot} {
+			if c.Storage.StagingDir != "" && served != "" && isWithin(c.Storage.StagingDir, served) {
+				problem("storage.staging_dir (STAGING_DIR) must not be inside %q, which is served, got %q", served, c.Storage.StagingDir)
+			}
+		}
+	}
+	if c.Storage.DirectUploadMaxBytes <= 0 {
+		problem("storage.direct_upload_max_bytes (DIRECT_UPLOAD_MAX_BYTES) must be positive, got %d", c.Storage.DirectUploadMaxBytes)
+	}
+
+	oneOf(c.Mail.Kind, "mail.kind", "MAILER", "log", "file", "smtp")
+	switch c.Mail.Kind {
+	case "file":
+		required(c.Mail.Dir, "mail.dir", "MAIL_DIR")
+	case "smtp":
+		required(c.Mail.SMTPHost, "mail.smtp_host", "SMTP_HOST")
+		required(c.Mail.From, "mail.from", "MAIL_FROM")
+	}
+
+	names := map[string]bool{}
+	for i, provider := range c.OIDC {
+		key := fmt.Sprintf("oidc_providers[%d]", i)
+		if provider.Name == "" {
+			problem("%s.name must be set", key)
+		} else if names[provider.Name] {
+			problem("%s.name %q is used by another provider", key, provider.Name)
+		}
+		names[provider.Name] = true
+		if provider.Issuer == "" || provider.ClientID == "" {
+			problem("%s (%s) needs an issuer and client_id", key, provider.Name)
+		}
+	}
+
+	oneOf(c.RateLimit.Store, "rate_limit.store", "RATE_LIMIT_STORE", "memory", "database")
+	oneOf(c.Log.Format, "log.format", "LOG_FORMAT", "json", "text")
+	oneOf(strings.ToLower(c.Log.Level), "log.level", "LOG_LEVEL", "debug", "info", "warn", "error")
+	oneOf(c.Tracing.Exporter, "tracing.exporter", "OTEL_TRACES_EXPORTER", "otlp", "console", "none")
+
+	if c.Media.MaxConcurrent < 1 {
+		problem("media.max_concurrent (MEDIA_MAX_CONCURRENT) must be at least 1, got %d", c.Media.MaxConcurrent)
+	}
+	if c.Media.ProbeTimeout <= 0 {
+		problem("media.probe_timeout (MEDIA_PROBE_TIMEOUT) must be positive, got %s", c.Media.ProbeTimeout)
+	}
+	if c.Media.ProcessTimeout <= 0 {
+		problem("media.process_timeout (MEDIA_PROCESS_TIMEOUT) must be positive, got %s", c.Media.ProcessTimeout)
+	}
+	if c.Media.AspectBuckets != "" {
+		if _, err := media.ParseBuckets(c.Media.AspectBuckets); err != nil {
+			problem("media.aspect_buckets (MEDIA_ASPECT_BUCKETS): %v", err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+// isWithin reports whether path is dir or inside it
+func isWithin(path, dir string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the config with secrets replaced, for printing
+func (c Config) Redacted() Config {
+	c.OIDC = slices.Clone(c.OIDC)
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if v.Type().Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+				field.SetString(redacted)
+				continue
+			}
+			redact(field)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			redact(v.Index(i))
+		}
+	}
+}
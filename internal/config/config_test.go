@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mapEnv is a lookupEnv backed by a map
+func mapEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const baseYAML = `
+platform: dev
+port: "8091"
+filepath_root: ./app
+assets_root: ./assets
+database:
+  path: ./file.db
+auth:
+  jwt_secret: file-secret
+storage:
+  videos: local
+  staging_dir: ./staging
+  upload_secret: upload-secret
+`
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "tubely.yaml", baseYAML)
+	env := map[string]string{
+		FileEnv:            path,
+		"DB_PATH":          "./env.db",
+		"PORT":             "9000",
+		"SHUTDOWN_TIMEOUT": "5s",
+		// blank values in .env leave the file's setting alone
+		"ASSETS_ROOT": "",
+	}
+
+	c, err := Load("tubely", []string{"-port", "9100"}, mapEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Database.Path != "./env.db" {
+		t.Errorf("expected the environment to override the file, got %q", c.Database.Path)
+	}
+	if c.Port != "9100" || c.BaseURL != "http://localhost:9100" {
+		t.Errorf("expected the flag to override the environment, got port %q and base URL %q", c.Port, c.BaseURL)
+	}
+	if c.AssetsRoot != "./assets" {
+		t.Errorf("expected a blank variable to be ignored, got %q", c.AssetsRoot)
+	}
+	if c.ShutdownTimeout != 5*time.Second || c.Mail.Kind != "log" || c.Log.Format != "text" {
+		t.Errorf("unexpected defaults: %+v", c)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "tubely.toml", `
+platform = "prod"
+port = "8091"
+filepath_root = "./app"
+assets_root = "./assets"
+shutdown_timeout = "10s"
+
+[database]
+path = "./tubely.db"
+
+[auth]
+jwt_secret = "secret"
+
+[storage.s3]
+bucket = "videos"
+staging_bucket = "videos-staging"
+region = "us-east-2"
+distribution = "cdn.example.com"
+
+[[oidc_providers]]
+name = "corp"
+issuer = "https://sso.example.com"
+client_id = "tubely"
+`)
+
+	c, err := Load("tubely", []string{"-config", path}, mapEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Storage.Videos != VideoStoreS3 || c.Storage.S3.Bucket != "videos" || c.ShutdownTimeout != 10*time.Second {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if c.Log.Format != "json" {
+		t.Errorf("expected JSON logs outside dev, got %q", c.Log.Format)
+	}
+	if len(c.OIDC) != 1 || c.OIDC[0].RedirectURL != "http://localhost:8091/api/oidc/corp/callback" {
+		t.Errorf("expected the provider's redirect URL to be derived, got %+v", c.OIDC)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	env := map[string]string{
+		"PORT":             "eighty",
+		"SHUTDOWN_TIMEOUT": "soon",
+		"MAILER":           "pigeon",
+	}
+
+	_, err := Load("tubely", nil, mapEnv(env))
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+	for _, want := range []string{"SHUTDOWN_TIMEOUT", "PLATFORM", "PORT", "DB_PATH", "JWT_SECRET", "S3_BUCKET", "MAILER"} {
+		found := false
+		for _, problem := range configErr.Problems {
+			found = found || strings.Contains(problem, want)
+		}
+		if !found {
+			t.Errorf("expected a problem mentioning %s, got:\n%v", want, err)
+		}
+	}
+
+	// S3 isn't needed when videos are kept locally
+	path := writeFile(t, "tubely.yaml", baseYAML)
+	if _, err := Load("tubely", []string{"-config", path}, mapEnv(nil)); err != nil {
+		t.Fatalf("expected a local video store to need no S3 settings, got %v", err)
+	}
+}
+
+func TestLoadKeepsStagedUploadsUnserved(t *testing.T) {
+	local := writeFile(t, "tubely.yaml", strings.Replace(baseYAML, "./staging", "./assets/staging", 1))
+	_, err := Load("tubely", []string{"-config", local}, mapEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "STAGING_DIR") {
+		t.Errorf("expected a staging dir under the assets to be refused, got %v", err)
+	}
+
+	s3 := writeFile(t, "tubely.yaml", strings.Replace(baseYAML, "videos: local", "videos: s3", 1)+`  s3:
+    bucket: videos
+    staging_bucket: videos
+    region: us-east-2
+    distribution: cdn.example.com
+`)
+	_, err = Load("tubely", []string{"-config", s3}, mapEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "S3_STAGING_BUCKET") {
+		t.Errorf("expected staging in the served bucket to be refused, got %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	path := writeFile(t, "tubely.yaml", baseYAML+"databse:\n  path: typo.db\n")
+	_, err := Load("tubely", []string{"-config", path}, mapEnv(nil))
+	if err == nil || !strings.Contains(err.Error(), "databse") {
+		t.Fatalf("expected the misspelled key to be reported, got %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Default()
+	c.Auth.JWTSecret = "jwt"
+	c.Mail.SMTPUsername = "user"
+	c.OIDC = []OIDCProvider{{Name: "corp", ClientSecret: "oidc"}}
+
+	r := c.Redacted()
+	if r.Auth.JWTSecret != redacted || r.OIDC[0].ClientSecret != redacted || r.Mail.SMTPPassword != "" {
+		t.Errorf("expected secrets to be redacted, got %+v", r)
+	}
+	if r.Mail.SMTPUsername != "user" {
+		t.Errorf("expected other settings to be kept, got %q", r.Mail.SMTPUsername)
+	}
+	if c.Auth.JWTSecret != "jwt" || c.OIDC[0].ClientSecret != "oidc" {
+		t.Error("expected the original config to be left alone")
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the config file when the -config flag isn't given
+const FileEnv = "TUBELY_CONFIG"
+
+// Load builds the config from the defaults, then the config file, then the
+// environment, then the flags in args, and validates the result. lookupEnv is
+// usually os.LookupEnv. Every problem is reported at once in an *Error.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", "", "YAML or TOML config file, overrides "+FileEnv)
+	flagValues := map[string]string{}
+	for _, s := range settings(&Config{}) {
+		env := s.env
+		fs.Func(flagName(env), "overrides "+env, func(value string) error {
+			flagValues[env] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	if *path == "" {
+		*path, _ = lookupEnv(FileEnv)
+	}
+
+	c := Default()
+	var problems []string
+	if *path != "" {
+		if err := loadFile(*path, &c); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// empty variables count as unset, so a blank line in .env keeps the default
+	for _, s := range settings(&c) {
+		value, ok := lookupEnv(s.env)
+		ok = ok && value != ""
+		if flagValue, set := flagValues[s.env]; set {
+			value, ok = flagValue, true
+		}
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+		}
+	}
+	if providers, ok := oidcFromEnv(lookupEnv); ok {
+		c.OIDC = providers
+	}
+
+	c.fillDerived()
+	if err := c.Validate(); err != nil {
+		var configErr *Error
+		if errors.As(err, &configErr) {
+			problems = append(problems, configErr.Problems...)
+		}
+	}
+	if len(problems) > 0 {
+		return Config{}, &Error{Problems: problems}
+	}
+	return c, nil
+}
+
+// loadFile reads a config file, choosing the format by its extension.
+// Unknown keys are errors so a typo doesn't silently leave a default.
+func loadFile(path string, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		// TOML is decoded generically and passed through the YAML decoder,
+		// so the struct only needs one set of key names
+		var generic map[string]any
+		if _, err := toml.Decode(string(data), &generic); err != nil {
+			return fmt.Errorf("couldn't parse %s: %w", path, err)
+		}
+		data, err = yaml.Marshal(generic)
+		if err != nil {
+			return fmt.Errorf("couldn't parse %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("couldn't parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// setting is a config field that can be set from the environment or a flag
+type setting struct {
+	env   string
+	value reflect.Value
+}
+
+func (s setting) set(raw string) error {
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("want true or false, got %q", raw)
+		}
+		s.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("want a whole number, got %q", raw)
+		}
+		s.value.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("want a duration like 30s, got %q", raw)
+		}
+		s.value.SetInt(int64(d))
+	default:
+		return fmt.Errorf("can't be set from text")
+	}
+	return nil
+}
+
+// settings finds every field of c with an env tag
+func settings(c *Config) []setting {
+	var found []setting
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if env := field.Tag.Get("env"); env != "" {
+				found = append(found, setting{env: env, value: v.Field(i)})
+			} else if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem())
+	return found
+}
+
+func flagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
+
+// oidcFromEnv reads the providers listed in OIDC_PROVIDERS, if it's set
+func oidcFromEnv(lookupEnv func(string) (string, bool)) ([]OIDCProvider, bool) {
+	names, ok := lookupEnv("OIDC_PROVIDERS")
+	if !ok || names == "" {
+		return nil, false
+	}
+	get := func(key string) string {
+		value, _ := lookupEnv(key)
+		return value
+	}
+
+	providers := []OIDCProvider{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       get(prefix + "ISSUER"),
+			ClientID:     get(prefix + "CLIENT_ID"),
+			ClientSecret: get(prefix + "CLIENT_SECRET"),
+			RedirectURL:  get(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(get(prefix + "SCOPES")),
+		})
+	}
+	return providers, true
+}
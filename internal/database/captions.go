@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Caption is a video's WebVTT caption track in one language
+type Caption struct {
+	VideoID uuid.UUID `json:"-"`
+	// Language is a BCP 47 tag like "en" or "pt-BR"
+	Language string `json:"language"`
+	Label    string `json:"label"`
+	URL      string `json:"url"`
+}
+
+// PutCaption saves a video's caption track for its language, returning the
+// one it replaces, if any, so the caller can delete its file
+func (c Client) PutCaption(caption Caption) (*Caption, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	previous, err := scanCaption(tx.QueryRow(
+		"SELECT video_id, language, label, url FROM captions WHERE video_id = ? AND language = ?",
+		caption.VideoID, caption.Language,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+	INSERT INTO captions (video_id, language, created_at, updated_at, label, url)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id, language) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		label = excluded.label,
+		url = excluded.url
+	`, caption.VideoID, caption.Language, now, now, caption.Label, caption.URL)
+	if err != nil {
+		return nil, err
+	}
+	return previous, tx.Commit()
+}
+
+// GetCaption returns a video's caption track in language, or nil if it has none
+func (c Client) GetCaption(videoID uuid.UUID, language string) (*Caption, error) {
+	return scanCaption(c.db.QueryRow(
+		"SELECT video_id, language, label, url FROM captions WHERE video_id = ? AND language = ?",
+		videoID, language,
+	))
+}
+
+// DeleteCaption removes a video's caption track in language
+func (c Client) DeleteCaption(videoID uuid.UUID, language string) error {
+	_, err := c.db.Exec("DELETE FROM captions WHERE video_id = ? AND language = ?", videoID, language)
+	return err
+}
+
+func scanCaption(row rowScanner) (*Caption, error) {
+	var caption Caption
+	err := row.Scan(&caption.VideoID, &caption.Language, &caption.Label, &caption.URL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &caption, nil
+}
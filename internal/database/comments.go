@@ -0,0 +1,213 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Comment struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	AuthorName string    `json:"author_name"`
+	ReplyCount int       `json:"reply_count"`
+	CreateCommentParams
+}
+
+type CreateCommentParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	// ParentID is set on replies, which are always to a top-level comment
+	ParentID *uuid.UUID `json:"parent_id"`
+	Body     string     `json:"body"`
+}
+
+// CommentCursor is the position of the last comment on a page
+type CommentCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// ListCommentsParams selects a page of top-level comments, newest first, or
+// of the replies to ParentID, oldest first
+type ListCommentsParams struct {
+	VideoID  uuid.UUID
+	ParentID *uuid.UUID
+	After    *CommentCursor
+	Limit    int
+}
+
+const commentColumns = `
+		c.id,
+		c.created_at,
+		c.updated_at,
+		COALESCE(u.display_name, ''),
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id),
+		c.video_id,
+		c.user_id,
+		c.parent_id,
+		c.body
+`
+
+func scanComment(row rowScanner) (Comment, error) {
+	var comment Comment
+	err := row.Scan(
+		&comment.ID,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+		&comment.AuthorName,
+		&comment.ReplyCount,
+		&comment.VideoID,
+		&comment.UserID,
+		&comment.ParentID,
+		&comment.Body,
+	)
+	return comment, err
+}
+
+// CreateComment adds a comment and bumps the video's comment count
+func (c Client) CreateComment(params CreateCommentParams) (Comment, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Comment{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	now := newUpdatedAt()
+	_, err = tx.Exec(`
+	INSERT INTO comments (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		parent_id,
+		body
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, now, now, params.VideoID, params.UserID, params.ParentID, params.Body)
+	if err != nil {
+		return Comment{}, err
+	}
+	_, err = tx.Exec("UPDATE videos SET comment_count = comment_count + 1 WHERE id = ?", params.VideoID)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Comment{}, err
+	}
+	return c.GetComment(id)
+}
+
+func (c Client) GetComment(id uuid.UUID) (Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	LEFT JOIN users u ON u.id = c.user_id
+	WHERE c.id = ?
+	`
+	comment, err := scanComment(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, nil
+		}
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+func (c Client) ListComments(params ListCommentsParams) ([]Comment, error) {
+	conditions := []string{"c.video_id = ?"}
+	args := []any{params.VideoID}
+
+	// top-level comments read newest first, replies read like a conversation
+	direction, compare := "DESC", "<"
+	if params.ParentID == nil {
+		conditions = append(conditions, "c.parent_id IS NULL")
+	} else {
+		conditions = append(conditions, "c.parent_id = ?")
+		args = append(args, *params.ParentID)
+		direction, compare = "ASC", ">"
+	}
+	if params.After != nil {
+		// julianday() compares timestamps by value, the id breaks ties
+		conditions = append(conditions, `(
+			julianday(c.created_at) `+compare+` julianday(?)
+			OR (julianday(c.created_at) = julianday(?) AND c.id `+compare+` ?)
+		)`)
+		createdAt := params.After.CreatedAt.UTC()
+		args = append(args, createdAt, createdAt, params.After.ID)
+	}
+	args = append(args, params.Limit)
+
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	LEFT JOIN users u ON u.id = c.user_id
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY julianday(c.created_at) ` + direction + `, c.id ` + direction + `
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return comments, rows.Err()
+}
+
+func (c Client) UpdateCommentBody(id uuid.UUID, body string) (Comment, error) {
+	_, err := c.db.Exec("UPDATE comments SET body = ?, updated_at = ? WHERE id = ?", body, newUpdatedAt(), id)
+	if err != nil {
+		return Comment{}, err
+	}
+	return c.GetComment(id)
+}
+
+// DeleteComment removes a comment and its replies, and takes them off the
+// video's comment count
+func (c Client) DeleteComment(comment Comment) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	replies, err := tx.Exec("DELETE FROM comments WHERE parent_id = ?", comment.ID)
+	if err != nil {
+		return err
+	}
+	deleted, err := replies.RowsAffected()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM comments WHERE id = ?", comment.ID)
+	if err != nil {
+		return err
+	}
+	self, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	deleted += self
+
+	_, err = tx.Exec("UPDATE videos SET comment_count = comment_count - ? WHERE id = ?", deleted, comment.VideoID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
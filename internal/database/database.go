@@ -1,23 +1,64 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sync/atomic"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db       contextDB
+	observer *atomic.Pointer[QueryObserver]
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
-	if err != nil {
-		return Client{}, err
+// WithContext returns a copy of the client whose statements run with ctx, so
+// they're cancelled with it and traced as part of the request it belongs to
+func (c Client) WithContext(ctx context.Context) Client {
+	c.db.ctx = ctx
+	return c
+}
+
+// contextDB runs the plain database/sql methods with the client's context
+type contextDB struct {
+	*sql.DB
+	ctx context.Context
+}
+
+func (db contextDB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	return db.ctx
+}
+
+func (db contextDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(db.context(), query, args...)
+}
+
+func (db contextDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(db.context(), query, args...)
+}
+
+func (db contextDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(db.context(), query, args...)
+}
+
+func (db contextDB) Begin() (*sql.Tx, error) {
+	return db.BeginTx(db.context(), nil)
+}
+
+func NewClient(pathToDB string) (Client, error) {
+	observer := &atomic.Pointer[QueryObserver]{}
+	db := sql.OpenDB(observedConnector{
+		dsn:      pathToDB,
+		driver:   &sqlite3.SQLiteDriver{},
+		observer: observer,
+	})
+	c := Client{db: contextDB{DB: db}, observer: observer}
+	err := c.autoMigrate()
 	if err != nil {
 		return Client{}, err
 	}
@@ -72,16 +113,472 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		PRIMARY KEY(provider, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	oidcStateTable := `
+	CREATE TABLE IF NOT EXISTS oidc_states (
+		state TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		provider TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(oidcStateTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "email_verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "display_name", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	userTokenTable := `
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTokenTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "failed_login_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("users", "locked_until", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	rateLimitBucketTable := `
+	CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+		key TEXT PRIMARY KEY,
+		tokens REAL NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(rateLimitBucketTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "visibility", "TEXT NOT NULL DEFAULT 'private'")
+	if err != nil {
+		return err
+	}
+
+	tagTable := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE NOT NULL
+	);
+	`
+	_, err = c.db.Exec(tagTable)
+	if err != nil {
+		return err
+	}
+
+	videoTagTable := `
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id INTEGER NOT NULL,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(tag_id) REFERENCES tags(id)
+	);
+	`
+	_, err = c.db.Exec(videoTagTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "category", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'private',
+		user_id TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	playlistVideoTable := `
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playlistVideoTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "published_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		token_hash TEXT UNIQUE NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		max_views INTEGER,
+		password_hash TEXT NOT NULL DEFAULT '',
+		view_count INTEGER NOT NULL DEFAULT 0,
+		last_viewed_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(shareLinkTable)
+	if err != nil {
+		return err
+	}
+
+	videoDailyStatsTable := `
+	CREATE TABLE IF NOT EXISTS video_daily_stats (
+		video_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		plays INTEGER NOT NULL DEFAULT 0,
+		quartile_25 INTEGER NOT NULL DEFAULT 0,
+		quartile_50 INTEGER NOT NULL DEFAULT 0,
+		quartile_75 INTEGER NOT NULL DEFAULT 0,
+		completes INTEGER NOT NULL DEFAULT 0,
+		watch_seconds REAL NOT NULL DEFAULT 0,
+		PRIMARY KEY(video_id, day),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoDailyStatsTable)
+	if err != nil {
+		return err
+	}
+
+	videoDailyViewerTable := `
+	CREATE TABLE IF NOT EXISTS video_daily_viewers (
+		video_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		viewer_hash TEXT NOT NULL,
+		PRIMARY KEY(video_id, day, viewer_hash),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(videoDailyViewerTable)
+	if err != nil {
+		return err
+	}
+
+	// like and comment counts are kept on the video so listings don't have to count
+	err = c.addColumnIfMissing("videos", "like_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "comment_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// the display aspect ratio, reduced like "4:3", and orientation found when the video was uploaded
+	err = c.addColumnIfMissing("videos", "aspect_ratio", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfMissing("videos", "orientation", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	// processed video files, shared by every video uploaded with the same content
+	storedObjectTable := `
+	CREATE TABLE IF NOT EXISTS stored_objects (
+		key TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		source_sha256 TEXT UNIQUE NOT NULL,
+		sha256 TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		aspect_ratio TEXT,
+		orientation TEXT,
+		ref_count INTEGER NOT NULL
+	);
+	`
+	_, err = c.db.Exec(storedObjectTable)
+	if err != nil {
+		return err
+	}
+
+	// video files clients are uploading straight to the video store, kept
+	// after their video is deleted until the file is cleaned up
+	stagedUploadTable := `
+	CREATE TABLE IF NOT EXISTS staged_uploads (
+		video_id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		upload_id TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(stagedUploadTable)
+	if err != nil {
+		return err
+	}
+
+	// caption tracks, one per video and language
+	captionTable := `
+	CREATE TABLE IF NOT EXISTS captions (
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		label TEXT NOT NULL,
+		url TEXT NOT NULL,
+		PRIMARY KEY (video_id, language),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(captionTable)
+	if err != nil {
+		return err
+	}
+
+	videoLikeTable := `
+	CREATE TABLE IF NOT EXISTS video_likes (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoLikeTable)
+	if err != nil {
+		return err
+	}
+
+	commentTable := `
+	CREATE TABLE IF NOT EXISTS comments (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		parent_id TEXT,
+		body TEXT NOT NULL,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(parent_id) REFERENCES comments(id)
+	);
+	`
+	_, err = c.db.Exec(commentTable)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS comments_video_id ON comments(video_id, parent_id, created_at)")
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+
+	webhookDeliveryTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_attempt_at TIMESTAMP,
+		response_status INTEGER,
+		last_error TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+	);
+	`
+	_, err = c.db.Exec(webhookDeliveryTable)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at)")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumnIfMissing adds a column to a table created by an older version of the schema
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// Ping checks the database can be reached
+func (c Client) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// Close waits for running statements to finish and closes the database
+func (c Client) Close() error {
+	return c.db.Close()
+}
+
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM rate_limit_buckets"); err != nil {
+		return fmt.Errorf("failed to reset table rate_limit_buckets: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_states"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_states: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM comments"); err != nil {
+		return fmt.Errorf("failed to reset table comments: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_likes"); err != nil {
+		return fmt.Errorf("failed to reset table video_likes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_daily_viewers"); err != nil {
+		return fmt.Errorf("failed to reset table video_daily_viewers: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_daily_stats"); err != nil {
+		return fmt.Errorf("failed to reset table video_daily_stats: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM captions"); err != nil {
+		return fmt.Errorf("failed to reset table captions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM staged_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table staged_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM stored_objects"); err != nil {
+		return fmt.Errorf("failed to reset table stored_objects: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// QueryObserver is told how long each statement took and whether it failed.
+// query names the function in this package that ran it, e.g.
+// "Client.GetVideo".
+type QueryObserver func(query string, duration time.Duration, err error)
+
+// ObserveQueries sets the observer every statement is reported to. It
+// applies to every copy of the client.
+func (c Client) ObserveQueries(observer QueryObserver) {
+	c.observer.Store(&observer)
+}
+
+// observedConnector opens connections that report each statement to the
+// client's observer and trace it, so transactions are covered as well as
+// c.db calls
+type observedConnector struct {
+	dsn      string
+	driver   driver.Driver
+	observer *atomic.Pointer[QueryObserver]
+}
+
+func (c observedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &observedConn{Conn: conn, observer: c.observer}, nil
+}
+
+func (c observedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+var tracer = otel.Tracer(reflect.TypeOf(Client{}).PkgPath())
+
+type observedConn struct {
+	driver.Conn
+	observer *atomic.Pointer[QueryObserver]
+	// txCtx is the context of the transaction the connection is in, since
+	// database/sql runs a transaction's statements without one
+	txCtx context.Context
+}
+
+// observe starts timing a statement, and tracing it if it's part of a traced
+// request. Call the returned function with the statement's error once done.
+func (c *observedConn) observe(ctx context.Context, query string) func(err error) {
+	if c.txCtx != nil && !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = c.txCtx
+	}
+	observer := c.observer.Load()
+	traced := trace.SpanContextFromContext(ctx).IsValid()
+	if observer == nil && !traced {
+		return func(error) {}
+	}
+
+	name := callerName()
+	start := time.Now()
+	var span trace.Span
+	if traced {
+		_, span = tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemSqlite, semconv.DBQueryText(query)),
+		)
+	}
+	return func(err error) {
+		if observer != nil {
+			(*observer)(name, time.Since(start), err)
+		}
+		if span != nil {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	}
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done := c.observe(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	done(err)
+	return result, err
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	done := c.observe(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	done(err)
+	return rows, err
+}
+
+func (c *observedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *observedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.txCtx = ctx
+	return observedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *observedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+type observedTx struct {
+	driver.Tx
+	conn *observedConn
+}
+
+func (tx observedTx) Commit() error {
+	tx.conn.txCtx = nil
+	return tx.Tx.Commit()
+}
+
+func (tx observedTx) Rollback() error {
+	tx.conn.txCtx = nil
+	return tx.Tx.Rollback()
+}
+
+// packagePrefix is how function names in this package start in a stack trace
+var packagePrefix = reflect.TypeOf(Client{}).PkgPath() + "."
+
+// callerNames caches what each return address seen on a statement's stack
+// resolves to: a function in this package, or "" for a frame to skip. Stacks
+// are only symbolized the first time a call site runs.
+var callerNames sync.Map
+
+var receiverReplacer = strings.NewReplacer("(*", "", ")", "")
+
+// callerName finds the function in this package that ran the statement being
+// observed, skipping database/sql and the observer itself
+func callerName() string {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	for _, pc := range pcs[:n] {
+		name, ok := callerNames.Load(pc)
+		if !ok {
+			name, _ = callerNames.LoadOrStore(pc, packageFunction(pc))
+		}
+		if name != "" {
+			return name.(string)
+		}
+	}
+	return "unknown"
+}
+
+// packageFunction names the function in this package a return address is
+// in, or returns "" if it's elsewhere or in the observer. Calls inlined at
+// the address are checked innermost first.
+func packageFunction(pc uintptr) string {
+	frames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		frame, more := frames.Next()
+		name, ok := strings.CutPrefix(frame.Function, packagePrefix)
+		name = receiverReplacer.Replace(name)
+		if ok && !strings.HasPrefix(name, "observed") && !strings.HasPrefix(name, "contextDB") {
+			// closures are reported as part of the function they're in
+			name, _, _ = strings.Cut(name, ".func")
+			return name
+		}
+		if !more {
+			return ""
+		}
+	}
+}
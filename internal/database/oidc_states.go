@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type OIDCState struct {
+	CreatedAt time.Time `json:"created_at"`
+	CreateOIDCStateParams
+}
+
+type CreateOIDCStateParams struct {
+	State        string    `json:"state"`
+	Provider     string    `json:"provider"`
+	CodeVerifier string    `json:"code_verifier"`
+	Nonce        string    `json:"nonce"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func (c Client) CreateOIDCState(params CreateOIDCStateParams) error {
+	query := `
+		INSERT INTO oidc_states (
+			state,
+			created_at,
+			provider,
+			code_verifier,
+			nonce,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.State, params.Provider, params.CodeVerifier, params.Nonce, params.ExpiresAt)
+	return err
+}
+
+// ConsumeOIDCState returns the login state and deletes it so it can only be used once
+func (c Client) ConsumeOIDCState(state string) (*OIDCState, error) {
+	query := `
+		DELETE FROM oidc_states
+		WHERE state = ?
+		RETURNING state, created_at, provider, code_verifier, nonce, expires_at
+	`
+	var s OIDCState
+	err := c.db.QueryRow(query, state).
+		Scan(&s.State, &s.CreatedAt, &s.Provider, &s.CodeVerifier, &s.Nonce, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// DeleteExpiredOIDCStates removes the states of logins that were never
+// finished
+func (c Client) DeleteExpiredOIDCStates() error {
+	query := `
+		DELETE FROM oidc_states
+		WHERE expires_at < ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,357 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrVideoInPlaylist is returned when adding a video a playlist already holds
+	ErrVideoInPlaylist = errors.New("video is already in the playlist")
+	// ErrPlaylistOrderMismatch is returned when a new order doesn't list
+	// exactly the videos in the playlist
+	ErrPlaylistOrderMismatch = errors.New("order must list every video in the playlist exactly once")
+)
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Visibility  Visibility `json:"visibility"`
+	UserID      uuid.UUID  `json:"user_id"`
+}
+
+const playlistColumns = `
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		visibility,
+		user_id
+`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var playlist Playlist
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.Visibility,
+		&playlist.UserID,
+	)
+	return playlist, err
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		visibility,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.Visibility, params.UserID)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `SELECT` + playlistColumns + `FROM playlists WHERE id = ?`
+
+	playlist, err := scanPlaylist(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `SELECT` + playlistColumns + `FROM playlists WHERE user_id = ? ORDER BY created_at DESC`
+
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+func (c Client) UpdatePlaylist(playlist Playlist) (Playlist, error) {
+	query := `
+	UPDATE playlists
+	SET
+		title = ?,
+		description = ?,
+		visibility = ?,
+		updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, playlist.Title, playlist.Description, playlist.Visibility, newUpdatedAt(), playlist.ID)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return c.GetPlaylist(playlist.ID)
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM playlist_videos WHERE playlist_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM playlists WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistVideos returns the videos in a playlist in playlist order
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ?
+	ORDER BY pv.position
+	`
+
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// AddPlaylistVideo inserts a video at a zero-based position, shifting later
+// videos down. A negative or out of range position appends the video.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID, position int) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM playlist_videos WHERE playlist_id = ? AND video_id = ?)",
+		playlistID, videoID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrVideoInPlaylist
+	}
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM playlist_videos WHERE playlist_id = ?", playlistID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if position < 0 || position > count {
+		position = count
+	}
+
+	_, err = tx.Exec(
+		"UPDATE playlist_videos SET position = position + 1 WHERE playlist_id = ? AND position >= ?",
+		playlistID, position,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO playlist_videos (playlist_id, video_id, position, added_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+		playlistID, videoID, position,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = touchPlaylist(tx, playlistID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemovePlaylistVideo takes a video out of a playlist, reporting whether it was there
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	removed, err := removePlaylistVideo(tx, playlistID, videoID)
+	if err != nil || !removed {
+		return false, err
+	}
+	err = touchPlaylist(tx, playlistID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ReorderPlaylist puts the playlist's videos in the given order, which must
+// contain every video in the playlist exactly once
+func (c Client) ReorderPlaylist(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT video_id FROM playlist_videos WHERE playlist_id = ?", playlistID)
+	if err != nil {
+		return err
+	}
+	current := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(videoIDs) != len(current) {
+		return ErrPlaylistOrderMismatch
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, id := range videoIDs {
+		if !current[id] || seen[id] {
+			return ErrPlaylistOrderMismatch
+		}
+		seen[id] = true
+	}
+
+	for position, id := range videoIDs {
+		_, err = tx.Exec(
+			"UPDATE playlist_videos SET position = ? WHERE playlist_id = ? AND video_id = ?",
+			position, playlistID, id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = touchPlaylist(tx, playlistID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removePlaylistVideo deletes one playlist entry and closes the gap it leaves
+func removePlaylistVideo(tx *sql.Tx, playlistID, videoID uuid.UUID) (bool, error) {
+	var position int
+	err := tx.QueryRow(
+		"DELETE FROM playlist_videos WHERE playlist_id = ? AND video_id = ? RETURNING position",
+		playlistID, videoID,
+	).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE playlist_videos SET position = position - 1 WHERE playlist_id = ? AND position > ?",
+		playlistID, position,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// removeVideoFromPlaylists takes a video out of every playlist holding it
+func removeVideoFromPlaylists(tx *sql.Tx, videoID uuid.UUID) error {
+	rows, err := tx.Query("SELECT playlist_id FROM playlist_videos WHERE video_id = ?", videoID)
+	if err != nil {
+		return err
+	}
+	playlistIDs := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		playlistIDs = append(playlistIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, playlistID := range playlistIDs {
+		if _, err := removePlaylistVideo(tx, playlistID, videoID); err != nil {
+			return err
+		}
+		if err := touchPlaylist(tx, playlistID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func touchPlaylist(tx *sql.Tx, playlistID uuid.UUID) error {
+	_, err := tx.Exec("UPDATE playlists SET updated_at = ? WHERE id = ?", newUpdatedAt(), playlistID)
+	return err
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UpdateRateLimitBucket loads a rate limit bucket, applies fn and saves the
+// result in a single transaction
+func (c Client) UpdateRateLimitBucket(
+	ctx context.Context,
+	key string,
+	fn func(tokens float64, updatedAt time.Time, found bool) (float64, time.Time),
+) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var tokens float64
+	var updatedAt time.Time
+	found := true
+	err = tx.QueryRowContext(ctx, `
+		SELECT tokens, updated_at
+		FROM rate_limit_buckets
+		WHERE key = ?
+	`, key).Scan(&tokens, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		found = false
+	} else if err != nil {
+		return err
+	}
+
+	tokens, updatedAt = fn(tokens, updatedAt, found)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET tokens = excluded.tokens, updated_at = excluded.updated_at
+	`, key, tokens, updatedAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteIdleRateLimitBuckets removes buckets that haven't been touched since before
+func (c Client) DeleteIdleRateLimitBuckets(before time.Time) error {
+	_, err := c.db.Exec("DELETE FROM rate_limit_buckets WHERE updated_at < ?", before.UTC())
+	return err
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ShareLink struct {
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	ViewCount    int        `json:"view_count"`
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	CreateShareLinkParams
+}
+
+// CreateShareLinkParams stores only hashes of the link token and password,
+// the raw token is shown to the owner once
+type CreateShareLinkParams struct {
+	TokenHash    string     `json:"-"`
+	VideoID      uuid.UUID  `json:"video_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxViews     *int       `json:"max_views"`
+	PasswordHash string     `json:"-"`
+}
+
+// HasPassword reports whether viewers must enter a password to use the link
+func (l ShareLink) HasPassword() bool {
+	return l.PasswordHash != ""
+}
+
+// Usable reports whether the link can still be viewed at the given time
+func (l ShareLink) Usable(now time.Time) bool {
+	if l.RevokedAt != nil {
+		return false
+	}
+	if l.ExpiresAt != nil && !l.ExpiresAt.After(now) {
+		return false
+	}
+	return l.MaxViews == nil || l.ViewCount < *l.MaxViews
+}
+
+const shareLinkColumns = `
+		id,
+		created_at,
+		revoked_at,
+		view_count,
+		last_viewed_at,
+		token_hash,
+		video_id,
+		user_id,
+		expires_at,
+		max_views,
+		password_hash
+`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.CreatedAt,
+		&link.RevokedAt,
+		&link.ViewCount,
+		&link.LastViewedAt,
+		&link.TokenHash,
+		&link.VideoID,
+		&link.UserID,
+		&link.ExpiresAt,
+		&link.MaxViews,
+		&link.PasswordHash,
+	)
+	return link, err
+}
+
+func (c Client) CreateShareLink(params CreateShareLinkParams) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		created_at,
+		token_hash,
+		video_id,
+		user_id,
+		expires_at,
+		max_views,
+		password_hash
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.TokenHash,
+		params.VideoID,
+		params.UserID,
+		params.ExpiresAt,
+		params.MaxViews,
+		params.PasswordHash,
+	)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	return c.GetShareLink(id)
+}
+
+func (c Client) GetShareLink(id uuid.UUID) (ShareLink, error) {
+	query := `SELECT` + shareLinkColumns + `FROM share_links WHERE id = ?`
+	return c.getShareLink(query, id)
+}
+
+func (c Client) GetShareLinkByToken(tokenHash string) (ShareLink, error) {
+	query := `SELECT` + shareLinkColumns + `FROM share_links WHERE token_hash = ?`
+	return c.getShareLink(query, tokenHash)
+}
+
+func (c Client) getShareLink(query string, arg any) (ShareLink, error) {
+	link, err := scanShareLink(c.db.QueryRow(query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+func (c Client) GetShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `SELECT` + shareLinkColumns + `FROM share_links WHERE video_id = ? ORDER BY created_at DESC`
+
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (c Client) RevokeShareLink(id uuid.UUID) error {
+	query := `
+	UPDATE share_links
+	SET revoked_at = ?
+	WHERE id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), id)
+	return err
+}
+
+// RecordShareLinkView counts a view against a link if it is still usable,
+// returning the updated link. It returns false if the link was revoked,
+// expired or used up, so concurrent viewers can't exceed the view limit.
+func (c Client) RecordShareLinkView(id uuid.UUID) (ShareLink, bool, error) {
+	now := time.Now().UTC()
+	query := `
+	UPDATE share_links
+	SET view_count = view_count + 1, last_viewed_at = ?
+	WHERE id = ?
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > ?)
+		AND (max_views IS NULL OR view_count < max_views)
+	RETURNING` + shareLinkColumns
+
+	link, err := scanShareLink(c.db.QueryRow(query, now, id, now))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, false, nil
+		}
+		return ShareLink{}, false, err
+	}
+	return link, true, nil
+}
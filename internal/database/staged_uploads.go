@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StagedUpload is a video file a client has been allowed to upload straight
+// to the video store, waiting to be processed. It outlives its video, expired,
+// until its file is cleaned up.
+type StagedUpload struct {
+	VideoID     uuid.UUID
+	CreatedAt   time.Time
+	Key         string
+	ContentType string
+	Size        int64
+	// UploadID is set for multipart uploads
+	UploadID  string
+	ExpiresAt time.Time
+}
+
+// PutStagedUpload records a video's staged upload, returning the one it
+// replaces, if any, so the caller can clean it up
+func (c Client) PutStagedUpload(upload StagedUpload) (*StagedUpload, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	previous, err := scanStagedUpload(tx.QueryRow(
+		"SELECT video_id, created_at, key, content_type, size, upload_id, expires_at FROM staged_uploads WHERE video_id = ?",
+		upload.VideoID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO staged_uploads (video_id, created_at, key, content_type, size, upload_id, expires_at)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		created_at = excluded.created_at,
+		key = excluded.key,
+		content_type = excluded.content_type,
+		size = excluded.size,
+		upload_id = excluded.upload_id,
+		expires_at = excluded.expires_at
+	`, upload.VideoID, upload.Key, upload.ContentType, upload.Size, upload.UploadID, upload.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return previous, tx.Commit()
+}
+
+// GetStagedUpload returns a video's staged upload, or nil if it has none
+func (c Client) GetStagedUpload(videoID uuid.UUID) (*StagedUpload, error) {
+	return scanStagedUpload(c.db.QueryRow(
+		"SELECT video_id, created_at, key, content_type, size, upload_id, expires_at FROM staged_uploads WHERE video_id = ?",
+		videoID,
+	))
+}
+
+// DeleteStagedUpload forgets a video's staged upload
+func (c Client) DeleteStagedUpload(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM staged_uploads WHERE video_id = ?", videoID)
+	return err
+}
+
+// ListExpiredStagedUploads returns the staged uploads that expired before now
+func (c Client) ListExpiredStagedUploads(now time.Time) ([]StagedUpload, error) {
+	rows, err := c.db.Query(
+		"SELECT video_id, created_at, key, content_type, size, upload_id, expires_at FROM staged_uploads WHERE expires_at < ? ORDER BY expires_at",
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []StagedUpload{}
+	for rows.Next() {
+		upload, err := scanStagedUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, *upload)
+	}
+	return uploads, rows.Err()
+}
+
+func scanStagedUpload(row rowScanner) (*StagedUpload, error) {
+	var upload StagedUpload
+	err := row.Scan(
+		&upload.VideoID,
+		&upload.CreatedAt,
+		&upload.Key,
+		&upload.ContentType,
+		&upload.Size,
+		&upload.UploadID,
+		&upload.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// StoredObject is a processed video file in the video store. Identical
+// uploads, found by the SHA-256 of the file as it was uploaded, share one
+// object, which is kept until no video refers to it. Each object gets a key
+// of its own that is never reused, so deleting a released object can't
+// remove a file a later identical upload was stored as.
+type StoredObject struct {
+	Key       string
+	CreatedAt time.Time
+	// SourceSHA256 is the hex SHA-256 of the upload the object was made from
+	SourceSHA256 string
+	// SHA256 and Size describe the stored object, to check it for corruption
+	SHA256      string
+	Size        int64
+	AspectRatio *string
+	Orientation *string
+	RefCount    int
+}
+
+const storedObjectColumns = "key, created_at, source_sha256, sha256, size, aspect_ratio, orientation, ref_count"
+
+func scanStoredObject(row rowScanner) (StoredObject, error) {
+	var object StoredObject
+	err := row.Scan(
+		&object.Key,
+		&object.CreatedAt,
+		&object.SourceSHA256,
+		&object.SHA256,
+		&object.Size,
+		&object.AspectRatio,
+		&object.Orientation,
+		&object.RefCount,
+	)
+	return object, err
+}
+
+// GetStoredObjectBySource returns the object made from an upload with the
+// given hash, or nil if there isn't one
+func (c Client) GetStoredObjectBySource(sourceSHA256 string) (*StoredObject, error) {
+	row := c.db.QueryRow("SELECT "+storedObjectColumns+" FROM stored_objects WHERE source_sha256 = ?", sourceSHA256)
+	object, err := scanStoredObject(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+// ListStoredObjects returns every object, ordered by key
+func (c Client) ListStoredObjects() ([]StoredObject, error) {
+	rows, err := c.db.Query("SELECT " + storedObjectColumns + " FROM stored_objects ORDER BY key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []StoredObject{}
+	for rows.Next() {
+		object, err := scanStoredObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, rows.Err()
+}
+
+// AddStoredObject records a newly stored object with one reference and
+// returns the record holding it. If an identical upload was stored
+// concurrently, that record gains the reference instead and is returned, so
+// the caller's object is unused when the keys differ.
+func (c Client) AddStoredObject(object StoredObject) (StoredObject, error) {
+	row := c.db.QueryRow(`
+	INSERT INTO stored_objects (key, created_at, source_sha256, sha256, size, aspect_ratio, orientation, ref_count)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, 1)
+	ON CONFLICT(source_sha256) DO UPDATE SET ref_count = ref_count + 1
+	RETURNING `+storedObjectColumns,
+		object.Key, object.SourceSHA256, object.SHA256, object.Size, object.AspectRatio, object.Orientation)
+	return scanStoredObject(row)
+}
+
+// RetainStoredObject adds a reference to an object, reporting false if it
+// no longer exists
+func (c Client) RetainStoredObject(key string) (bool, error) {
+	result, err := c.db.Exec("UPDATE stored_objects SET ref_count = ref_count + 1 WHERE key = ?", key)
+	if err != nil {
+		return false, err
+	}
+	changed, err := result.RowsAffected()
+	return changed > 0, err
+}
+
+// ReleaseStoredObject drops a reference to an object. The record is removed
+// when its last reference goes, and unused is true so the caller can delete
+// the object itself. That's safe after the record is gone since its key is
+// never stored under again. Objects stored before content addressing have no
+// record and were never shared, so they're always unused.
+func (c Client) ReleaseStoredObject(key string) (unused bool, err error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var refCount int
+	err = tx.QueryRow("UPDATE stored_objects SET ref_count = ref_count - 1 WHERE key = ? RETURNING ref_count", key).Scan(&refCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if refCount <= 0 {
+		if _, err := tx.Exec("DELETE FROM stored_objects WHERE key = ?", key); err != nil {
+			return false, err
+		}
+	}
+	return refCount <= 0, tx.Commit()
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// setVideoTags replaces a video's tags, creating any tags that don't exist yet
+func setVideoTags(tx *sql.Tx, videoID uuid.UUID, tags []string) error {
+	_, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", videoID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		_, err = tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT(name) DO NOTHING", tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO video_tags (video_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+		ON CONFLICT DO NOTHING
+		`, videoID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserIdentity struct {
+	CreatedAt time.Time `json:"created_at"`
+	CreateUserIdentityParams
+}
+
+type CreateUserIdentityParams struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   uuid.UUID `json:"user_id"`
+	Email    string    `json:"email"`
+}
+
+func (c Client) CreateUserIdentity(params CreateUserIdentityParams) (*UserIdentity, error) {
+	query := `
+		INSERT INTO user_identities
+		    (provider, subject, created_at, user_id, email)
+		VALUES
+		    (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.Provider, params.Subject, params.UserID.String(), params.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetUserIdentity(params.Provider, params.Subject)
+}
+
+func (c Client) GetUserIdentity(provider, subject string) (*UserIdentity, error) {
+	query := `
+		SELECT provider, subject, created_at, user_id, email
+		FROM user_identities
+		WHERE provider = ? AND subject = ?
+	`
+	var identity UserIdentity
+	var userID string
+	err := c.db.QueryRow(query, provider, subject).
+		Scan(&identity.Provider, &identity.Subject, &identity.CreatedAt, &userID, &identity.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	identity.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserTokenPurpose string
+
+const (
+	UserTokenEmailVerification UserTokenPurpose = "email_verification"
+	UserTokenPasswordReset     UserTokenPurpose = "password_reset"
+)
+
+type UserToken struct {
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreateUserTokenParams
+}
+
+// CreateUserTokenParams stores only a hash of the token, the raw value is sent to the user
+type CreateUserTokenParams struct {
+	TokenHash string           `json:"-"`
+	UserID    uuid.UUID        `json:"user_id"`
+	Purpose   UserTokenPurpose `json:"purpose"`
+	ExpiresAt time.Time        `json:"expires_at"`
+}
+
+func (c Client) CreateUserToken(params CreateUserTokenParams) error {
+	query := `
+		INSERT INTO user_tokens (
+			token_hash,
+			created_at,
+			user_id,
+			purpose,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID.String(), params.Purpose, params.ExpiresAt)
+	return err
+}
+
+// UseUserToken marks an unused, unexpired token as used and returns it. It
+// returns nil if no such token exists so each token can only be used once.
+func (c Client) UseUserToken(tokenHash string, purpose UserTokenPurpose) (*UserToken, error) {
+	query := `
+		UPDATE user_tokens
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ?
+			AND purpose = ?
+			AND used_at IS NULL
+			AND expires_at > ?
+		RETURNING token_hash, created_at, user_id, purpose, expires_at, used_at
+	`
+	var token UserToken
+	var userID string
+	err := c.db.QueryRow(query, tokenHash, purpose, time.Now().UTC()).Scan(
+		&token.TokenHash,
+		&token.CreatedAt,
+		&userID,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	token.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteUserTokens removes all of a user's outstanding tokens for a purpose
+func (c Client) DeleteUserTokens(userID uuid.UUID, purpose UserTokenPurpose) error {
+	query := `
+		DELETE FROM user_tokens
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), purpose)
+	return err
+}
@@ -3,21 +3,31 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	DisplayName     string     `json:"display_name"`
+	LockedUntil     *time.Time `json:"-"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
+}
+
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	Email       string
+	DisplayName string
 }
 
 func (c Client) GetUsers() ([]User, error) {
@@ -53,13 +63,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email_verified_at, display_name, locked_until, email, password
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerifiedAt, &user.DisplayName, &user.LockedUntil, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,15 +85,17 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.email_verified_at, u.display_name, u.locked_until, u.password
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
+			AND rt.revoked_at IS NULL
+			AND rt.expires_at > ?
 	`
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token, time.Now().UTC()).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerifiedAt, &user.DisplayName, &user.LockedUntil, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,13 +129,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email_verified_at, display_name, locked_until, email, password
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.EmailVerifiedAt, &user.DisplayName, &user.LockedUntil, &user.Email, &user.Password)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,6 +149,81 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+// UpdateUserProfile changes a user's email and display name. Changing the
+// email clears its verification.
+func (c Client) UpdateUserProfile(params UpdateUserProfileParams) (*User, error) {
+	query := `
+		UPDATE users
+		SET
+			email_verified_at = CASE WHEN email = ? THEN email_verified_at ELSE NULL END,
+			email = ?,
+			display_name = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, params.Email, params.Email, params.DisplayName, params.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetUser(params.ID)
+}
+
+func (c Client) MarkUserEmailVerified(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND email_verified_at IS NULL
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
+func (c Client) UpdateUserPassword(id uuid.UUID, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hashedPassword, id.String())
+	return err
+}
+
+// IncrementFailedLogins records a failed password attempt and returns the
+// number of consecutive failures
+func (c Client) IncrementFailedLogins(id uuid.UUID) (int, error) {
+	query := `
+		UPDATE users
+		SET failed_login_count = failed_login_count + 1
+		WHERE id = ?
+		RETURNING failed_login_count
+	`
+	var count int
+	err := c.db.QueryRow(query, id.String()).Scan(&count)
+	return count, err
+}
+
+func (c Client) LockUser(id uuid.UUID, until time.Time) error {
+	query := `
+		UPDATE users
+		SET locked_until = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, until.UTC(), id.String())
+	return err
+}
+
+// ResetFailedLogins clears the failure count and any lock after a successful login
+func (c Client) ResetFailedLogins(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET failed_login_count = 0, locked_until = NULL
+		WHERE id = ? AND (failed_login_count > 0 OR locked_until IS NOT NULL)
+	`
+	_, err := c.db.Exec(query, id.String())
+	return err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
@@ -145,3 +232,78 @@ func (c Client) DeleteUser(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+// DeleteUserAccount removes a user and everything they own in a single
+// transaction. It returns the deleted videos so their stored media can be
+// cleaned up once the rows are gone.
+func (c Client) DeleteUserAccount(id uuid.UUID) ([]Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT"+videoColumns+"FROM videos v WHERE v.user_id = ?", id.String())
+	if err != nil {
+		return nil, err
+	}
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// staged uploads are expired instead of deleted, so their files are
+	// still cleaned up
+	_, err = tx.Exec("UPDATE staged_uploads SET expires_at = ? WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)", time.Now().UTC(), id.String())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't delete account: %w", err)
+	}
+
+	// every statement takes the user ID as its only parameter, ?1 reuses it
+	statements := []string{
+		// take the user's likes and comments (with their replies) off other people's videos
+		"UPDATE videos SET like_count = like_count - 1 WHERE id IN (SELECT video_id FROM video_likes WHERE user_id = ?1)",
+		`UPDATE videos SET comment_count = comment_count - (
+			SELECT COUNT(*) FROM comments c
+			WHERE c.video_id = videos.id
+				AND (c.user_id = ?1 OR c.parent_id IN (SELECT id FROM comments WHERE user_id = ?1))
+		) WHERE id IN (SELECT video_id FROM comments WHERE user_id = ?1 OR parent_id IN (SELECT id FROM comments WHERE user_id = ?1))`,
+		"DELETE FROM comments WHERE parent_id IN (SELECT id FROM comments WHERE user_id = ?1)",
+		"DELETE FROM comments WHERE user_id = ?1 OR video_id IN (SELECT id FROM videos WHERE user_id = ?1)",
+		"DELETE FROM video_likes WHERE user_id = ?1 OR video_id IN (SELECT id FROM videos WHERE user_id = ?1)",
+		"DELETE FROM video_tags WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM playlist_videos WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM playlist_videos WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)",
+		"DELETE FROM playlists WHERE user_id = ?",
+		"DELETE FROM share_links WHERE user_id = ?",
+		"DELETE FROM captions WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM video_daily_stats WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM video_daily_viewers WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM videos WHERE user_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)",
+		"DELETE FROM webhooks WHERE user_id = ?",
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM user_tokens WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id.String()); err != nil {
+			return nil, fmt.Errorf("couldn't delete account: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
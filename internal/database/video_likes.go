@@ -0,0 +1,64 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// LikeVideo records a user's like, returning the video's new like count.
+// Liking a video twice has no effect.
+func (c Client) LikeVideo(videoID, userID uuid.UUID) (int, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT INTO video_likes (video_id, user_id, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT DO NOTHING
+	`, videoID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return updateLikeCount(tx, result, videoID, 1)
+}
+
+// UnlikeVideo removes a user's like, returning the video's new like count
+func (c Client) UnlikeVideo(videoID, userID uuid.UUID) (int, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM video_likes WHERE video_id = ? AND user_id = ?", videoID, userID)
+	if err != nil {
+		return 0, err
+	}
+	return updateLikeCount(tx, result, videoID, -1)
+}
+
+// updateLikeCount adjusts the denormalized count by delta if the like changed
+// and commits
+func updateLikeCount(tx *sql.Tx, result sql.Result, videoID uuid.UUID, delta int) (int, error) {
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if changed > 0 {
+		_, err = tx.Exec("UPDATE videos SET like_count = like_count + ? WHERE id = ?", delta, videoID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+	err = tx.QueryRow("SELECT like_count FROM videos WHERE id = ?", videoID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
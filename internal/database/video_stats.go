@@ -0,0 +1,153 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// statsDayFormat is how days are stored in the stats tables
+const statsDayFormat = "2006-01-02"
+
+// VideoDailyStats holds playback totals for one video on one UTC day. When
+// recorded they are added to any totals already stored for that day.
+type VideoDailyStats struct {
+	VideoID uuid.UUID `json:"-"`
+	Day     string    `json:"date"`
+	Plays   int       `json:"views"`
+	// Quartile25 to Quartile75 count plays that got at least that far in
+	Quartile25   int     `json:"quartile_25"`
+	Quartile50   int     `json:"quartile_50"`
+	Quartile75   int     `json:"quartile_75"`
+	Completes    int     `json:"completes"`
+	WatchSeconds float64 `json:"watch_seconds"`
+	// UniqueViewers is filled in when stats are read back
+	UniqueViewers int `json:"unique_viewers"`
+}
+
+// VideoDailyViewer records that a viewer, identified by an opaque hash,
+// watched a video on a day
+type VideoDailyViewer struct {
+	VideoID    uuid.UUID
+	Day        string
+	ViewerHash string
+}
+
+// RecordVideoStats adds a batch of daily totals and viewers in one transaction
+func (c Client) RecordVideoStats(stats []VideoDailyStats, viewers []VideoDailyViewer) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range stats {
+		_, err = tx.Exec(`
+		INSERT INTO video_daily_stats (
+			video_id,
+			day,
+			plays,
+			quartile_25,
+			quartile_50,
+			quartile_75,
+			completes,
+			watch_seconds
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(video_id, day) DO UPDATE SET
+			plays = plays + excluded.plays,
+			quartile_25 = quartile_25 + excluded.quartile_25,
+			quartile_50 = quartile_50 + excluded.quartile_50,
+			quartile_75 = quartile_75 + excluded.quartile_75,
+			completes = completes + excluded.completes,
+			watch_seconds = watch_seconds + excluded.watch_seconds
+		`,
+			s.VideoID,
+			s.Day,
+			s.Plays,
+			s.Quartile25,
+			s.Quartile50,
+			s.Quartile75,
+			s.Completes,
+			s.WatchSeconds,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, v := range viewers {
+		_, err = tx.Exec(`
+		INSERT INTO video_daily_viewers (video_id, day, viewer_hash)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING
+		`, v.VideoID, v.Day, v.ViewerHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetVideoDailyStats returns a video's stats for each day in [fromDay, toDay]
+// that had any activity, oldest first
+func (c Client) GetVideoDailyStats(videoID uuid.UUID, fromDay, toDay string) ([]VideoDailyStats, error) {
+	query := `
+	SELECT
+		s.day,
+		s.plays,
+		s.quartile_25,
+		s.quartile_50,
+		s.quartile_75,
+		s.completes,
+		s.watch_seconds,
+		(
+			SELECT COUNT(*) FROM video_daily_viewers v
+			WHERE v.video_id = s.video_id AND v.day = s.day
+		)
+	FROM video_daily_stats s
+	WHERE s.video_id = ? AND s.day BETWEEN ? AND ?
+	ORDER BY s.day
+	`
+	rows, err := c.db.Query(query, videoID, fromDay, toDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []VideoDailyStats{}
+	for rows.Next() {
+		s := VideoDailyStats{VideoID: videoID}
+		err := rows.Scan(
+			&s.Day,
+			&s.Plays,
+			&s.Quartile25,
+			&s.Quartile50,
+			&s.Quartile75,
+			&s.Completes,
+			&s.WatchSeconds,
+			&s.UniqueViewers,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// CountVideoViewers counts distinct viewers of a video over [fromDay, toDay]
+func (c Client) CountVideoViewers(videoID uuid.UUID, fromDay, toDay string) (int, error) {
+	var count int
+	err := c.db.QueryRow(`
+	SELECT COUNT(DISTINCT viewer_hash)
+	FROM video_daily_viewers
+	WHERE video_id = ? AND day BETWEEN ? AND ?
+	`, videoID, fromDay, toDay).Scan(&count)
+	return count, err
+}
+
+// StatsDay returns the UTC day a time falls on, as stored in the stats tables
+func StatsDay(t time.Time) string {
+	return t.UTC().Format(statsDayFormat)
+}
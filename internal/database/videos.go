@@ -2,44 +2,237 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+// ErrVideoModified is returned by conditional updates when the video changed
+// since the caller read it
+var ErrVideoModified = errors.New("video was modified")
+
+// Categories are the fixed set a video can be filed under
+var Categories = []string{
+	"autos",
+	"comedy",
+	"education",
+	"entertainment",
+	"gaming",
+	"howto",
+	"music",
+	"news",
+	"people",
+	"pets",
+	"science",
+	"sports",
+	"travel",
+}
+
+func ValidCategory(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	ThumbnailURL *string    `json:"thumbnail_url"`
+	VideoURL     *string    `json:"video_url"`
+	Visibility   Visibility `json:"visibility"`
+	PublishedAt  *time.Time `json:"published_at"`
+	Category     *string    `json:"category"`
+	Tags         []string   `json:"tags"`
+	// Captions are sorted by language
+	Captions []Caption `json:"captions"`
+	// AspectRatio is the reduced display aspect ratio, like "16:9", and
+	// Orientation is landscape, portrait or square. Both are unset until a
+	// video file is uploaded.
+	AspectRatio  *string `json:"aspect_ratio"`
+	Orientation  *string `json:"orientation"`
+	LikeCount    int     `json:"like_count"`
+	CommentCount int     `json:"comment_count"`
 	CreateVideoParams
 }
 
+// Published reports whether the video has been released to viewers other
+// than its owner
+func (v Video) Published() bool {
+	return v.PublishedAt != nil
+}
+
+// ListVideosParams filters video listings. Empty fields don't filter.
+type ListVideosParams struct {
+	UserID   uuid.UUID
+	Tag      string
+	Category string
+	// Public limits the listing to published public videos, newest published first
+	Public bool
+	// After pages through public listings
+	After *VideoCursor
+	Limit int
+}
+
+// VideoCursor is the position of the last video on a page of a public listing
+type VideoCursor struct {
+	PublishedAt time.Time
+	ID          uuid.UUID
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
 }
 
+// videoColumns is selected by every video query, in the order scanVideo reads them
+const videoColumns = `
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.visibility,
+		v.published_at,
+		v.category,
+		v.like_count,
+		v.comment_count,
+		v.aspect_ratio,
+		v.orientation,
+		(
+			SELECT GROUP_CONCAT(t.name, ',')
+			FROM video_tags vt
+			JOIN tags t ON t.id = vt.tag_id
+			WHERE vt.video_id = v.id
+		) AS tags,
+		(
+			SELECT json_group_array(json_object('language', c.language, 'label', c.label, 'url', c.url))
+			FROM captions c
+			WHERE c.video_id = v.id
+		) AS captions
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var tags, captions sql.NullString
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.Visibility,
+		&video.PublishedAt,
+		&video.Category,
+		&video.LikeCount,
+		&video.CommentCount,
+		&video.AspectRatio,
+		&video.Orientation,
+		&tags,
+		&captions,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+
+	video.Tags = []string{}
+	if tags.Valid && tags.String != "" {
+		video.Tags = strings.Split(tags.String, ",")
+		sort.Strings(video.Tags)
+	}
+
+	video.Captions = []Caption{}
+	if captions.Valid {
+		if err := json.Unmarshal([]byte(captions.String), &video.Captions); err != nil {
+			return Video{}, err
+		}
+		for i := range video.Captions {
+			video.Captions[i].VideoID = video.ID
+		}
+		sort.Slice(video.Captions, func(i, j int) bool {
+			return video.Captions[i].Language < video.Captions[j].Language
+		})
+	}
+	return video, nil
+}
+
 func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	return c.ListVideos(ListVideosParams{UserID: userID})
+}
+
+func (c Client) ListVideos(params ListVideosParams) ([]Video, error) {
+	conditions := []string{"1 = 1"}
+	args := []any{}
+	if params.UserID != uuid.Nil {
+		conditions = append(conditions, "v.user_id = ?")
+		args = append(args, params.UserID)
+	}
+	if params.Category != "" {
+		conditions = append(conditions, "v.category = ?")
+		args = append(args, params.Category)
+	}
+	if params.Tag != "" {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM video_tags vt JOIN tags t ON t.id = vt.tag_id
+			WHERE vt.video_id = v.id AND t.name = ?
+		)`)
+		args = append(args, params.Tag)
+	}
+	order := "v.created_at DESC"
+	if params.Public {
+		conditions = append(conditions, "v.visibility = ? AND v.published_at IS NOT NULL")
+		args = append(args, VisibilityPublic)
+		order = "julianday(v.published_at) DESC, v.id DESC"
+	}
+	if params.After != nil {
+		// julianday() compares timestamps by value, the id breaks ties
+		conditions = append(conditions, `(
+			julianday(v.published_at) < julianday(?)
+			OR (julianday(v.published_at) = julianday(?) AND v.id < ?)
+		)`)
+		publishedAt := params.After.PublishedAt.UTC()
+		args = append(args, publishedAt, publishedAt, params.After.ID)
+	}
+	limit := ""
+	if params.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, params.Limit)
+	}
+
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+	SELECT` + videoColumns + `
+	FROM videos v
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + order + `
+	` + limit
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,21 +240,15 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return videos, nil
 }
@@ -88,29 +275,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE id = ?
+	SELECT` + videoColumns + `
+	FROM videos v
+	WHERE v.id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,6 +291,12 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// newUpdatedAt is the timestamp written on every update. It is truncated to
+// the millisecond precision SQLite compares timestamps with.
+func newUpdatedAt() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
 func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
@@ -129,7 +305,10 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		user_id = ?,
+		aspect_ratio = ?,
+		orientation = ?,
+		updated_at = ?
 	WHERE id = ?
 	`
 
@@ -140,16 +319,145 @@ func (c Client) UpdateVideo(video Video) error {
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.AspectRatio,
+		video.Orientation,
+		newUpdatedAt(),
 		video.ID,
 	)
 	return err
 }
 
+// UpdateVideoMedia saves a video's file and the shape it's displayed in,
+// leaving its metadata to UpdateVideoMetadata. The file is only replaced if
+// it's still previousURL, returning ErrVideoModified otherwise, so the
+// caller knows exactly which file it replaced.
+func (c Client) UpdateVideoMedia(id uuid.UUID, previousURL *string, videoURL string, aspectRatio, orientation *string) (Video, error) {
+	result, err := c.db.Exec(`
+	UPDATE videos
+	SET
+		video_url = ?,
+		aspect_ratio = ?,
+		orientation = ?,
+		updated_at = ?
+	WHERE id = ? AND video_url IS ?
+	`,
+		videoURL,
+		aspectRatio,
+		orientation,
+		newUpdatedAt(),
+		id,
+		previousURL,
+	)
+	if err != nil {
+		return Video{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return Video{}, err
+	}
+	if updated == 0 {
+		return Video{}, ErrVideoModified
+	}
+	return c.GetVideo(id)
+}
+
+// UpdateVideoThumbnail saves a video's thumbnail, leaving its metadata to
+// UpdateVideoMetadata
+func (c Client) UpdateVideoThumbnail(id uuid.UUID, thumbnailURL string) (Video, error) {
+	_, err := c.db.Exec("UPDATE videos SET thumbnail_url = ?, updated_at = ? WHERE id = ?", thumbnailURL, newUpdatedAt(), id)
+	if err != nil {
+		return Video{}, err
+	}
+	return c.GetVideo(id)
+}
+
+// UpdateVideoMetadata saves a video's title, description, visibility,
+// published state, category and tags only if its updated_at still matches
+// unmodifiedSince, returning ErrVideoModified otherwise
+func (c Client) UpdateVideoMetadata(video Video, unmodifiedSince time.Time) (Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Video{}, err
+	}
+	defer tx.Rollback()
+
+	// julianday() lets timestamps written by CURRENT_TIMESTAMP and by Go compare equal
+	result, err := tx.Exec(`
+	UPDATE videos
+	SET
+		title = ?,
+		description = ?,
+		visibility = ?,
+		published_at = ?,
+		category = ?,
+		updated_at = ?
+	WHERE id = ? AND julianday(updated_at) = julianday(?)
+	`,
+		video.Title,
+		video.Description,
+		video.Visibility,
+		video.PublishedAt,
+		video.Category,
+		newUpdatedAt(),
+		video.ID,
+		unmodifiedSince.UTC(),
+	)
+	if err != nil {
+		return Video{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return Video{}, err
+	}
+	if updated == 0 {
+		return Video{}, ErrVideoModified
+	}
+
+	err = setVideoTags(tx, video.ID, video.Tags)
+	if err != nil {
+		return Video{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Video{}, err
+	}
+	return c.GetVideo(video.ID)
+}
+
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_tags WHERE video_id = ?", id)
+	if err != nil {
+		return err
+	}
+	err = removeVideoFromPlaylists(tx, id)
+	if err != nil {
+		return err
+	}
+	// a staged upload is expired instead, so its file is still cleaned up
+	_, err = tx.Exec("UPDATE staged_uploads SET expires_at = ? WHERE video_id = ?", time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"share_links", "captions", "video_daily_stats", "video_daily_viewers", "video_likes", "comments"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE video_id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	_, err = tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,341 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreateWebhookParams
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	URL    string    `json:"url"`
+	// Secret signs deliveries, so it's kept as is and only shown at creation
+	Secret string `json:"-"`
+	// Events are the event names the webhook is sent
+	Events []string `json:"events"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+type WebhookDelivery struct {
+	ID        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	WebhookID uuid.UUID       `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	WebhookAttempt
+	Attempts      int        `json:"attempts"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+}
+
+// WebhookAttempt is the outcome of trying to send a delivery
+type WebhookAttempt struct {
+	Status WebhookDeliveryStatus `json:"status"`
+	// ResponseStatus is nil if the endpoint couldn't be reached
+	ResponseStatus *int   `json:"response_status"`
+	Error          string `json:"error"`
+	// NextAttemptAt is when a pending delivery will be tried again
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		secret,
+		events
+`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		&events,
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	err = json.Unmarshal([]byte(events), &webhook.Events)
+	return webhook, err
+}
+
+const webhookDeliveryColumns = `
+		id,
+		created_at,
+		webhook_id,
+		event,
+		payload,
+		status,
+		response_status,
+		last_error,
+		next_attempt_at,
+		attempts,
+		last_attempt_at
+`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload string
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.ResponseStatus,
+		&delivery.Error,
+		&delivery.NextAttemptAt,
+		&delivery.Attempts,
+		&delivery.LastAttemptAt,
+	)
+	delivery.Payload = json.RawMessage(payload)
+	return delivery, err
+}
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	events, err := json.Marshal(params.Events)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	id := uuid.New()
+	now := newUpdatedAt()
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		secret,
+		events
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = c.db.Exec(query, id, now, now, params.UserID, params.URL, params.Secret, string(events))
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return c.GetWebhook(id)
+}
+
+func (c Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `SELECT` + webhookColumns + `FROM webhooks WHERE id = ?`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, nil
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `SELECT` + webhookColumns + `FROM webhooks WHERE user_id = ? ORDER BY created_at`
+	return c.queryWebhooks(query, userID)
+}
+
+func (c Client) queryWebhooks(query string, args ...any) ([]Webhook, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook along with its delivery log
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnqueueWebhookDeliveries queues a pending delivery of the payload to each of
+// the user's webhooks that subscribes to the event, returning how many were
+// queued
+func (c Client) EnqueueWebhookDeliveries(userID uuid.UUID, event string, payload []byte) (int, error) {
+	webhooks, err := c.queryWebhooks(`
+	SELECT`+webhookColumns+`
+	FROM webhooks
+	WHERE user_id = ? AND EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = ?)
+	`, userID, event)
+	if err != nil {
+		return 0, err
+	}
+	if len(webhooks) == 0 {
+		return 0, nil
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, webhook := range webhooks {
+		if err := insertWebhookDelivery(tx, uuid.New(), webhook.ID, event, payload); err != nil {
+			return 0, err
+		}
+	}
+	return len(webhooks), tx.Commit()
+}
+
+// CreateWebhookDelivery queues a single pending delivery, used to redeliver
+// an earlier payload
+func (c Client) CreateWebhookDelivery(webhookID uuid.UUID, event string, payload []byte) (WebhookDelivery, error) {
+	id := uuid.New()
+	if err := insertWebhookDelivery(c.db, id, webhookID, event, payload); err != nil {
+		return WebhookDelivery{}, err
+	}
+	return c.GetWebhookDelivery(id)
+}
+
+// execer is a *sql.DB or *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertWebhookDelivery(db execer, id, webhookID uuid.UUID, event string, payload []byte) error {
+	now := newUpdatedAt()
+	_, err := db.Exec(`
+	INSERT INTO webhook_deliveries (
+		id,
+		created_at,
+		webhook_id,
+		event,
+		payload,
+		status,
+		next_attempt_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, now, webhookID, event, string(payload), WebhookDeliveryPending, now)
+	return err
+}
+
+func (c Client) GetWebhookDelivery(id uuid.UUID) (WebhookDelivery, error) {
+	query := `SELECT` + webhookDeliveryColumns + `FROM webhook_deliveries WHERE id = ?`
+	delivery, err := scanWebhookDelivery(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookDelivery{}, nil
+		}
+		return WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns a webhook's most recent deliveries, newest first
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY julianday(created_at) DESC, id DESC
+	LIMIT ?
+	`
+	return c.queryWebhookDeliveries(query, webhookID, limit)
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries that are due
+// and pushes their next attempt back by lease, so a delivery that is being
+// sent isn't picked up again unless the sender dies before recording it
+func (c Client) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	query := `
+	UPDATE webhook_deliveries
+	SET next_attempt_at = ?
+	WHERE id IN (
+		SELECT id FROM webhook_deliveries
+		WHERE status = ? AND julianday(next_attempt_at) <= julianday(?)
+		ORDER BY julianday(next_attempt_at)
+		LIMIT ?
+	)
+	RETURNING` + webhookDeliveryColumns
+	return c.queryWebhookDeliveries(query, now.Add(lease).UTC(), WebhookDeliveryPending, now.UTC(), limit)
+}
+
+func (c Client) queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordWebhookAttempt saves the outcome of sending a delivery
+func (c Client) RecordWebhookAttempt(id uuid.UUID, attempt WebhookAttempt) error {
+	query := `
+	UPDATE webhook_deliveries
+	SET status = ?,
+		response_status = ?,
+		last_error = ?,
+		next_attempt_at = ?,
+		attempts = attempts + 1,
+		last_attempt_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(
+		query,
+		attempt.Status,
+		attempt.ResponseStatus,
+		attempt.Error,
+		attempt.NextAttemptAt.UTC(),
+		newUpdatedAt(),
+		id,
+	)
+	return err
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	// Kind is one of "smtp", "log" or "file"
+	Kind string
+	From string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Dir is where the file mailer writes one .eml file per message
+	Dir string
+}
+
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Kind {
+	case "", "log":
+		return LogMailer{From: cfg.From}, nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("file mailer requires a directory")
+		}
+		return FileMailer{From: cfg.From, Dir: cfg.Dir}, nil
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp mailer requires a host and from address")
+		}
+		port := cfg.SMTPPort
+		if port == "" {
+			port = "587"
+		}
+		return SMTPMailer{
+			From:     cfg.From,
+			Host:     cfg.SMTPHost,
+			Port:     port,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Kind)
+	}
+}
+
+// format renders the message as a minimal RFC 5322 email
+func format(from string, msg Message) []byte {
+	return []byte(fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from, msg.To, msg.Subject, msg.Body,
+	))
+}
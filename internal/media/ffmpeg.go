@@ -0,0 +1,253 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediatool"
+)
+
+// FFmpeg is a Processor that runs ffmpeg and ffprobe, which must be in PATH
+type FFmpeg struct {
+	Runner *mediatool.Runner
+	// ProbeTimeout bounds each ffprobe run and ProcessTimeout each ffmpeg run
+	ProbeTimeout   time.Duration
+	ProcessTimeout time.Duration
+}
+
+func (f FFmpeg) Probe(ctx context.Context, path string) (Probe, error) {
+	var out bytes.Buffer
+	err := f.Runner.Run(ctx, mediatool.Command{
+		Name:    "ffprobe",
+		Args:    []string{"-v", "error", "-print_format", "json", "-show_streams", "-show_format", path},
+		Timeout: f.ProbeTimeout,
+		Stdout:  &out,
+	})
+	if err != nil {
+		return Probe{}, err
+	}
+	return parseProbe(out.Bytes())
+}
+
+// parseProbe reads ffprobe's JSON output. A missing duration is left as
+// zero, but a file without a video stream is an error.
+func parseProbe(data []byte) (Probe, error) {
+	var result struct {
+		Streams []struct {
+			Width             int    `json:"width"`
+			Height            int    `json:"height"`
+			Codec             string `json:"codec_type"`
+			SampleAspectRatio string `json:"sample_aspect_ratio"`
+			Tags              struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideData []struct {
+				Type     string  `json:"side_data_type"`
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			// ffprobe reports the duration as a string of seconds
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return Probe{}, fmt.Errorf("couldn't parse ffprobe output: %w", err)
+	}
+
+	var probe Probe
+	if seconds, err := strconv.ParseFloat(result.Format.Duration, 64); err == nil && seconds > 0 {
+		probe.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	for _, stream := range result.Streams {
+		if stream.Codec != "video" || stream.Width <= 0 || stream.Height <= 0 {
+			continue
+		}
+		probe.Width, probe.Height = stream.Width, stream.Height
+		// "0:1" means the pixel shape isn't known, so it's taken as square
+		if sar, err := ParseRatio(stream.SampleAspectRatio); err == nil {
+			probe.SampleAspect = sar
+		}
+
+		// older files carry a rotate tag, clockwise. Newer ones have a
+		// display matrix, whose rotation ffprobe reports counterclockwise.
+		if degrees, err := strconv.ParseFloat(stream.Tags.Rotate, 64); err == nil {
+			probe.Rotation = normalizeRotation(degrees)
+		}
+		for _, side := range stream.SideData {
+			if side.Type == "Display Matrix" {
+				probe.Rotation = normalizeRotation(-side.Rotation)
+			}
+		}
+		return probe, nil
+	}
+	return Probe{}, errors.New("no video stream found")
+}
+
+// normalizeRotation rounds degrees to a quarter turn in [0, 360)
+func normalizeRotation(degrees float64) int {
+	quarter := int(math.Round(degrees/90)) % 4
+	if quarter < 0 {
+		quarter += 4
+	}
+	return quarter * 90
+}
+
+func (f FFmpeg) Remux(ctx context.Context, input, output string, duration time.Duration, onProgress func(percent float64)) error {
+	return f.runFFmpeg(ctx, output, duration, onProgress,
+		"-i", input, "-c", "copy", "-movflags", "faststart", "-f", "mp4", output)
+}
+
+func (f FFmpeg) Transcode(ctx context.Context, input, output string, rendition Rendition, duration time.Duration, onProgress func(percent float64)) error {
+	args := []string{"-i", input}
+	args = append(args, encodeArgs(rendition)...)
+	args = append(args, "-movflags", "faststart", "-f", "mp4", output)
+	return f.runFFmpeg(ctx, output, duration, onProgress, args...)
+}
+
+func (f FFmpeg) ExtractFrame(ctx context.Context, input, output string, at time.Duration) error {
+	return f.runFFmpeg(ctx, output, 0, nil,
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64), "-i", input,
+		"-frames:v", "1", "-q:v", "2", "-f", "image2", output)
+}
+
+func (f FFmpeg) PackageHLS(ctx context.Context, input, dir string, renditions []Rendition, subtitles []SubtitleTrack) (string, error) {
+	if len(renditions) == 0 {
+		return "", errors.New("no renditions to package")
+	}
+	for _, rendition := range renditions {
+		playlist := filepath.Join(dir, rendition.Name+".m3u8")
+		args := []string{"-i", input}
+		args = append(args, encodeArgs(rendition)...)
+		args = append(args,
+			"-f", "hls", "-hls_time", "6", "-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(dir, rendition.Name+"_%04d.ts"),
+			playlist,
+		)
+		if err := f.runFFmpeg(ctx, playlist, 0, nil, args...); err != nil {
+			return "", err
+		}
+	}
+	return WriteMasterPlaylist(dir, renditions, subtitles)
+}
+
+// subtitleGroup is the HLS group every subtitle track belongs to
+const subtitleGroup = "subs"
+
+// WriteMasterPlaylist writes the HLS master playlist listing each
+// rendition's playlist, which is named after the rendition, in dir. Each
+// subtitle track gets a playlist of its own, subtitles_<language>.m3u8,
+// holding its WebVTT file as a single segment.
+func WriteMasterPlaylist(dir string, renditions []Rendition, subtitles []SubtitleTrack) (string, error) {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, track := range subtitles {
+		name := "subtitles_" + track.Language + ".m3u8"
+		if err := writeSubtitlePlaylist(filepath.Join(dir, name), track); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"%s\",NAME=\"%s\",LANGUAGE=\"%s\",AUTOSELECT=YES,DEFAULT=NO,URI=\"%s\"\n",
+			subtitleGroup, playlistQuotable(track.Name), playlistQuotable(track.Language), name)
+	}
+	for _, rendition := range renditions {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,NAME=%q", rendition.VideoBitrate+rendition.AudioBitrate, rendition.Name)
+		if len(subtitles) > 0 {
+			fmt.Fprintf(&b, ",SUBTITLES=%q", subtitleGroup)
+		}
+		fmt.Fprintf(&b, "\n%s.m3u8\n", rendition.Name)
+	}
+	path := filepath.Join(dir, MasterPlaylistName)
+	return path, os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// playlistQuotable drops the characters an HLS quoted string can't hold
+func playlistQuotable(s string) string {
+	return strings.NewReplacer(`"`, "", "\n", "", "\r", "").Replace(s)
+}
+
+func writeSubtitlePlaylist(path string, track SubtitleTrack) error {
+	seconds := track.Duration.Seconds()
+	playlist := fmt.Sprintf(
+		"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:%.3f,\n%s\n#EXT-X-ENDLIST\n",
+		max(int(math.Ceil(seconds)), 1), seconds, track.URL)
+	return os.WriteFile(path, []byte(playlist), 0o644)
+}
+
+func encodeArgs(rendition Rendition) []string {
+	return []string{
+		"-vf", fmt.Sprintf("scale=-2:%d", rendition.Height),
+		"-c:v", "libx264", "-preset", "veryfast", "-b:v", strconv.Itoa(rendition.VideoBitrate),
+		"-c:a", "aac", "-b:a", strconv.Itoa(rendition.AudioBitrate),
+	}
+}
+
+// runFFmpeg runs ffmpeg, overwriting output, and removes output if it fails.
+// Progress is written as key=value lines to stdout and parsed as it arrives.
+func (f FFmpeg) runFFmpeg(ctx context.Context, output string, duration time.Duration, onProgress func(percent float64), args ...string) error {
+	if onProgress == nil {
+		onProgress = func(float64) {}
+	}
+	progress, progressWriter := io.Pipe()
+	parsed := make(chan struct{})
+	go func() {
+		parseFFmpegProgress(progress, duration, onProgress)
+		close(parsed)
+	}()
+
+	err := f.Runner.Run(ctx, mediatool.Command{
+		Name:    "ffmpeg",
+		Args:    append([]string{"-y", "-progress", "pipe:1", "-nostats"}, args...),
+		Timeout: f.ProcessTimeout,
+		Stdout:  progressWriter,
+	})
+	progressWriter.Close()
+	<-parsed
+	if err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
+
+// parseFFmpegProgress reads ffmpeg's -progress output until it ends, turning
+// the position of each block into a percentage of the duration
+func parseFFmpegProgress(r io.Reader, duration time.Duration, onProgress func(percent float64)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		// both are the position in microseconds, out_time_ms is just misnamed
+		// and is all older ffmpeg builds write
+		case "out_time_us", "out_time_ms":
+			if duration <= 0 {
+				continue
+			}
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || us < 0 {
+				continue
+			}
+			percent := float64(time.Duration(us)*time.Microsecond) / float64(duration) * 100
+			onProgress(min(percent, 100))
+		case "progress":
+			if value == "end" {
+				onProgress(100)
+			}
+		}
+	}
+	// drain whatever is left so ffmpeg never blocks writing to the pipe
+	io.Copy(io.Discard, r)
+}
@@ -0,0 +1,176 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFFmpegProgress(t *testing.T) {
+	output := strings.Join([]string{
+		"frame=10",
+		"out_time_us=2500000",
+		"out_time=00:00:02.500000",
+		"progress=continue",
+		"out_time_us=N/A",
+		"out_time_us=7500000",
+		"progress=continue",
+		"out_time_us=12000000",
+		"progress=end",
+	}, "\n")
+
+	var got []float64
+	parseFFmpegProgress(strings.NewReader(output), 10*time.Second, func(percent float64) {
+		got = append(got, percent)
+	})
+
+	want := []float64{25, 75, 100, 100}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	// without a duration only the end is reported
+	got = nil
+	parseFFmpegProgress(strings.NewReader(output), 0, func(percent float64) {
+		got = append(got, percent)
+	})
+	if len(got) != 1 || got[0] != 100 {
+		t.Fatalf("expected only the end to be reported, got %v", got)
+	}
+}
+
+func TestParseProbe(t *testing.T) {
+	output := `{
+		"streams": [
+			{"codec_type": "audio"},
+			{"codec_type": "video", "width": 1920, "height": 1080}
+		],
+		"format": {"duration": "12.500000"}
+	}`
+	probe, err := parseProbe([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Probe{Width: 1920, Height: 1080, Duration: 12500 * time.Millisecond}
+	if probe != want {
+		t.Errorf("expected %+v, got %+v", want, probe)
+	}
+
+	if _, err := parseProbe([]byte(`{"streams": [{"codec_type": "audio"}], "format": {}}`)); err == nil {
+		t.Error("expected a file without video to be an error")
+	}
+}
+
+func TestParseProbeRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   string
+	}{
+		{"plain", `"width": 1920, "height": 1080`, "16:9"},
+		{"rotate tag", `"width": 1920, "height": 1080, "tags": {"rotate": "90"}`, "9:16"},
+		{"display matrix", `"width": 1920, "height": 1080, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]`, "9:16"},
+		{"upside down", `"width": 1920, "height": 1080, "side_data_list": [{"side_data_type": "Display Matrix", "rotation": 180}]`, "16:9"},
+		{"anamorphic", `"width": 720, "height": 480, "sample_aspect_ratio": "32:27"`, "16:9"},
+		{"unknown pixel shape", `"width": 640, "height": 480, "sample_aspect_ratio": "0:1"`, "4:3"},
+	}
+	for _, tt := range tests {
+		probe, err := parseProbe([]byte(`{"streams": [{"codec_type": "video", ` + tt.stream + `}]}`))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := probe.DisplayAspect().String(); got != tt.want {
+			t.Errorf("%s: display aspect = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{1920, 1080, "landscape"},
+		{854, 480, "landscape"},
+		{1080, 1920, "portrait"},
+		{1000, 1000, "square"},
+		{640, 480, "standard"},
+		{2560, 1080, "ultrawide"},
+		{1920, 803, "ultrawide"},
+		{1850, 1000, "other"},
+		{0, 0, "other"},
+	}
+	for _, tt := range tests {
+		ratio := Ratio{tt.width, tt.height}.Reduce()
+		if got := DefaultBuckets.Classify(ratio); got != tt.want {
+			t.Errorf("Classify(%dx%d) = %q, want %q", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	buckets, err := ParseBuckets("wide=16:9, cinema=2.39:1,tall=9:16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Buckets{{"wide", Ratio{16, 9}}, {"cinema", Ratio{239, 100}}, {"tall", Ratio{9, 16}}}
+	if len(buckets) != len(want) {
+		t.Fatalf("buckets = %+v, want %+v", buckets, want)
+	}
+	for i := range want {
+		if buckets[i] != want[i] {
+			t.Fatalf("buckets = %+v, want %+v", buckets, want)
+		}
+	}
+
+	for _, spec := range []string{"", "wide", "wide=16", "../up=1:1", "wide=0:9"} {
+		if _, err := ParseBuckets(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestWriteMasterPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	renditions := []Rendition{{Name: "720p", Height: 720, VideoBitrate: 2_500_000, AudioBitrate: 128_000}}
+	subtitles := []SubtitleTrack{{Language: "pt-BR", Name: "Português", URL: "https://cdn.test/captions/pt-BR.vtt", Duration: 90500 * time.Millisecond}}
+
+	path, err := WriteMasterPlaylist(dir, renditions, subtitles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantMaster := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"Português\",LANGUAGE=\"pt-BR\",AUTOSELECT=YES,DEFAULT=NO,URI=\"subtitles_pt-BR.m3u8\"\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2628000,NAME=\"720p\",SUBTITLES=\"subs\"\n720p.m3u8\n"
+	if string(master) != wantMaster {
+		t.Errorf("master playlist =\n%s\nwant\n%s", master, wantMaster)
+	}
+
+	subtitlePlaylist, err := os.ReadFile(filepath.Join(dir, "subtitles_pt-BR.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(subtitlePlaylist), "#EXT-X-TARGETDURATION:91\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:90.500,\nhttps://cdn.test/captions/pt-BR.vtt\n") {
+		t.Errorf("unexpected subtitle playlist:\n%s", subtitlePlaylist)
+	}
+
+	// without subtitles the streams don't name a group
+	if _, err := WriteMasterPlaylist(dir, renditions, nil); err != nil {
+		t.Fatal(err)
+	}
+	master, _ = os.ReadFile(path)
+	if strings.Contains(string(master), "SUBTITLES") {
+		t.Errorf("expected no subtitles, got\n%s", master)
+	}
+}
@@ -0,0 +1,221 @@
+// Package media probes and converts uploaded videos. Processor is the set of
+// operations the server needs; FFmpeg implements it with the ffmpeg and
+// ffprobe tools and mediatest.Fake stands in for it in tests.
+package media
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Processor works on video files on local disk. Operations that write a file
+// are given its path and overwrite it. onProgress, when it isn't nil, is
+// called with the percentage done.
+type Processor interface {
+	// Probe describes the first video stream and the container's duration
+	Probe(ctx context.Context, path string) (Probe, error)
+	// Remux copies the streams into an MP4 with the index at the front, so
+	// playback can start before the download finishes
+	Remux(ctx context.Context, input, output string, duration time.Duration, onProgress func(percent float64)) error
+	// Transcode re-encodes the video as an H.264/AAC MP4 at the rendition's
+	// height and bitrates
+	Transcode(ctx context.Context, input, output string, rendition Rendition, duration time.Duration, onProgress func(percent float64)) error
+	// ExtractFrame writes the frame at the given offset as a JPEG
+	ExtractFrame(ctx context.Context, input, output string, at time.Duration) error
+	// PackageHLS encodes each rendition as an HLS stream in dir and writes a
+	// master playlist listing them and the subtitle tracks, returning its path
+	PackageHLS(ctx context.Context, input, dir string, renditions []Rendition, subtitles []SubtitleTrack) (string, error)
+}
+
+// Probe is what's known about a video file
+type Probe struct {
+	// Width and Height are the stored frame size in pixels
+	Width  int
+	Height int
+	// SampleAspect is the shape of a pixel, zero for square pixels
+	SampleAspect Ratio
+	// Rotation is how far the frame is turned clockwise for display: 0, 90,
+	// 180 or 270 degrees
+	Rotation int
+	Duration time.Duration
+}
+
+// DisplayAspect is the reduced aspect ratio the video is shown at, after
+// stretching non-square pixels and applying the rotation. It's zero if the
+// size isn't known.
+func (p Probe) DisplayAspect() Ratio {
+	r := Ratio{p.Width, p.Height}
+	if p.SampleAspect.Valid() {
+		r = Ratio{p.Width * p.SampleAspect.Width, p.Height * p.SampleAspect.Height}
+	}
+	if p.Rotation == 90 || p.Rotation == 270 {
+		r = Ratio{r.Height, r.Width}
+	}
+	return r.Reduce()
+}
+
+// Rendition is one size of a transcoded video
+type Rendition struct {
+	// Name is used for the rendition's files, e.g. "720p"
+	Name   string
+	Height int
+	// bitrates are in bits per second
+	VideoBitrate int
+	AudioBitrate int
+}
+
+// SubtitleTrack is a WebVTT file offered alongside an HLS stream
+type SubtitleTrack struct {
+	// Language is a BCP 47 tag and Name is what viewers pick the track by
+	Language string
+	Name     string
+	// URL is where the WebVTT file is served from
+	URL string
+	// Duration is the length of the video the track covers
+	Duration time.Duration
+}
+
+// MasterPlaylistName is the name of the playlist PackageHLS writes
+const MasterPlaylistName = "master.m3u8"
+
+// Ratio is an aspect ratio, width:height
+type Ratio struct {
+	Width  int
+	Height int
+}
+
+// ParseRatio reads a ratio written like "16:9" or "2.39:1"
+func ParseRatio(s string) (Ratio, error) {
+	w, h, ok := strings.Cut(s, ":")
+	if !ok {
+		return Ratio{}, fmt.Errorf("aspect ratio %q isn't width:height", s)
+	}
+	width, errW := strconv.ParseFloat(strings.TrimSpace(w), 64)
+	height, errH := strconv.ParseFloat(strings.TrimSpace(h), 64)
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return Ratio{}, fmt.Errorf("aspect ratio %q isn't width:height", s)
+	}
+	// fractional sides like 2.39 are scaled to whole numbers
+	const scale = 1000
+	return Ratio{int(math.Round(width * scale)), int(math.Round(height * scale))}.Reduce(), nil
+}
+
+// Valid reports whether both sides are positive
+func (r Ratio) Valid() bool {
+	return r.Width > 0 && r.Height > 0
+}
+
+// Reduce divides both sides by their greatest common divisor
+func (r Ratio) Reduce() Ratio {
+	if !r.Valid() {
+		return Ratio{}
+	}
+	a, b := r.Width, r.Height
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return Ratio{r.Width / a, r.Height / a}
+}
+
+// Float is width divided by height, zero if the ratio isn't valid
+func (r Ratio) Float() float64 {
+	if !r.Valid() {
+		return 0
+	}
+	return float64(r.Width) / float64(r.Height)
+}
+
+func (r Ratio) String() string {
+	return fmt.Sprintf("%d:%d", r.Width, r.Height)
+}
+
+// Orientation names the shape of a ratio: "landscape", "portrait" or
+// "square", or "" if it isn't valid
+func (r Ratio) Orientation() string {
+	switch {
+	case !r.Valid():
+		return ""
+	case r.Width > r.Height:
+		return "landscape"
+	case r.Width < r.Height:
+		return "portrait"
+	default:
+		return "square"
+	}
+}
+
+// Bucket groups videos with an aspect ratio close to Ratio under Name
+type Bucket struct {
+	Name  string
+	Ratio Ratio
+}
+
+// Buckets is a table of aspect ratio buckets. The closest bucket within
+// BucketTolerance of a video's ratio is the one it's filed under.
+type Buckets []Bucket
+
+// BucketTolerance is how far, as a fraction of the bucket's ratio, a video's
+// ratio can be from a bucket and still be filed under it. It absorbs sizes
+// like 854x480 that are 16:9 once rounded.
+const BucketTolerance = 0.02
+
+// OtherBucket is where videos matching no bucket, or of unknown size, go
+const OtherBucket = "other"
+
+// DefaultBuckets keeps the original landscape and portrait folders and adds
+// the other common shapes
+var DefaultBuckets = Buckets{
+	{"landscape", Ratio{16, 9}},
+	{"portrait", Ratio{9, 16}},
+	{"square", Ratio{1, 1}},
+	{"standard", Ratio{4, 3}},
+	{"standard-portrait", Ratio{3, 4}},
+	{"ultrawide", Ratio{21, 9}},
+	{"ultrawide", Ratio{239, 100}},
+}
+
+// ParseBuckets reads a bucket table written as "name=W:H,...". A name can
+// be given more than once to cover several ratios.
+func ParseBuckets(spec string) (Buckets, error) {
+	var buckets Buckets
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, ratio, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, "/\\ ") {
+			return nil, fmt.Errorf("aspect bucket %q isn't name=W:H", entry)
+		}
+		r, err := ParseRatio(ratio)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, Bucket{Name: name, Ratio: r})
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no aspect buckets in %q", spec)
+	}
+	return buckets, nil
+}
+
+// Classify returns the name of the closest bucket to r, or OtherBucket
+func (b Buckets) Classify(r Ratio) string {
+	if !r.Valid() {
+		return OtherBucket
+	}
+	best, bestDistance := OtherBucket, math.Inf(1)
+	for _, bucket := range b {
+		target := bucket.Ratio.Float()
+		distance := math.Abs(r.Float()-target) / target
+		if distance <= BucketTolerance && distance < bestDistance {
+			best, bestDistance = bucket.Name, distance
+		}
+	}
+	return best
+}
@@ -0,0 +1,173 @@
+// Package mediatest provides an in-memory media.Processor for tests, so code
+// that probes and converts videos can run without ffmpeg installed.
+package mediatest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// Operation names, as recorded in Call.Op and passed to Fail
+const (
+	OpProbe        = "probe"
+	OpRemux        = "remux"
+	OpTranscode    = "transcode"
+	OpExtractFrame = "extract-frame"
+	OpPackageHLS   = "package-hls"
+)
+
+// Call records one operation the fake was asked to do
+type Call struct {
+	Op     string
+	Input  string
+	Output string
+}
+
+type probeResult struct {
+	probe media.Probe
+	err   error
+}
+
+// Fake is a media.Processor that returns scripted probe results and copies
+// its input for every conversion, so the output is the bytes that went in.
+// It's safe for concurrent use.
+type Fake struct {
+	mu     sync.Mutex
+	probes []probeResult
+	// DefaultProbe is returned once the scripted results run out
+	DefaultProbe media.Probe
+	failures     map[string]error
+	calls        []Call
+}
+
+// NewFake returns a fake that probes every file as a 16:9 one minute video
+func NewFake() *Fake {
+	return &Fake{
+		DefaultProbe: media.Probe{Width: 1920, Height: 1080, Duration: time.Minute},
+		failures:     map[string]error{},
+	}
+}
+
+// QueueProbe scripts the result of the next Probe call. Results are
+// returned in the order they're queued.
+func (f *Fake) QueueProbe(probe media.Probe, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probes = append(f.probes, probeResult{probe: probe, err: err})
+}
+
+// Fail makes every later call of op return err, or succeed again if err is nil
+func (f *Fake) Fail(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.failures, op)
+		return
+	}
+	f.failures[op] = err
+}
+
+// Calls returns the operations done so far, in order
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// record notes the call and returns the failure scripted for op, if any
+func (f *Fake) record(op, input, output string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, Call{Op: op, Input: input, Output: output})
+	return f.failures[op]
+}
+
+func (f *Fake) Probe(ctx context.Context, path string) (media.Probe, error) {
+	if err := f.record(OpProbe, path, ""); err != nil {
+		return media.Probe{}, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return media.Probe{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.probes) == 0 {
+		return f.DefaultProbe, nil
+	}
+	result := f.probes[0]
+	f.probes = f.probes[1:]
+	return result.probe, result.err
+}
+
+func (f *Fake) Remux(ctx context.Context, input, output string, duration time.Duration, onProgress func(percent float64)) error {
+	if err := f.record(OpRemux, input, output); err != nil {
+		return err
+	}
+	return convert(ctx, input, output, onProgress)
+}
+
+func (f *Fake) Transcode(ctx context.Context, input, output string, rendition media.Rendition, duration time.Duration, onProgress func(percent float64)) error {
+	if err := f.record(OpTranscode, input, output); err != nil {
+		return err
+	}
+	return convert(ctx, input, output, onProgress)
+}
+
+func (f *Fake) ExtractFrame(ctx context.Context, input, output string, at time.Duration) error {
+	if err := f.record(OpExtractFrame, input, output); err != nil {
+		return err
+	}
+	// the smallest file that still looks like a JPEG
+	return os.WriteFile(output, []byte{0xff, 0xd8, 0xff, 0xd9}, 0o644)
+}
+
+func (f *Fake) PackageHLS(ctx context.Context, input, dir string, renditions []media.Rendition, subtitles []media.SubtitleTrack) (string, error) {
+	if err := f.record(OpPackageHLS, input, dir); err != nil {
+		return "", err
+	}
+	for _, rendition := range renditions {
+		playlist := fmt.Sprintf("#EXTM3U\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:6.0,\n%s_0000.ts\n#EXT-X-ENDLIST\n", rendition.Name)
+		if err := os.WriteFile(filepath.Join(dir, rendition.Name+".m3u8"), []byte(playlist), 0o644); err != nil {
+			return "", err
+		}
+		if err := convert(ctx, input, filepath.Join(dir, rendition.Name+"_0000.ts"), nil); err != nil {
+			return "", err
+		}
+	}
+	return media.WriteMasterPlaylist(dir, renditions, subtitles)
+}
+
+// convert copies input to output, reporting the copy as done
+func convert(ctx context.Context, input, output string, onProgress func(percent float64)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	in, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if onProgress != nil {
+		onProgress(100)
+	}
+	return nil
+}
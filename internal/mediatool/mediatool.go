@@ -0,0 +1,169 @@
+// Package mediatool runs external media tools like ffmpeg and ffprobe. Every
+// run is bounded by a context and a timeout, the number running at once is
+// limited, and a failed run's stderr is kept in the returned *Error.
+package mediatool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxStderr is how much of the end of a tool's stderr is kept
+const maxStderr = 16 << 10
+
+// waitDelay is how long a killed tool gets to close its output before Run
+// gives up on it
+const waitDelay = 5 * time.Second
+
+// Command is a single run of a tool
+type Command struct {
+	Name string
+	Args []string
+	// Timeout bounds the run, including any time spent waiting for a slot.
+	// Zero means only the context applies.
+	Timeout time.Duration
+	// Stdout receives the tool's output, which is discarded if it's nil
+	Stdout io.Writer
+}
+
+// Runner runs tools, at most a fixed number at a time
+type Runner struct {
+	slots chan struct{}
+}
+
+// NewRunner returns a runner allowing maxConcurrent tools to run at once.
+// It's at least one.
+func NewRunner(maxConcurrent int) *Runner {
+	return &Runner{slots: make(chan struct{}, max(maxConcurrent, 1))}
+}
+
+// Error describes a tool that couldn't be started, failed, or was stopped
+type Error struct {
+	Name     string
+	Args     []string
+	ExitCode int
+	// Stderr is the end of what the tool wrote to stderr
+	Stderr   string
+	TimedOut bool
+	Err      error
+}
+
+func (e *Error) Error() string {
+	var msg string
+	switch {
+	case e.TimedOut:
+		msg = e.Name + " timed out"
+	case errors.Is(e.Err, context.Canceled):
+		msg = e.Name + " was cancelled"
+	case e.ExitCode > 0:
+		msg = fmt.Sprintf("%s exited with status %d", e.Name, e.ExitCode)
+	default:
+		msg = fmt.Sprintf("%s failed: %v", e.Name, e.Err)
+	}
+	if line := lastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// LogValue logs the error with the tool's exit code and stderr alongside
+func (e *Error) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("msg", e.Error()),
+		slog.String("tool", e.Name),
+		slog.Int("exit_code", e.ExitCode),
+		slog.Bool("timed_out", e.TimedOut),
+		slog.String("stderr", e.Stderr),
+	)
+}
+
+// Run runs cmd and waits for it to finish. If ctx is done or the timeout
+// passes first, the tool and anything it started are killed and the error
+// wraps ctx.Err(), context.DeadlineExceeded for a timeout.
+func (r *Runner) Run(ctx context.Context, cmd Command) error {
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
+	}
+	fail := func(err error, exitCode int, stderr string) error {
+		return &Error{
+			Name:     cmd.Name,
+			Args:     cmd.Args,
+			ExitCode: exitCode,
+			Stderr:   stderr,
+			TimedOut: errors.Is(err, context.DeadlineExceeded),
+			Err:      err,
+		}
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+		return fail(ctx.Err(), -1, "")
+	}
+
+	stderr := &tailBuffer{limit: maxStderr}
+	c := exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+	c.Stdout = cmd.Stdout
+	c.Stderr = stderr
+	c.WaitDelay = waitDelay
+	killProcessGroup(c)
+
+	err := c.Run()
+	if err == nil {
+		return nil
+	}
+	// a killed tool's exit status says nothing useful, the context says why
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fail(ctxErr, -1, stderr.String())
+	}
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	return fail(err, exitCode, stderr.String())
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > b.limit {
+		p = p[len(p)-b.limit:]
+	}
+	if over := b.buf.Len() + len(p) - b.limit; over > 0 {
+		b.buf.Next(over)
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *tailBuffer) String() string {
+	return b.buf.String()
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(s)
+}
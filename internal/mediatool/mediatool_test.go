@@ -0,0 +1,126 @@
+package mediatool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func sh(script string) Command {
+	return Command{Name: "sh", Args: []string{"-c", script}}
+}
+
+func TestRunCapturesOutput(t *testing.T) {
+	runner := NewRunner(1)
+
+	var out bytes.Buffer
+	cmd := sh("echo hello")
+	cmd.Stdout = &out
+	if err := runner.Run(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("expected stdout to be captured, got %q", out.String())
+	}
+
+	err := runner.Run(context.Background(), sh("echo noise >&2; echo 'Invalid data found' >&2; exit 3"))
+	var toolErr *Error
+	if !errors.As(err, &toolErr) {
+		t.Fatalf("expected a *Error, got %v", err)
+	}
+	if toolErr.ExitCode != 3 || toolErr.TimedOut || !strings.Contains(toolErr.Stderr, "noise") {
+		t.Errorf("unexpected error: %+v", toolErr)
+	}
+	if err.Error() != "sh exited with status 3: Invalid data found" {
+		t.Errorf("expected the last stderr line in the message, got %q", err.Error())
+	}
+
+	err = runner.Run(context.Background(), Command{Name: "tubely-no-such-tool"})
+	if !errors.As(err, &toolErr) || toolErr.ExitCode != -1 {
+		t.Errorf("expected a missing tool to be reported, got %v", err)
+	}
+}
+
+func TestRunTimeoutKillsProcessGroup(t *testing.T) {
+	runner := NewRunner(1)
+	marker := filepath.Join(t.TempDir(), "survived")
+
+	// the child outlives its parent's kill unless the whole group is killed
+	cmd := sh("(sleep 1; touch " + marker + ") & sleep 30")
+	cmd.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	err := runner.Run(context.Background(), cmd)
+
+	var toolErr *Error
+	if !errors.As(err, &toolErr) || !toolErr.TimedOut || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the tool to be killed promptly, took %s", elapsed)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the tool's child process to be killed too")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	runner := NewRunner(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := runner.Run(ctx, sh("sleep 30"))
+	if !errors.Is(err, context.Canceled) || err.Error() != "sh was cancelled" {
+		t.Fatalf("expected the run to be cancelled, got %v", err)
+	}
+}
+
+func TestRunLimitsConcurrency(t *testing.T) {
+	runner := NewRunner(2)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runner.Run(context.Background(), sh("sleep 0.2")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Errorf("expected six runs two at a time to take three rounds, took %s", elapsed)
+	}
+
+	// a run waiting for a slot gives up with its context
+	runner.slots <- struct{}{}
+	runner.slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := runner.Run(ctx, sh("true"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a queued run to time out waiting for a slot, got %v", err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 8}
+	b.Write([]byte("abcdef"))
+	b.Write([]byte("ghij"))
+	if b.String() != "cdefghij" {
+		t.Errorf("expected the last 8 bytes, got %q", b.String())
+	}
+	b.Write([]byte("0123456789"))
+	if b.String() != "23456789" {
+		t.Errorf("expected the last 8 bytes, got %q", b.String())
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package mediatool
+
+import "os/exec"
+
+// killProcessGroup leaves the default of killing only the tool itself
+func killProcessGroup(c *exec.Cmd) {}
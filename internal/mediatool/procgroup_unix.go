@@ -0,0 +1,17 @@
+//go:build unix
+
+package mediatool
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup starts the tool in its own process group and kills the
+// whole group when its context is done, so nothing it spawned is left behind
+func killProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Cancel = func() error {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+}
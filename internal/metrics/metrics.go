@@ -0,0 +1,235 @@
+// Package metrics collects the server's Prometheus metrics: HTTP traffic,
+// media tool runs, object store operations and database statements.
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tubely"
+
+// slowBuckets covers requests and media runs that can take minutes, like
+// video uploads and remuxing
+var slowBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var queryBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, 1}
+
+// Metrics holds the server's collectors. A nil *Metrics records nothing, so
+// code that's handed one doesn't have to check.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	requestBytes    *prometheus.CounterVec
+
+	mediaDuration *prometheus.HistogramVec
+	mediaFailures *prometheus.CounterVec
+
+	storeDuration *prometheus.HistogramVec
+	storeFailures *prometheus.CounterVec
+	storeBytes    *prometheus.CounterVec
+
+	queryDuration *prometheus.HistogramVec
+	queryFailures *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests served, by mux pattern and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by mux pattern.",
+			Buckets:   slowBuckets,
+		}, []string{"method", "route"}),
+		requestBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_request_body_bytes_total",
+			Help:      "Bytes read from request bodies, by mux pattern. For the upload routes this is the uploaded media.",
+		}, []string{"method", "route"}),
+
+		mediaDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "media_command_duration_seconds",
+			Help:      "Time taken by ffmpeg and ffprobe runs, by tool and pipeline stage.",
+			Buckets:   slowBuckets,
+		}, []string{"tool", "stage"}),
+		mediaFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "media_command_failures_total",
+			Help:      "ffmpeg and ffprobe runs that failed, by tool and pipeline stage.",
+		}, []string{"tool", "stage"}),
+
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Time taken by object store operations, by store and operation.",
+			Buckets:   slowBuckets,
+		}, []string{"store", "operation"}),
+		storeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_operation_failures_total",
+			Help:      "Object store operations that failed, by store and operation.",
+		}, []string{"store", "operation"}),
+		storeBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "store_put_bytes_total",
+			Help:      "Bytes written to the object stores.",
+		}, []string{"store"}),
+
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time taken by database statements, by the function that ran them.",
+			Buckets:   queryBuckets,
+		}, []string{"query"}),
+		queryFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_query_failures_total",
+			Help:      "Database statements that failed, by the function that ran them.",
+		}, []string{"query"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.requestBytes,
+		m.mediaDuration, m.mediaFailures,
+		m.storeDuration, m.storeFailures, m.storeBytes,
+		m.queryDuration, m.queryFailures,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a served request. route is the mux pattern it
+// matched, so IDs in the path don't make a series each.
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration, bodyBytes int64) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+	m.requestBytes.WithLabelValues(method, route).Add(float64(bodyBytes))
+}
+
+// ObserveMedia records a run of a media tool such as ffmpeg or ffprobe
+func (m *Metrics) ObserveMedia(tool, stage string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mediaDuration.WithLabelValues(tool, stage).Observe(duration.Seconds())
+	if err != nil {
+		m.mediaFailures.WithLabelValues(tool, stage).Inc()
+	}
+}
+
+// ObserveQuery records a database statement. It has the signature of a
+// database.QueryObserver.
+func (m *Metrics) ObserveQuery(query string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(query).Observe(duration.Seconds())
+	if err != nil {
+		m.queryFailures.WithLabelValues(query).Inc()
+	}
+}
+
+func (m *Metrics) observeStore(store, operation string, duration time.Duration, err error) {
+	m.storeDuration.WithLabelValues(store, operation).Observe(duration.Seconds())
+	if err != nil {
+		m.storeFailures.WithLabelValues(store, operation).Inc()
+	}
+}
+
+// Store wraps an object store so its operations are recorded under name
+func (m *Metrics) Store(name string, store storage.Store) storage.Store {
+	if m == nil {
+		return store
+	}
+	return instrumentedStore{Store: store, name: name, metrics: m}
+}
+
+type instrumentedStore struct {
+	storage.Store
+	name    string
+	metrics *Metrics
+}
+
+func (s instrumentedStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	counted := &countingReader{r: body}
+	// keep the body seekable, the S3 client needs to rewind it to retry
+	var reader io.Reader = counted
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		reader = countingReadSeeker{countingReader: counted, seeker: seeker}
+	}
+
+	start := time.Now()
+	err := s.Store.Put(ctx, key, reader, contentType)
+	s.metrics.observeStore(s.name, "put", time.Since(start), err)
+	if err == nil {
+		s.metrics.storeBytes.WithLabelValues(s.name).Add(float64(counted.n))
+	}
+	return err
+}
+
+func (s instrumentedStore) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := s.Store.Delete(ctx, key)
+	s.metrics.observeStore(s.name, "delete", time.Since(start), err)
+	return err
+}
+
+// Unwrap returns the store being instrumented
+func (s instrumentedStore) Unwrap() storage.Store {
+	return s.Store
+}
+
+// countingReader counts the bytes read through it. After a seek the count is
+// the furthest offset reached, so a retried upload isn't counted twice.
+type countingReader struct {
+	r   io.Reader
+	pos int64
+	n   int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.pos += int64(n)
+	c.n = max(c.n, c.pos)
+	return n, err
+}
+
+type countingReadSeeker struct {
+	*countingReader
+	seeker io.Seeker
+}
+
+func (c countingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.seeker.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
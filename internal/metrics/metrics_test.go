@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// retryingStore reads the body twice, rewinding in between, like the S3
+// client does when it retries a PUT
+type retryingStore struct {
+	fail bool
+}
+
+func (s retryingStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	io.Copy(io.Discard, body)
+	if seeker, ok := body.(io.Seeker); ok {
+		seeker.Seek(0, io.SeekStart)
+		io.Copy(io.Discard, body)
+	}
+	if s.fail {
+		return errors.New("bucket is full")
+	}
+	return nil
+}
+
+func (s retryingStore) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+func (s retryingStore) URL(key string) string {
+	return "https://example.com/" + key
+}
+
+func TestStore(t *testing.T) {
+	m := New()
+	store := m.Store("s3", retryingStore{})
+
+	err := store.Put(context.Background(), "a.mp4", bytes.NewReader(make([]byte, 1000)), "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.storeBytes.WithLabelValues("s3")); got != 1000 {
+		t.Fatalf("expected a retried put to be counted once, got %v bytes", got)
+	}
+	if got := testutil.CollectAndCount(m.storeDuration); got != 1 {
+		t.Fatalf("expected one put to be timed, got %d series", got)
+	}
+
+	failing := m.Store("s3", retryingStore{fail: true})
+	failing.Put(context.Background(), "b.mp4", strings.NewReader("data"), "video/mp4")
+	if got := testutil.ToFloat64(m.storeFailures.WithLabelValues("s3", "put")); got != 1 {
+		t.Fatalf("expected the failed put to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.storeBytes.WithLabelValues("s3")); got != 1000 {
+		t.Fatalf("expected a failed put's bytes not to be counted, got %v", got)
+	}
+
+	if _, ok := storage.Unwrap(store).(retryingStore); !ok {
+		t.Fatal("expected Unwrap to return the instrumented store")
+	}
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("GET", "GET /", 200, 0, 0)
+	m.ObserveMedia("ffmpeg", "fast-start", 0, errors.New("exit status 1"))
+	m.ObserveQuery("Client.GetVideo", 0, nil)
+
+	store := retryingStore{}
+	if m.Store("s3", store) != storage.Store(store) {
+		t.Fatal("expected the store to be returned unwrapped")
+	}
+}
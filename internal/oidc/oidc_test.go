@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://tubely.test/api/oidc/test/callback"
+
+func newTestProvider(t *testing.T) (*oidctest.IdP, *Provider) {
+	t.Helper()
+
+	idp, err := oidctest.NewIdP("tubely", "shh")
+	if err != nil {
+		t.Fatalf("couldn't start identity provider: %v", err)
+	}
+	t.Cleanup(idp.Close)
+
+	provider, err := NewProvider(context.Background(), ProviderConfig{
+		Name:         "test",
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "tubely",
+		ClientSecret: "shh",
+		RedirectURL:  redirectURL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	return idp, provider
+}
+
+// authorize follows the authorization URL and returns the code from the redirect
+func authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	return location.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SetIdentity(oidctest.Identity{
+		Subject:       "user-123",
+		Email:         "boots@example.com",
+		EmailVerified: true,
+	})
+
+	verifier := NewCodeVerifier()
+	code := authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if identity.Subject != "user-123" || identity.Email != "boots@example.com" || !identity.EmailVerified {
+		t.Errorf("Exchange() identity = %+v", identity)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SetIdentity(oidctest.Identity{Subject: "user-123"})
+
+	code := authorize(t, provider.AuthCodeURL("state", "nonce", NewCodeVerifier()))
+
+	_, err := provider.Exchange(context.Background(), code, NewCodeVerifier(), "nonce")
+	if err == nil {
+		t.Fatal("Exchange() with a different code verifier succeeded")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SetIdentity(oidctest.Identity{Subject: "user-123"})
+
+	verifier := NewCodeVerifier()
+	code := authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+
+	_, err := provider.Exchange(context.Background(), code, verifier, "other-nonce")
+	if !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("Exchange() error = %v, want %v", err, ErrNonceMismatch)
+	}
+}
@@ -0,0 +1,168 @@
+// Package progress tracks how far along each video upload is so clients can
+// follow it while the upload request is still running.
+package progress
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Stage string
+
+const (
+	StageReceiving Stage = "receiving"
+	StageProbing   Stage = "probing"
+	StageFastStart Stage = "fast-start"
+	StageUploading Stage = "uploading"
+	StageDone      Stage = "done"
+	StageFailed    Stage = "failed"
+)
+
+// Finished reports whether no more updates follow this stage
+func (s Stage) Finished() bool {
+	return s == StageDone || s == StageFailed
+}
+
+type Update struct {
+	Stage Stage `json:"stage"`
+	// BytesDone and BytesTotal are set while receiving and uploading. Total
+	// is 0 if it isn't known.
+	BytesDone  int64 `json:"bytes_done"`
+	BytesTotal int64 `json:"bytes_total"`
+	// Percent is how far through the current stage the upload is, or 0 if
+	// that isn't known
+	Percent float64 `json:"percent"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// finishedTTL is how long a finished upload's last update is kept for
+// clients that connect late
+const finishedTTL = time.Minute
+
+// Tracker holds the latest update for each video being uploaded. Watchers are
+// only told that something changed and read the latest update themselves, so
+// a slow watcher skips updates rather than holding up the upload.
+type Tracker struct {
+	mu     sync.Mutex
+	videos map[uuid.UUID]*video
+}
+
+type video struct {
+	latest   Update
+	seq      uint64
+	watchers map[chan struct{}]struct{}
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{videos: map[uuid.UUID]*video{}}
+}
+
+func (t *Tracker) get(videoID uuid.UUID) *video {
+	v, ok := t.videos[videoID]
+	if !ok {
+		v = &video{watchers: map[chan struct{}]struct{}{}}
+		t.videos[videoID] = v
+	}
+	return v
+}
+
+// Publish records the latest update for a video and wakes its watchers
+func (t *Tracker) Publish(videoID uuid.UUID, update Update) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v := t.get(videoID)
+	v.latest = update
+	v.seq++
+	for watcher := range v.watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+
+	if update.Stage.Finished() {
+		seq := v.seq
+		time.AfterFunc(finishedTTL, func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			// only forget the upload if nothing new was published since
+			if v, ok := t.videos[videoID]; ok && v.seq == seq && len(v.watchers) == 0 {
+				delete(t.videos, videoID)
+			}
+		})
+	}
+}
+
+// Latest returns the most recent update for a video and a sequence number
+// that changes whenever a new update is published. It returns false if no
+// upload has reported progress recently.
+func (t *Tracker) Latest(videoID uuid.UUID) (Update, uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.videos[videoID]
+	if !ok || v.seq == 0 {
+		return Update{}, 0, false
+	}
+	return v.latest, v.seq, true
+}
+
+// Watch returns a channel that receives a value whenever the video's
+// progress changes. Call stop once done watching.
+func (t *Tracker) Watch(videoID uuid.UUID) (changed <-chan struct{}, stop func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	watcher := make(chan struct{}, 1)
+	t.get(videoID).watchers[watcher] = struct{}{}
+	return watcher, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if v, ok := t.videos[videoID]; ok {
+			delete(v.watchers, watcher)
+			if len(v.watchers) == 0 && (v.seq == 0 || v.latest.Stage.Finished()) {
+				delete(t.videos, videoID)
+			}
+		}
+	}
+}
+
+// Reader counts the bytes read through it, calling report with the running
+// total. Seeking moves the count with the offset so a reader that is rewound
+// and read again reports correctly.
+type Reader struct {
+	r      io.Reader
+	n      int64
+	report func(n int64)
+}
+
+func NewReader(r io.Reader, report func(n int64)) *Reader {
+	return &Reader{r: r, report: report}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.report(r.n)
+	}
+	return n, err
+}
+
+// Seek is only supported if the underlying reader is an io.Seeker
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("progress: underlying reader can't seek")
+	}
+	n, err := seeker.Seek(offset, whence)
+	if err == nil {
+		r.n = n
+	}
+	return n, err
+}
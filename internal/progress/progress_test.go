@@ -0,0 +1,70 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTrackerWatch(t *testing.T) {
+	tracker := NewTracker()
+	videoID := uuid.New()
+
+	if _, _, ok := tracker.Latest(videoID); ok {
+		t.Fatal("expected no progress before an upload starts")
+	}
+
+	changed, stop := tracker.Watch(videoID)
+	tracker.Publish(videoID, Update{Stage: StageReceiving, BytesDone: 10, BytesTotal: 100})
+	tracker.Publish(videoID, Update{Stage: StageProbing})
+
+	// a watcher is told something changed once, however many updates it missed
+	<-changed
+	select {
+	case <-changed:
+		t.Fatal("expected updates to be coalesced")
+	default:
+	}
+	update, seq, ok := tracker.Latest(videoID)
+	if !ok || update.Stage != StageProbing || seq != 2 {
+		t.Fatalf("expected the probing update, got %+v (seq %d)", update, seq)
+	}
+
+	stop()
+	tracker.Publish(videoID, Update{Stage: StageDone})
+	if update, _, _ := tracker.Latest(videoID); update.Stage != StageDone {
+		t.Fatalf("expected a finished upload to be kept for late watchers, got %+v", update)
+	}
+}
+
+func TestReaderCountsAndSeeks(t *testing.T) {
+	var reported []int64
+	r := NewReader(strings.NewReader("hello world"), func(n int64) {
+		reported = append(reported, n)
+	})
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	all, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != "hello world" {
+		t.Fatalf("unexpected body %q", all)
+	}
+	if reported[0] != 5 || reported[len(reported)-1] != 11 {
+		t.Fatalf("expected counts to restart after seeking, got %v", reported)
+	}
+
+	if _, err := NewReader(io.MultiReader(&bytes.Buffer{}), func(int64) {}).Seek(0, io.SeekStart); err == nil {
+		t.Fatal("expected seeking a reader that can't seek to fail")
+	}
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// DatabaseStore keeps buckets in the database so limits survive restarts and
+// are shared by every server using the same database
+type DatabaseStore struct {
+	db database.Client
+	// mu serializes updates from this process so SQLite doesn't report busy
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewDatabaseStore(db database.Client) *DatabaseStore {
+	return &DatabaseStore{db: db, lastSweep: time.Now()}
+}
+
+func (s *DatabaseStore) Update(ctx context.Context, key string, fn func(bucket Bucket, found bool) Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.lastSweep) > time.Hour {
+		if err := s.db.DeleteIdleRateLimitBuckets(time.Now().Add(-idleBucketTTL)); err != nil {
+			return err
+		}
+		s.lastSweep = time.Now()
+	}
+
+	return s.db.UpdateRateLimitBucket(ctx, key, func(tokens float64, updatedAt time.Time, found bool) (float64, time.Time) {
+		bucket := fn(Bucket{Tokens: tokens, UpdatedAt: updatedAt}, found)
+		return bucket.Tokens, bucket.UpdatedAt
+	})
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept in memory. Buckets
+// idle this long have refilled for every limit we configure.
+const idleBucketTTL = 24 * time.Hour
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]Bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   map[string]Bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(bucket Bucket, found bool) Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, found := s.buckets[key]
+	s.buckets[key] = fn(bucket, found)
+
+	if time.Since(s.lastSweep) > time.Hour {
+		s.sweep()
+	}
+	return nil
+}
+
+// sweep drops idle buckets so the map doesn't grow without bound
+func (s *MemoryStore) sweep() {
+	cutoff := time.Now().Add(-idleBucketTTL)
+	for key, bucket := range s.buckets {
+		if bucket.UpdatedAt.Before(cutoff) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = time.Now()
+}
@@ -0,0 +1,115 @@
+// Package ratelimit implements token bucket rate limiting with pluggable
+// bucket storage.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled at Burst per Period
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseLimit parses limits written as "<count>/<period>", e.g. "10/1m"
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid limit %q: want <count>/<period>", s)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: count must be a positive integer", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: period must be a positive duration", s)
+	}
+	return Limit{Burst: burst, Period: d}, nil
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Burst, l.Period)
+}
+
+// refillInterval is how long it takes to regain a single token
+func (l Limit) refillInterval() time.Duration {
+	return l.Period / time.Duration(l.Burst)
+}
+
+// Bucket is the stored state of a single key
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Store loads and saves buckets. Update must apply fn atomically with respect
+// to other updates of the same key.
+type Store interface {
+	Update(ctx context.Context, key string, fn func(bucket Bucket, found bool) Bucket) error
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a request would be allowed, zero if allowed now
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow takes a token from the bucket for key if one is available
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := l.now()
+	var result Result
+
+	err := l.store.Update(ctx, key, func(bucket Bucket, found bool) Bucket {
+		if !found {
+			bucket = Bucket{Tokens: float64(limit.Burst), UpdatedAt: now}
+		}
+		bucket = refill(bucket, limit, now)
+
+		result = Result{Allowed: bucket.Tokens >= 1, Limit: limit.Burst}
+		if result.Allowed {
+			bucket.Tokens--
+		} else {
+			result.RetryAfter = time.Duration((1 - bucket.Tokens) * float64(limit.refillInterval()))
+		}
+		result.Remaining = int(math.Floor(bucket.Tokens))
+		result.Reset = time.Duration((float64(limit.Burst) - bucket.Tokens) * float64(limit.refillInterval()))
+		return bucket
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
+
+func refill(bucket Bucket, limit Limit, now time.Time) Bucket {
+	elapsed := now.Sub(bucket.UpdatedAt)
+	if elapsed > 0 {
+		bucket.Tokens += float64(elapsed) / float64(limit.refillInterval())
+		bucket.UpdatedAt = now
+	}
+	if bucket.Tokens > float64(limit.Burst) {
+		bucket.Tokens = float64(limit.Burst)
+	}
+	return bucket
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	if err != nil {
+		t.Fatalf("ParseLimit() error = %v", err)
+	}
+	if limit.Burst != 10 || limit.Period != time.Minute {
+		t.Errorf("ParseLimit() = %v", limit)
+	}
+
+	for _, bad := range []string{"", "10", "0/1m", "ten/1m", "10/soon", "10/-1m"} {
+		if _, err := ParseLimit(bad); err == nil {
+			t.Errorf("ParseLimit(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(NewMemoryStore())
+	limiter.now = func() time.Time { return now }
+	limit := Limit{Burst: 3, Period: 3 * time.Second}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		result, err := limiter.Allow(ctx, "k", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("Allow() = %+v, want allowed with %d remaining", result, i)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "k", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.RetryAfter != time.Second {
+		t.Fatalf("Allow() on empty bucket = %+v, want denied with 1s retry", result)
+	}
+
+	// other keys have their own bucket
+	if result, _ := limiter.Allow(ctx, "other", limit); !result.Allowed {
+		t.Error("Allow() for a different key was denied")
+	}
+
+	// one token refills every second
+	now = now.Add(time.Second)
+	if result, _ := limiter.Allow(ctx, "k", limit); !result.Allowed {
+		t.Error("Allow() after refill was denied")
+	}
+	if result, _ := limiter.Allow(ctx, "k", limit); result.Allowed {
+		t.Error("Allow() was allowed more than refilled")
+	}
+
+	// buckets never hold more than the burst
+	now = now.Add(time.Hour)
+	result, _ = limiter.Allow(ctx, "k", limit)
+	if result.Remaining != 2 {
+		t.Errorf("Remaining after long idle = %d, want 2", result.Remaining)
+	}
+}
+
+func TestDatabaseStore(t *testing.T) {
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewLimiter(NewDatabaseStore(db))
+	limit := Limit{Burst: 2, Period: time.Hour}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if result, err := limiter.Allow(ctx, "k", limit); err != nil || !result.Allowed {
+			t.Fatalf("Allow() %d = %+v, %v", i, result, err)
+		}
+	}
+	if result, err := limiter.Allow(ctx, "k", limit); err != nil || result.Allowed {
+		t.Fatalf("Allow() on empty bucket = %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// PresignedRequest is a request a client can make straight to the store
+// without credentials of its own
+type PresignedRequest struct {
+	Method string
+	URL    string
+	// Header must be sent with the request as it's part of the signature
+	Header http.Header
+}
+
+// Presigner is implemented by stores that let clients upload an object
+// directly. The signed request only accepts a body of exactly size bytes.
+type Presigner interface {
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (PresignedRequest, error)
+}
+
+// CompletedPart is an uploaded part of a multipart upload
+type CompletedPart struct {
+	PartNumber int32
+	ETag       string
+}
+
+// MultipartPresigner is implemented by stores that let clients upload an
+// object directly in parts, for objects too big for a single request.
+// Aborting an upload that was already completed or aborted isn't an error.
+type MultipartPresigner interface {
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (uploadID string, err error)
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, size int64, expires time.Duration) (PresignedRequest, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Store is a place media objects are written to and served from
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL an object is served from
+	URL(key string) string
+}
+
+// Checker is implemented by stores that can check they're usable
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Check checks the store is usable, if it knows how to
+func Check(ctx context.Context, store Store) error {
+	checker, ok := Unwrap(store).(Checker)
+	if !ok {
+		return nil
+	}
+	return checker.Check(ctx)
+}
+
+// Opener is implemented by stores that can read objects back
+type Opener interface {
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// ErrNotFound is returned by Open when there's no object with the key
+var ErrNotFound = errors.New("object not found")
+
+// Open reads an object from the store, if it knows how to
+func Open(ctx context.Context, store Store, key string) (io.ReadCloser, error) {
+	opener, ok := Unwrap(store).(Opener)
+	if !ok {
+		return nil, errors.New("store can't read objects back")
+	}
+	return opener.Open(ctx, key)
+}
+
+// KeyFromURL returns the key of an object in the store given its public URL
+func KeyFromURL(store Store, url string) (string, bool) {
+	prefix := store.URL("")
+	if !strings.HasPrefix(url, prefix) || len(url) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(url, prefix), true
+}
+
+// Unwrap returns the store underneath any wrappers, such as instrumentation,
+// for code that needs a particular implementation
+func Unwrap(store Store) Store {
+	for {
+		wrapper, ok := store.(interface{ Unwrap() Store })
+		if !ok {
+			return store
+		}
+		store = wrapper.Unwrap()
+	}
+}
@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/smithy-go"
+	smithytracing "github.com/aws/smithy-go/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AWSTracerProvider adapts an OpenTelemetry tracer provider for the AWS SDK,
+// so each SDK call, like S3 PutObject, gets a span under the request's
+type AWSTracerProvider struct {
+	Provider trace.TracerProvider
+}
+
+func (p AWSTracerProvider) Tracer(scope string, opts ...smithytracing.TracerOption) smithytracing.Tracer {
+	return awsTracer{tracer: p.Provider.Tracer(scope)}
+}
+
+type awsTracer struct {
+	tracer trace.Tracer
+}
+
+var spanKinds = map[smithytracing.SpanKind]trace.SpanKind{
+	smithytracing.SpanKindInternal: trace.SpanKindInternal,
+	smithytracing.SpanKindClient:   trace.SpanKindClient,
+	smithytracing.SpanKindServer:   trace.SpanKindServer,
+	smithytracing.SpanKindProducer: trace.SpanKindProducer,
+	smithytracing.SpanKindConsumer: trace.SpanKindConsumer,
+}
+
+func (t awsTracer) StartSpan(ctx context.Context, name string, opts ...smithytracing.SpanOption) (context.Context, smithytracing.Span) {
+	var options smithytracing.SpanOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	ctx, span := t.tracer.Start(ctx, name,
+		trace.WithSpanKind(spanKinds[options.Kind]),
+		trace.WithAttributes(propertyAttributes(&options.Properties)...),
+	)
+	return ctx, awsSpan{span: span, name: name}
+}
+
+type awsSpan struct {
+	span trace.Span
+	name string
+}
+
+func (s awsSpan) Name() string {
+	return s.name
+}
+
+func (s awsSpan) Context() smithytracing.SpanContext {
+	sc := s.span.SpanContext()
+	return smithytracing.SpanContext{
+		TraceID:  sc.TraceID().String(),
+		SpanID:   sc.SpanID().String(),
+		IsRemote: sc.IsRemote(),
+	}
+}
+
+func (s awsSpan) AddEvent(name string, opts ...smithytracing.EventOption) {
+	var options smithytracing.EventOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	s.span.AddEvent(name, trace.WithAttributes(propertyAttributes(&options.Properties)...))
+}
+
+func (s awsSpan) SetStatus(status smithytracing.SpanStatus) {
+	switch status {
+	case smithytracing.SpanStatusOK:
+		s.span.SetStatus(codes.Ok, "")
+	case smithytracing.SpanStatusError:
+		s.span.SetStatus(codes.Error, "")
+	}
+}
+
+func (s awsSpan) SetProperty(k, v any) {
+	s.span.SetAttributes(propertyAttribute(k, v))
+}
+
+func (s awsSpan) End() {
+	s.span.End()
+}
+
+func propertyAttributes(props *smithy.Properties) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{}
+	for k, v := range props.Values() {
+		attrs = append(attrs, propertyAttribute(k, v))
+	}
+	return attrs
+}
+
+func propertyAttribute(k, v any) attribute.KeyValue {
+	key := fmt.Sprint(k)
+	switch v := v.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
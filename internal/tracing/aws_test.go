@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	smithytracing "github.com/aws/smithy-go/tracing"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAWSTracerProvider(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "upload")
+	tracer := AWSTracerProvider{Provider: provider}.Tracer("S3")
+	_, span := tracer.StartSpan(ctx, "S3.PutObject", func(o *smithytracing.SpanOptions) {
+		o.Kind = smithytracing.SpanKindClient
+		o.Properties.Set("rpc.method", "PutObject")
+	})
+	span.SetProperty("http.response.status_code", 503)
+	span.SetStatus(smithytracing.SpanStatusError)
+	if sc := span.Context(); sc.TraceID != parent.SpanContext().TraceID().String() {
+		t.Fatalf("expected the SDK span to share the parent's trace, got %s", sc.TraceID)
+	}
+	span.End()
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected one span, got %d", len(ended))
+	}
+	got := ended[0]
+	if got.Name() != "S3.PutObject" || got.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("unexpected span %s of kind %v", got.Name(), got.SpanKind())
+	}
+	if got.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("expected the SDK span to be a child of the request")
+	}
+	if got.Status().Code != codes.Error {
+		t.Fatalf("expected an error status, got %v", got.Status())
+	}
+	attrs := map[string]string{}
+	for _, attr := range got.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attrs["rpc.method"] != "PutObject" || attrs["http.response.status_code"] != "503" {
+		t.Fatalf("expected the SDK's properties as attributes, got %v", attrs)
+	}
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), "zipkin", nil); err == nil {
+		t.Fatal("expected an unknown exporter to be rejected")
+	}
+}
@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned for endpoints on the server's own networks,
+// which users could otherwise probe through the delivery log
+var ErrBlockedAddress = errors.New("webhook endpoints can't be on loopback, link-local, private or unspecified addresses")
+
+// sharedAddressSpace is carrier-grade NAT, private in all but name
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func blockedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsPrivate() || addr.IsUnspecified() ||
+		sharedAddressSpace.Contains(addr)
+}
+
+// CheckURL checks an endpoint is an absolute http or https URL whose host
+// resolves only to public addresses. Deliveries check again when they
+// connect, so a host that later resolves somewhere private is still refused.
+func (d *Dispatcher) CheckURL(ctx context.Context, raw string) error {
+	endpoint, err := url.Parse(raw)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if d.config.AllowPrivateNetworks {
+		return nil
+	}
+
+	host := endpoint.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if blockedAddress(addr) {
+			return ErrBlockedAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if blockedAddress(addr) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+// newClient returns the client deliveries are sent with. Its dialer refuses
+// blocked addresses after DNS resolution, so a host can't pass CheckURL and
+// then rebind to an internal address, and redirects are checked the same way.
+func newClient(config Config) *http.Client {
+	dialer := &net.Dialer{Timeout: config.Timeout, KeepAlive: 30 * time.Second}
+	if !config.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if blockedAddress(addrPort.Addr()) {
+				return ErrBlockedAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be the only address the dialer sees
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: config.Timeout, Transport: transport}
+}
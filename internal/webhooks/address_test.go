@@ -0,0 +1,55 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckURLRejectsInternalAddresses(t *testing.T) {
+	d := &Dispatcher{config: Config{}}
+	for _, raw := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	} {
+		if err := d.CheckURL(context.Background(), raw); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("CheckURL(%q) = %v, want %v", raw, err, ErrBlockedAddress)
+		}
+	}
+
+	for _, raw := range []string{"ftp://example.com/hook", "/hook", "http://"} {
+		if err := d.CheckURL(context.Background(), raw); err == nil || errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("CheckURL(%q) = %v, want an invalid URL error", raw, err)
+		}
+	}
+	if err := d.CheckURL(context.Background(), "https://93.184.215.14/hook"); err != nil {
+		t.Errorf("expected a public address to be allowed, got %v", err)
+	}
+}
+
+func TestClientRefusesInternalAddressesWhenDialing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// as if the host resolved somewhere public when the webhook was created
+	_, err := newClient(testConfig).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected private networks to be allowed when configured, got %v", err)
+	}
+	strict := testConfig
+	strict.AllowPrivateNetworks = false
+	_, err = newClient(strict).Get(server.URL)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("expected the dial to be refused, got %v", err)
+	}
+}
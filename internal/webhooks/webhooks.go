@@ -0,0 +1,269 @@
+// Package webhooks sends signed notifications about video lifecycle events to
+// endpoints registered by users. Deliveries are queued in the database and
+// sent by a background worker, which retries failures with exponential
+// backoff.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	EventVideoCreated   = "video.created"
+	EventVideoProcessed = "video.processed"
+	EventVideoThumbnail = "video.thumbnail"
+	EventVideoDeleted   = "video.deleted"
+)
+
+// Events lists every event a webhook can subscribe to
+var Events = []string{EventVideoCreated, EventVideoProcessed, EventVideoThumbnail, EventVideoDeleted}
+
+const (
+	SignatureHeader = "X-Tubely-Signature"
+	TimestampHeader = "X-Tubely-Timestamp"
+	EventHeader     = "X-Tubely-Event"
+	DeliveryHeader  = "X-Tubely-Delivery"
+)
+
+// Payload is the JSON body of every delivery
+type Payload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// Sign returns the signature header value for a delivery body sent at the
+// given unix time. Receivers recompute it with their secret and compare.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Store is where deliveries are queued and logged. database.Client
+// implements it.
+type Store interface {
+	EnqueueWebhookDeliveries(userID uuid.UUID, event string, payload []byte) (int, error)
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]database.WebhookDelivery, error)
+	RecordWebhookAttempt(id uuid.UUID, attempt database.WebhookAttempt) error
+	GetWebhook(id uuid.UUID) (database.Webhook, error)
+}
+
+type Config struct {
+	// Interval is how often the queue is checked for due deliveries
+	Interval time.Duration
+	// Timeout bounds each request to an endpoint
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before it fails
+	MaxAttempts int
+	// Backoff is the wait after the first failure, doubling up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// AllowPrivateNetworks lets endpoints be on loopback and private
+	// addresses, for tests
+	AllowPrivateNetworks bool
+}
+
+// DefaultConfig retries for about a day before giving up
+var DefaultConfig = Config{
+	Interval:    5 * time.Second,
+	Timeout:     10 * time.Second,
+	MaxAttempts: 10,
+	Backoff:     30 * time.Second,
+	MaxBackoff:  6 * time.Hour,
+}
+
+// batchSize is how many due deliveries are claimed at a time
+const batchSize = 20
+
+// Dispatcher delivers queued webhooks in the background
+type Dispatcher struct {
+	store  Store
+	config Config
+	client *http.Client
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func NewDispatcher(store Store, config Config) *Dispatcher {
+	d := &Dispatcher{
+		store:   store,
+		config:  config,
+		client:  newClient(config),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Enqueue queues the event for each of the user's webhooks that subscribes to
+// it. The deliveries are sent shortly after by the worker.
+func (d *Dispatcher) Enqueue(userID uuid.UUID, event string, data any) error {
+	payload, err := json.Marshal(Payload{Event: event, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	queued, err := d.store.EnqueueWebhookDeliveries(userID, event, payload)
+	if err != nil {
+		return err
+	}
+	if queued > 0 {
+		d.Wake()
+	}
+	return nil
+}
+
+// Wake makes the worker check for due deliveries now rather than at its next
+// interval
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the worker, waiting for any delivery in flight. Deliveries
+// still queued are sent the next time a dispatcher starts.
+func (d *Dispatcher) Close() {
+	d.once.Do(func() {
+		close(d.done)
+		<-d.stopped
+	})
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.stopped)
+
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		case <-d.done:
+			return
+		}
+		d.deliverDue()
+	}
+}
+
+// deliverDue sends every delivery that is due, a batch at a time
+func (d *Dispatcher) deliverDue() {
+	for {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		// the lease outlasts a request so a slow endpoint isn't sent twice
+		deliveries, err := d.store.ClaimWebhookDeliveries(time.Now(), 2*d.config.Timeout, batchSize)
+		if err != nil {
+			slog.Error("couldn't claim webhook deliveries", "error", err)
+			return
+		}
+		for _, delivery := range deliveries {
+			attempt := d.attempt(delivery)
+			if err := d.store.RecordWebhookAttempt(delivery.ID, attempt); err != nil {
+				slog.Error("couldn't record webhook delivery", "delivery_id", delivery.ID, "error", err)
+			}
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt sends a delivery once and works out what happens next
+func (d *Dispatcher) attempt(delivery database.WebhookDelivery) database.WebhookAttempt {
+	webhook, err := d.store.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		return d.retry(delivery, nil, err)
+	}
+	if webhook.ID == uuid.Nil {
+		return database.WebhookAttempt{Status: database.WebhookDeliveryFailed, Error: "webhook was deleted", NextAttemptAt: time.Now()}
+	}
+
+	status, err := d.send(webhook, delivery)
+	if err != nil {
+		return d.retry(delivery, status, err)
+	}
+	return database.WebhookAttempt{Status: database.WebhookDeliverySucceeded, ResponseStatus: status, NextAttemptAt: time.Now()}
+}
+
+func (d *Dispatcher) send(webhook database.Webhook, delivery database.WebhookDelivery) (*int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// read a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	status := resp.StatusCode
+	if status < 200 || status > 299 {
+		return &status, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return &status, nil
+}
+
+// retry schedules the next attempt after a failure, or fails the delivery
+// once it has used up its attempts
+func (d *Dispatcher) retry(delivery database.WebhookDelivery, status *int, err error) database.WebhookAttempt {
+	attempt := database.WebhookAttempt{
+		Status:         database.WebhookDeliveryPending,
+		ResponseStatus: status,
+		Error:          err.Error(),
+	}
+	attempts := delivery.Attempts + 1
+	if attempts >= d.config.MaxAttempts {
+		attempt.Status = database.WebhookDeliveryFailed
+		attempt.NextAttemptAt = time.Now()
+		return attempt
+	}
+	attempt.NextAttemptAt = time.Now().Add(backoff(d.config, attempts))
+	return attempt
+}
+
+// backoff is how long to wait before the next attempt after the given number
+// of failed attempts
+func backoff(config Config, attempts int) time.Duration {
+	wait := config.Backoff
+	for i := 1; i < attempts && wait < config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, config.MaxBackoff)
+}
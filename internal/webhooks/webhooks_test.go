@@ -0,0 +1,150 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+var testConfig = Config{
+	Interval:    time.Hour,
+	Timeout:     time.Second,
+	MaxAttempts: 2,
+	Backoff:     time.Millisecond,
+	MaxBackoff:  time.Millisecond,
+
+	AllowPrivateNetworks: true,
+}
+
+func newTestStore(t *testing.T, url string, events ...string) (database.Client, database.Webhook) {
+	t.Helper()
+
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := db.CreateWebhook(database.CreateWebhookParams{
+		UserID: uuid.New(),
+		URL:    url,
+		Secret: "shh",
+		Events: events,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, webhook
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	db, webhook := newTestStore(t, server.URL, EventVideoCreated)
+	dispatcher := NewDispatcher(db, testConfig)
+	defer dispatcher.Close()
+
+	// events the webhook didn't subscribe to aren't queued
+	if err := dispatcher.Enqueue(webhook.UserID, EventVideoDeleted, map[string]string{"id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.Enqueue(webhook.UserID, EventVideoCreated, map[string]string{"id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var req *http.Request
+	select {
+	case req = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't delivered")
+	}
+	body := <-bodies
+
+	if req.Header.Get(EventHeader) != EventVideoCreated {
+		t.Fatalf("expected %s event header, got %q", EventVideoCreated, req.Header.Get(EventHeader))
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Header.Get(SignatureHeader), Sign("shh", timestamp, body); got != want {
+		t.Fatalf("signature %q doesn't match %q", got, want)
+	}
+
+	dispatcher.Close()
+	deliveries, err := db.GetWebhookDeliveries(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != database.WebhookDeliverySucceeded || deliveries[0].Attempts != 1 {
+		t.Fatalf("expected one successful delivery, got %+v", deliveries)
+	}
+}
+
+func TestDispatcherRetriesThenFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	db, webhook := newTestStore(t, server.URL, EventVideoDeleted)
+	dispatcher := NewDispatcher(db, testConfig)
+	defer dispatcher.Close()
+
+	// queued straight in the store so the worker isn't woken and each attempt
+	// is driven by the test
+	if _, err := db.EnqueueWebhookDeliveries(webhook.UserID, EventVideoDeleted, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first attempt leaves the delivery pending with a retry scheduled
+	dispatcher.deliverDue()
+	deliveries, err := db.GetWebhookDeliveries(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != database.WebhookDeliveryPending {
+		t.Fatalf("expected a pending retry, got %+v", deliveries)
+	}
+	if deliveries[0].ResponseStatus == nil || *deliveries[0].ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected the response status to be logged, got %v", deliveries[0].ResponseStatus)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	dispatcher.deliverDue()
+	deliveries, err = db.GetWebhookDeliveries(webhook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deliveries[0].Status != database.WebhookDeliveryFailed || deliveries[0].Attempts != 2 {
+		t.Fatalf("expected the delivery to fail after 2 attempts, got %+v", deliveries[0])
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	config := Config{Backoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+	for attempts, want := range map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		3: 2 * time.Minute,
+		4: 4 * time.Minute,
+		5: 5 * time.Minute,
+		9: 5 * time.Minute,
+	} {
+		if got := backoff(config, attempts); got != want {
+			t.Errorf("backoff after %d attempts: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
@@ -2,16 +2,20 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+	// the request log line reports the error, so it isn't logged twice
+	if rl := requestLogFromWriter(w); rl != nil {
+		rl.mu.Lock()
+		rl.errorMsg, rl.err = msg, err
+		rl.mu.Unlock()
+	} else if code > 499 {
+		slog.Error("responding with error", "status", code, "error", msg, "cause", err)
+	} else if err != nil {
+		slog.Debug("responding with error", "status", code, "error", msg, "cause", err)
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
@@ -25,7 +29,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		slog.Error("couldn't marshal JSON response", "error", err)
 		w.WriteHeader(500)
 		return
 	}
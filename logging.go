@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// incoming request IDs are kept if they look like an ID, anything else is replaced
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// newLogger builds the server's logger. format is json or text, level is
+// debug, info, warn or error.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// contextHandler adds the fields of the request being served, if any, to
+// every line logged with its context, along with the trace it's part of
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if rl := requestLogFromContext(ctx); rl != nil {
+		record.AddAttrs(rl.attrs()...)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestLogKey struct{}
+
+// requestLog is what's known about a request for logging. Handlers fill in
+// the stage as they go and respondWithError records why a request failed.
+type requestLog struct {
+	id     string
+	userID uuid.UUID
+	// req is read when a line is logged, since path values are only set once
+	// the mux has routed the request
+	req *http.Request
+
+	mu       sync.Mutex
+	stage    string
+	errorMsg string
+	err      error
+}
+
+func (rl *requestLog) attrs() []slog.Attr {
+	attrs := []slog.Attr{slog.String("request_id", rl.id)}
+	if rl.userID != uuid.Nil {
+		attrs = append(attrs, slog.String("user_id", rl.userID.String()))
+	}
+	if videoID := rl.req.PathValue("videoID"); videoID != "" {
+		attrs = append(attrs, slog.String("video_id", videoID))
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.stage != "" {
+		attrs = append(attrs, slog.String("stage", rl.stage))
+	}
+	return attrs
+}
+
+func requestLogFromContext(ctx context.Context) *requestLog {
+	rl, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// setLogStage records which step of a pipeline the request has reached, so
+// later lines show where it was
+func setLogStage(ctx context.Context, stage string) {
+	if rl := requestLogFromContext(ctx); rl != nil {
+		rl.mu.Lock()
+		rl.stage = stage
+		rl.mu.Unlock()
+	}
+}
+
+// statusWriter remembers the status sent so the request can be logged and
+// counted once it's finished
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// loggingResponseWriter carries the request log so respondWithError can
+// record why the request failed
+type loggingResponseWriter struct {
+	statusWriter
+	log *requestLog
+}
+
+// requestLogFromWriter finds the request log attached to a response writer
+// by requestLogMiddleware
+func requestLogFromWriter(w http.ResponseWriter) *requestLog {
+	for {
+		switch v := w.(type) {
+		case *loggingResponseWriter:
+			return v.log
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// requestLogMiddleware gives every request an ID, sent back as X-Request-ID,
+// and logs one line per request once it's been served
+func (cfg *apiConfig) requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		rl := &requestLog{id: id}
+		// an invalid token is the handler's problem, the log just has no user
+		rl.userID, _ = cfg.optionalUserID(r)
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl))
+		rl.req = r
+
+		lw := &loggingResponseWriter{statusWriter: statusWriter{ResponseWriter: w}, log: rl}
+		next.ServeHTTP(lw, r)
+
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+		}
+		rl.mu.Lock()
+		if rl.errorMsg != "" {
+			attrs = append(attrs, slog.String("error", rl.errorMsg))
+		}
+		if rl.err != nil {
+			attrs = append(attrs, slog.String("cause", rl.err.Error()))
+		}
+		rl.mu.Unlock()
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
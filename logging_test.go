@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// captureLogs sends the default logger's output to a buffer for the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	lines := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line isn't JSON: %q", line)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestNewLoggerRejectsUnknownSettings(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "json", "loud"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
+
+func TestRequestLogMiddleware(t *testing.T) {
+	logs := captureLogs(t)
+	cfg := &apiConfig{keyring: newTestKeyring(t)}
+	userID := uuid.New()
+	token, err := auth.MakeJWT(userID, cfg.keyring, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/videos/{videoID}/thing", func(w http.ResponseWriter, r *http.Request) {
+		setLogStage(r.Context(), "probing")
+		slog.InfoContext(r.Context(), "working")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected the logging writer to support flushing: %v", err)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't do the thing", errors.New("disk on fire"))
+	})
+	handler := cfg.requestLogMiddleware(mux)
+
+	videoID := uuid.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/videos/"+videoID.String()+"/thing", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(requestIDHeader, "upstream-id-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(requestIDHeader); got != "upstream-id-123" {
+		t.Fatalf("expected the incoming request ID to be kept, got %q", got)
+	}
+
+	lines := decodeLogLines(t, logs)
+	if len(lines) != 2 {
+		t.Fatalf("expected a handler line and a request line, got %v", lines)
+	}
+	for _, line := range lines {
+		if line["request_id"] != "upstream-id-123" || line["user_id"] != userID.String() || line["video_id"] != videoID.String() {
+			t.Errorf("expected request, user and video IDs on every line, got %v", line)
+		}
+		if line["stage"] != "probing" {
+			t.Errorf("expected the stage on every line, got %v", line)
+		}
+	}
+	request := lines[1]
+	if request["msg"] != "request" || request["level"] != "ERROR" || request["status"] != float64(500) {
+		t.Fatalf("unexpected request line %v", request)
+	}
+	if request["error"] != "Couldn't do the thing" || request["cause"] != "disk on fire" {
+		t.Fatalf("expected the error to be on the request line, got %v", request)
+	}
+}
+
+func TestRequestLogMiddlewareReplacesBadRequestIDs(t *testing.T) {
+	captureLogs(t)
+	cfg := &apiConfig{keyring: newTestKeyring(t)}
+	handler := cfg.requestLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(requestIDHeader, "not an id\n")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if _, err := uuid.Parse(rec.Header().Get(requestIDHeader)); err != nil {
+		t.Fatalf("expected a generated request ID, got %q", rec.Header().Get(requestIDHeader))
+	}
+}
@@ -2,21 +2,43 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/analytics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mediatool"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/progress"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/tracing"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/webhooks"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
 )
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	keyring          *auth.Keyring
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -25,114 +47,275 @@ type apiConfig struct {
 	s3CfDistribution string
 	port             string
 	s3Client         *s3.Client
+	videoStore       storage.Store
+	assetStore       storage.Store
+	oidcProviders    map[string]*oidc.Provider
+	mailer           mailer.Mailer
+	baseURL          string
+	analytics        *analytics.Recorder
+	webhooks         *webhooks.Dispatcher
+	uploadProgress   *progress.Tracker
+	metrics          *metrics.Metrics
+	metricsToken     string
+	// stagingStore holds direct uploads until they're processed, somewhere
+	// that's never served
+	stagingStore storage.Store
+	// shutdown is closed once the server starts shutting down
+	shutdown chan struct{}
+	// inFlight counts running handlers, and background the work they or
+	// the server leave running, so shutdown can wait for both
+	inFlight   sync.WaitGroup
+	background sync.WaitGroup
+
+	rateLimiter    *ratelimit.Limiter
+	rateLimits     map[string][]rateLimitRule
+	trustedProxies int
+
+	// media probes and converts uploaded videos, which are filed in the
+	// folder of their aspect bucket
+	media         media.Processor
+	aspectBuckets media.Buckets
+
+	// directUploadMaxBytes caps videos uploaded straight to the video store
+	directUploadMaxBytes int64
 }
 
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			os.Exit(runConfigCommand(args[1:]))
+		case "verify":
+			os.Exit(runVerifyCommand(args[1:]))
+		}
 	}
 
-	db, err := database.NewClient(pathToDB)
+	conf, err := config.Load("tubely", args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	logger, err := newLogger(os.Stderr, conf.Log.Format, conf.Log.Level)
+	if err != nil {
+		fatal("Couldn't configure logging", "error", err)
 	}
+	slog.SetDefault(logger)
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.Tracing.Exporter, os.Stdout)
+	if err != nil {
+		fatal("Couldn't configure tracing", "error", err)
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	db, err := database.NewClient(conf.Database.Path)
+	if err != nil {
+		fatal("Couldn't connect to database", "error", err)
 	}
+	appMetrics := metrics.New()
+	db.ObserveQueries(appMetrics.ObserveQuery)
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	// a shared secret and/or a directory of RS256/EdDSA keys; the signing key ID picks the signer
+	keyring, err := auth.LoadKeyring(conf.Auth.JWTSecret, conf.Auth.JWTSecretID, conf.Auth.KeysDir, conf.Auth.SigningKeyID)
+	if err != nil {
+		fatal("Couldn't load JWT keys", "error", err)
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	videoStore, stagingStore, client, err := newVideoStore(context.Background(), conf)
+	if err != nil {
+		fatal("Failed to load S3 client config", "error", err)
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	oidcProviders, err := loadOIDCProviders(context.Background(), conf.OIDC)
+	if err != nil {
+		fatal("Couldn't configure OIDC providers", "error", err)
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	mail, err := mailer.New(mailer.Config{
+		Kind:         conf.Mail.Kind,
+		From:         conf.Mail.From,
+		SMTPHost:     conf.Mail.SMTPHost,
+		SMTPPort:     conf.Mail.SMTPPort,
+		SMTPUsername: conf.Mail.SMTPUsername,
+		SMTPPassword: conf.Mail.SMTPPassword,
+		Dir:          conf.Mail.Dir,
+	})
+	if err != nil {
+		fatal("Couldn't configure mailer", "error", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	rateLimitSpec := conf.RateLimit.Rules
+	if rateLimitSpec == "" {
+		rateLimitSpec = defaultRateLimits
 	}
-
-	s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	rateLimits, err := parseRateLimits(rateLimitSpec)
 	if err != nil {
-		log.Fatal("Failed to load S3 client config")
+		fatal("Couldn't parse RATE_LIMITS", "error", err)
+	}
+
+	aspectBuckets := media.DefaultBuckets
+	if conf.Media.AspectBuckets != "" {
+		aspectBuckets, err = media.ParseBuckets(conf.Media.AspectBuckets)
+		if err != nil {
+			fatal("Couldn't parse MEDIA_ASPECT_BUCKETS", "error", err)
+		}
 	}
 
-	client := s3.NewFromConfig(s3Config)
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if conf.RateLimit.Store == "database" {
+		rateLimitStore = ratelimit.NewDatabaseStore(db)
+	}
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
+		keyring:          keyring,
+		platform:         conf.Platform,
+		filepathRoot:     conf.FilepathRoot,
+		assetsRoot:       conf.AssetsRoot,
+		s3Bucket:         conf.Storage.S3.Bucket,
+		s3Region:         conf.Storage.S3.Region,
+		s3CfDistribution: conf.Storage.S3.Distribution,
+		port:             conf.Port,
 		s3Client:         client,
+		videoStore:       appMetrics.Store(conf.Storage.Videos, videoStore),
+		stagingStore:     appMetrics.Store(conf.Storage.Videos+"_staging", stagingStore),
+		assetStore: appMetrics.Store("local", storage.LocalStore{
+			Root:    conf.AssetsRoot,
+			BaseURL: conf.BaseURL + "/assets",
+		}),
+		oidcProviders:  oidcProviders,
+		mailer:         mail,
+		baseURL:        conf.BaseURL,
+		analytics:      analytics.NewRecorder(db, analyticsFlushInterval, analyticsMaxPending),
+		webhooks:       webhooks.NewDispatcher(db, webhooks.DefaultConfig),
+		uploadProgress: progress.NewTracker(),
+		metrics:        appMetrics,
+		metricsToken:   conf.Metrics.Token,
+		shutdown:       make(chan struct{}),
+
+		rateLimiter:    ratelimit.NewLimiter(rateLimitStore),
+		rateLimits:     rateLimits,
+		trustedProxies: conf.TrustedProxies,
+
+		media: observedMedia{
+			next: media.FFmpeg{
+				Runner:         mediatool.NewRunner(conf.Media.MaxConcurrent),
+				ProbeTimeout:   conf.Media.ProbeTimeout,
+				ProcessTimeout: conf.Media.ProcessTimeout,
+			},
+			metrics: appMetrics,
+		},
+		aspectBuckets:        aspectBuckets,
+		directUploadMaxBytes: int64(conf.Storage.DirectUploadMaxBytes),
 	}
 
 	err = cfg.ensureAssetsDir()
 	if err != nil {
-		log.Fatalf("Couldn't create assets directory: %v", err)
+		fatal("Couldn't create assets directory", "error", err)
 	}
 
-	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
-	mux.Handle("/app/", appHandler)
+	srv := &http.Server{
+		Addr:    ":" + conf.Port,
+		Handler: cfg.routes(),
+	}
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	// requests run under a context that's cancelled if they're still going
+	// when the shutdown deadline passes, which kills any ffmpeg they started
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	srv.BaseContext = func(net.Listener) context.Context { return requestsCtx }
+	srv.RegisterOnShutdown(func() { close(cfg.shutdown) })
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	cfg.background.Add(1)
+	go func() {
+		defer cfg.background.Done()
+		cfg.sweepStagedUploadsEvery(requestsCtx, stagedUploadSweepInterval)
+	}()
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	go func() {
+		slog.Info("serving", "url", "http://localhost:"+conf.Port+"/app/")
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("Server stopped", "error", err)
+		}
+	}()
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-signals.Done()
+	// a second signal kills the server straight away
+	stop()
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", conf.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("requests still running at the shutdown deadline, cancelling them", "error", err)
+	}
+	cancelRequests()
+	srv.Close()
+	// cancelled handlers and background work still use the workers and the
+	// database, so they get a moment to stop before those close
+	if !waitFor(&cfg.inFlight, shutdownGrace) || !waitFor(&cfg.background, shutdownGrace) {
+		slog.Warn("work still running after being cancelled, closing anyway", "grace", shutdownGrace)
+	}
 
-	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+	// flush what the background workers are holding before the database goes
+	cfg.webhooks.Close()
+	cfg.analytics.Close()
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("couldn't flush traces", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("couldn't close database", "error", err)
+	}
+	slog.Info("stopped")
+}
+
+// fatal logs why the server can't run and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newVideoStore returns where videos are kept, and where direct uploads wait
+// to be processed: S3, with the client, unless they're kept locally with the
+// assets
+func newVideoStore(ctx context.Context, conf config.Config) (videos, staging storage.Store, client *s3.Client, err error) {
+	if conf.Storage.Videos == config.VideoStoreLocal {
+		videos = storage.LocalStore{
+			Root:    filepath.Join(conf.AssetsRoot, "videos"),
+			BaseURL: conf.BaseURL + "/assets/videos",
+		}
+		// direct uploads come back to the app and are kept outside the assets
+		staging = storage.LocalStore{
+			Root:      conf.Storage.StagingDir,
+			UploadURL: conf.BaseURL + stagedUploadPath,
+			Secret:    []byte(conf.Storage.UploadSecret),
+		}
+		return videos, staging, nil, nil
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	s3Config, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(conf.Storage.S3.Region))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	client = s3.NewFromConfig(s3Config, func(o *s3.Options) {
+		o.TracerProvider = tracing.AWSTracerProvider{Provider: otel.GetTracerProvider()}
+	})
+	videos = storage.S3Store{
+		Client:       client,
+		Bucket:       conf.Storage.S3.Bucket,
+		Distribution: conf.Storage.S3.Distribution,
+	}
+	// the staging bucket has no distribution in front of it
+	staging = storage.S3Store{
+		Client: client,
+		Bucket: conf.Storage.S3.StagingBucket,
+	}
+	return videos, staging, client, nil
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+func newTestDB(t *testing.T) database.Client {
+	t.Helper()
+
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+	return db
+}
+
+func newTestKeyring(t *testing.T) *auth.Keyring {
+	t.Helper()
+
+	key := auth.NewHMACKey("hs256", "test-secret")
+	keyring, err := auth.NewKeyring(key.ID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// recordingMailer keeps sent messages in memory so tests can follow emailed links
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *recordingMailer) last() mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		return mailer.Message{}
+	}
+	return m.messages[len(m.messages)-1]
+}
+
+func sendJSON(t *testing.T, mux *http.ServeMux, method, path string, body any, bearer string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	dat, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(dat))
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
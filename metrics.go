@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// handlerMetrics serves metrics to scrapers that send the configured token.
+// It shares the public listener, so without a token it's off.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	if cfg.metricsToken == "" {
+		respondWithError(w, http.StatusNotFound, "Metrics are disabled", nil)
+		return
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.metricsToken)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid metrics token", err)
+		return
+	}
+	cfg.metrics.Handler().ServeHTTP(w, r)
+}
+
+// metricsMiddleware records every request against the mux pattern it's
+// routed to, so paths with IDs in them share a series
+func (cfg *apiConfig) metricsMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		cfg.metrics.ObserveRequest(r.Method, route, status, time.Since(start), body.n)
+	})
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
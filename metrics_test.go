@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+	"github.com/google/uuid"
+)
+
+func scrapeMetrics(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected metrics to be served, got %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	cfg, mux := newPlaylistTestConfig(t)
+	cfg.metrics = metrics.New()
+	cfg.db.ObserveQueries(cfg.metrics.ObserveQuery)
+	_, token, _ := createTestUser(t, cfg, mux, "metrics@example.com", "password123")
+	handler := cfg.metricsMiddleware(mux, mux)
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	body := `{"title":"Favourites"}`
+	send(http.MethodPost, "/api/playlists", body)
+	send(http.MethodGet, "/api/playlists/"+uuid.NewString(), "")
+	send(http.MethodGet, "/api/playlists/"+uuid.NewString(), "")
+	send(http.MethodGet, "/nowhere", "")
+
+	scraped := scrapeMetrics(t, cfg.metrics)
+	for _, want := range []string{
+		`tubely_http_requests_total{method="POST",route="POST /api/playlists",status="201"} 1`,
+		`tubely_http_requests_total{method="GET",route="GET /api/playlists/{playlistID}",status="404"} 2`,
+		`tubely_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`tubely_http_request_body_bytes_total{method="POST",route="POST /api/playlists"} ` + strconv.Itoa(len(body)),
+		`tubely_http_request_duration_seconds_count{method="GET",route="GET /api/playlists/{playlistID}"} 2`,
+		`tubely_db_query_duration_seconds_count{query="Client.GetPlaylist"}`,
+		`tubely_db_query_duration_seconds_count{query="Client.CreatePlaylist"}`,
+	} {
+		if !strings.Contains(scraped, want) {
+			t.Errorf("expected metrics to include %s", want)
+		}
+	}
+}
+
+func TestMetricsEndpointRequiresToken(t *testing.T) {
+	cfg := &apiConfig{metrics: metrics.New()}
+
+	scrape := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerMetrics(rec, req)
+		return rec.Code
+	}
+
+	if code := scrape("anything"); code != http.StatusNotFound {
+		t.Errorf("status without a configured token = %d, want %d", code, http.StatusNotFound)
+	}
+	cfg.metricsToken = "s3cret"
+	if code := scrape(""); code != http.StatusUnauthorized {
+		t.Errorf("status without a token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := scrape("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("status with the wrong token = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := scrape("s3cret"); code != http.StatusOK {
+		t.Errorf("status with the token = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+// observedMedia records each media operation in the metrics and traces it
+type observedMedia struct {
+	next    media.Processor
+	metrics *metrics.Metrics
+}
+
+// observe starts recording a run of tool. Call the returned function with
+// the run's error once it's finished.
+func (o observedMedia) observe(ctx context.Context, tool, stage string) func(err error) {
+	start := time.Now()
+	_, span := tracer.Start(ctx, tool+" "+stage)
+	return func(err error) {
+		o.metrics.ObserveMedia(tool, stage, time.Since(start), err)
+		endSpan(span, err)
+	}
+}
+
+func (o observedMedia) Probe(ctx context.Context, path string) (media.Probe, error) {
+	done := o.observe(ctx, "ffprobe", "probe")
+	probe, err := o.next.Probe(ctx, path)
+	done(err)
+	return probe, err
+}
+
+func (o observedMedia) Remux(ctx context.Context, input, output string, duration time.Duration, onProgress func(percent float64)) error {
+	done := o.observe(ctx, "ffmpeg", "fast-start")
+	err := o.next.Remux(ctx, input, output, duration, onProgress)
+	done(err)
+	return err
+}
+
+func (o observedMedia) Transcode(ctx context.Context, input, output string, rendition media.Rendition, duration time.Duration, onProgress func(percent float64)) error {
+	done := o.observe(ctx, "ffmpeg", "transcode")
+	err := o.next.Transcode(ctx, input, output, rendition, duration, onProgress)
+	done(err)
+	return err
+}
+
+func (o observedMedia) ExtractFrame(ctx context.Context, input, output string, at time.Duration) error {
+	done := o.observe(ctx, "ffmpeg", "extract-frame")
+	err := o.next.ExtractFrame(ctx, input, output, at)
+	done(err)
+	return err
+}
+
+func (o observedMedia) PackageHLS(ctx context.Context, input, dir string, renditions []media.Rendition, subtitles []media.SubtitleTrack) (string, error) {
+	done := o.observe(ctx, "ffmpeg", "package-hls")
+	playlist, err := o.next.PackageHLS(ctx, input, dir, renditions, subtitles)
+	done(err)
+	return playlist, err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// defaultRateLimits is used when RATE_LIMITS isn't set. Keys are mux patterns.
+const defaultRateLimits = "POST /api/login=ip:10/1m;" +
+	"POST /api/users=ip:10/1h;" +
+	"POST /api/password/forgot=ip:5/1h;" +
+	"POST /api/share/{token}=ip:30/1m;" +
+	"POST /api/videos/{videoID}/events=ip:120/1m;" +
+	"POST /api/videos/{videoID}/comments=user:30/1h;" +
+	"POST /api/thumbnail_upload/{videoID}=user:30/1h,ip:60/1h;" +
+	"POST /api/video_upload/{videoID}=user:10/1h,ip:20/1h;" +
+	"POST /api/videos/{videoID}/upload-url=user:10/1h,ip:20/1h"
+
+// rateLimitRule limits requests to a route per client IP, per user or for the
+// route as a whole
+type rateLimitRule struct {
+	scope string
+	limit ratelimit.Limit
+}
+
+// parseRateLimits reads rules written as
+// "<pattern>=<scope>:<count>/<period>[,...][;<pattern>=...]"
+func parseRateLimits(s string) (map[string][]rateLimitRule, error) {
+	rules := map[string][]rateLimitRule{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pattern, specs, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: want <pattern>=<rules>", entry)
+		}
+		pattern = strings.TrimSpace(pattern)
+
+		for _, spec := range strings.Split(specs, ",") {
+			scope, limitString, ok := strings.Cut(strings.TrimSpace(spec), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid rate limit %q for %s: want <scope>:<count>/<period>", spec, pattern)
+			}
+			if scope != "ip" && scope != "user" && scope != "route" {
+				return nil, fmt.Errorf("invalid rate limit scope %q for %s: want ip, user or route", scope, pattern)
+			}
+			limit, err := ratelimit.ParseLimit(limitString)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", pattern, err)
+			}
+			rules[pattern] = append(rules[pattern], rateLimitRule{scope: scope, limit: limit})
+		}
+	}
+	return rules, nil
+}
+
+// rateLimitMiddleware applies the limits configured for whichever mux pattern
+// the request will be routed to
+func (cfg *apiConfig) rateLimitMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		rules := cfg.rateLimits[pattern]
+		if len(rules) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var tightest *ratelimit.Result
+		for _, rule := range rules {
+			subject, ok := cfg.rateLimitSubject(r, rule.scope)
+			if !ok {
+				continue
+			}
+			key := pattern + "|" + rule.scope + "|" + subject
+
+			result, err := cfg.rateLimiter.Allow(r.Context(), key, rule.limit)
+			if err != nil {
+				// fail open, a broken limiter store shouldn't take the API down
+				slog.ErrorContext(r.Context(), "couldn't check rate limit", "key", key, "error", err)
+				continue
+			}
+			if tightest == nil || moreRestrictive(result, *tightest) {
+				tightest = &result
+			}
+		}
+		if tightest == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.Reset)))
+		if !tightest.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+			respondWithError(w, http.StatusTooManyRequests, "Too many requests", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitSubject returns the value a rule's bucket is keyed by. Requests
+// without a valid JWT have no subject for user-scoped rules.
+func (cfg *apiConfig) rateLimitSubject(r *http.Request, scope string) (string, bool) {
+	switch scope {
+	case "ip":
+		return cfg.clientIP(r), true
+	case "user":
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return "", false
+		}
+		userID, err := auth.ValidateJWT(token, cfg.keyring)
+		if err != nil {
+			return "", false
+		}
+		return userID.String(), true
+	default:
+		return "", true
+	}
+}
+
+// clientIP returns the address of the client. Behind trusted proxies it's
+// the entry the first of them added to X-Forwarded-For, counting from the
+// right, since anything further left came from the client and can be forged.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	if cfg.trustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if len(hops) > 0 {
+			return hops[max(len(hops)-cfg.trustedProxies, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// moreRestrictive reports whether a should be reported to the client instead of b
+func moreRestrictive(a, b ratelimit.Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,91 @@
+package main
+
+import "net/http"
+
+// routes registers every endpoint and wraps the mux in the middleware each
+// request goes through
+func (cfg *apiConfig) routes() http.Handler {
+	mux := http.NewServeMux()
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(cfg.filepathRoot)))
+	mux.Handle("/app/", appHandler)
+
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(cfg.assetsRoot)))
+	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.HandleFunc("GET /metrics", cfg.handlerMetrics)
+
+	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
+	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+
+	mux.HandleFunc("GET /api/oidc/providers", cfg.handlerOIDCProviders)
+	mux.HandleFunc("GET /api/oidc/{provider}/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", cfg.handlerOIDCCallback)
+
+	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/users/verify", cfg.handlerUsersVerify)
+	mux.HandleFunc("POST /api/users/verify/resend", cfg.handlerUsersResendVerification)
+	mux.HandleFunc("GET /api/users/me", cfg.handlerUsersMeGet)
+	mux.HandleFunc("PATCH /api/users/me", cfg.handlerUsersMeUpdate)
+	mux.HandleFunc("POST /api/users/me/password", cfg.handlerUsersMeChangePassword)
+	mux.HandleFunc("DELETE /api/users/me", cfg.handlerUsersMeDelete)
+
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerPasswordReset)
+
+	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-url", cfg.handlerVideoUploadURL)
+	mux.HandleFunc("POST /api/videos/{videoID}/upload-complete", cfg.handlerVideoUploadComplete)
+	mux.HandleFunc("PUT "+stagedUploadPath+"/{key...}", cfg.handlerStagedUpload)
+	mux.HandleFunc("GET /api/videos/{videoID}/progress", cfg.handlerVideoProgress)
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", cfg.handlerCaptionsRetrieve)
+	mux.HandleFunc("PUT /api/videos/{videoID}/captions/{language}", cfg.handlerCaptionUpload)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/captions/{language}", cfg.handlerCaptionDelete)
+	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("GET /api/categories", cfg.handlerCategoriesGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	mux.HandleFunc("GET /api/videos/{videoID}/analytics", cfg.handlerVideoAnalytics)
+
+	mux.HandleFunc("PUT /api/videos/{videoID}/like", cfg.handlerVideoLike)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/like", cfg.handlerVideoUnlike)
+	mux.HandleFunc("GET /api/videos/{videoID}/comments", cfg.handlerCommentsRetrieve)
+	mux.HandleFunc("POST /api/videos/{videoID}/comments", cfg.handlerCommentCreate)
+	mux.HandleFunc("PATCH /api/comments/{commentID}", cfg.handlerCommentUpdate)
+	mux.HandleFunc("DELETE /api/comments/{commentID}", cfg.handlerCommentDelete)
+
+	mux.HandleFunc("POST /api/videos/{videoID}/share_links", cfg.handlerShareLinkCreate)
+	mux.HandleFunc("GET /api/videos/{videoID}/share_links", cfg.handlerShareLinksRetrieve)
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share_links/{linkID}", cfg.handlerShareLinkRevoke)
+	mux.HandleFunc("POST /api/share/{token}", cfg.handlerShareLinkPlay)
+
+	mux.HandleFunc("GET /api/public/videos", cfg.handlerPublicVideosRetrieve)
+	mux.HandleFunc("GET /api/public/videos/{videoID}", cfg.handlerPublicVideoGet)
+
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsRetrieve)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistAddVideo)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistRemoveVideo)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/order", cfg.handlerPlaylistReorder)
+
+	mux.HandleFunc("POST /api/webhooks", cfg.handlerWebhookCreate)
+	mux.HandleFunc("GET /api/webhooks", cfg.handlerWebhooksRetrieve)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.handlerWebhookDelete)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.handlerWebhookDeliveriesRetrieve)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", cfg.handlerWebhookRedeliver)
+
+	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+
+	return cfg.trackRequests(cfg.tracingMiddleware(mux, cfg.requestLogMiddleware(cfg.metricsMiddleware(mux, cfg.rateLimitMiddleware(mux, mux)))))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	link, err := cfg.createUserTokenLink(ctx, user, database.UserTokenEmailVerification, emailVerificationTTL, "verify_token")
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body:    fmt.Sprintf("Welcome to Tubely! Confirm your email address by opening this link:\n\n%s\n\nThe link expires in 24 hours.", link),
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	link, err := cfg.createUserTokenLink(ctx, user, database.UserTokenPasswordReset, passwordResetTTL, "reset_token")
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body:    fmt.Sprintf("Someone asked to reset your Tubely password. If it was you, open this link:\n\n%s\n\nThe link expires in 1 hour. If you didn't ask for this you can ignore this email.", link),
+	})
+}
+
+// createUserTokenLink stores the hash of a new single-use token, replacing any
+// outstanding token for the same purpose, and returns the web app link for it
+func (cfg *apiConfig) createUserTokenLink(ctx context.Context, user database.User, purpose database.UserTokenPurpose, ttl time.Duration, param string) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", fmt.Errorf("couldn't create token: %w", err)
+	}
+
+	db := cfg.db.WithContext(ctx)
+	err = db.DeleteUserTokens(user.ID, purpose)
+	if err != nil {
+		return "", fmt.Errorf("couldn't clear old tokens: %w", err)
+	}
+
+	err = db.CreateUserToken(database.CreateUserTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't save token: %w", err)
+	}
+
+	return fmt.Sprintf("%s/app/?%s=%s", cfg.baseURL, param, url.QueryEscape(token)), nil
+}
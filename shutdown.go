@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shutdownGrace is how long cancelled requests and background work get to
+// stop before the workers and database they use are closed
+const shutdownGrace = 5 * time.Second
+
+// trackRequests counts the handlers still running, which http.Server.Close
+// doesn't wait for
+func (cfg *apiConfig) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.inFlight.Add(1)
+		defer cfg.inFlight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitFor waits for wg until timeout, reporting whether it finished
+func waitFor(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackRequestsWaitsForRunningHandlers(t *testing.T) {
+	cfg := &apiConfig{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := cfg.trackRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	if waitFor(&cfg.inFlight, 10*time.Millisecond) {
+		t.Fatal("waitFor returned before the handler finished")
+	}
+	close(release)
+	if !waitFor(&cfg.inFlight, time.Second) {
+		t.Fatal("waitFor timed out after the handler finished")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	installSpanRecorder sync.Once
+	spanRecorder        *tracetest.SpanRecorder
+)
+
+// recordSpans installs a global tracer provider that records every span.
+// Tracers only pick up the first provider installed, so tests share one and
+// pick out their own spans by trace ID.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	installSpanRecorder.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spanRecorder
+}
+
+func spansInTrace(recorder *tracetest.SpanRecorder, traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+func TestTracingMiddlewareContinuesIncomingTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	cfg, mux := newPlaylistTestConfig(t)
+	_, token, _ := createTestUser(t, cfg, mux, "tracing@example.com", "password123")
+	handler := cfg.tracingMiddleware(mux, mux)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/playlists/"+uuid.NewString(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	id, _ := trace.TraceIDFromHex(traceID)
+	spans := spansInTrace(recorder, id)
+	span, ok := spans["GET /api/playlists/{playlistID}"]
+	if !ok {
+		t.Fatal("expected a span named after the route in the caller's trace")
+	}
+	// handlers run their statements with the request's context
+	if query, ok := spans["Client.GetPlaylist"]; !ok || query.Parent().SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("expected the handler's query to be traced under the request, got %v", spans)
+	}
+	if span.Parent().SpanID().String() != "00f067aa0ba902b7" || !span.Parent().IsRemote() {
+		t.Fatalf("expected the span's parent to be the caller's span, got %v", span.Parent())
+	}
+	for _, attr := range span.Attributes() {
+		if attr.Key == "http.response.status_code" && attr.Value.AsInt64() != http.StatusNotFound {
+			t.Fatalf("expected the status to be recorded, got %v", attr.Value.AsInt64())
+		}
+	}
+}
+
+func TestDatabaseStatementsAreTraced(t *testing.T) {
+	recorder := recordSpans(t)
+	db := newTestDB(t)
+	user, err := db.CreateUser(database.CreateUserParams{Email: "spans@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	video, err := db.WithContext(ctx).CreateVideo(database.CreateVideoParams{Title: "Traced", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// statements in a transaction are traced too
+	video.Title = "Still traced"
+	if _, err := db.WithContext(ctx).UpdateVideoMetadata(video, video.UpdatedAt); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := spansInTrace(recorder, parent.SpanContext().TraceID())
+	for _, name := range []string{"Client.CreateVideo", "Client.UpdateVideoMetadata", "setVideoTags"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("expected a %s span, got %v", name, spans)
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of the request", name)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// runVerifyCommand handles "tubely verify [flags]", which reads back every
+// stored video file and checks it against the hash recorded when it was
+// stored. It fails if any are missing or corrupted.
+func runVerifyCommand(args []string) int {
+	conf, err := config.Load("tubely verify", args, os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := database.NewClient(conf.Database.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't connect to database:", err)
+		return 1
+	}
+	defer db.Close()
+	store, _, _, err := newVideoStore(ctx, conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't configure video store:", err)
+		return 1
+	}
+
+	objects, err := db.ListStoredObjects()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "couldn't list stored videos:", err)
+		return 1
+	}
+	problems := verifyStoredObjects(ctx, store, objects, os.Stdout)
+	fmt.Printf("checked %d stored videos, %d problems\n", len(objects), problems)
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
+
+// verifyStoredObjects reports each object that's missing from the store or
+// doesn't match its recorded hash and size to w, returning how many there were
+func verifyStoredObjects(ctx context.Context, store storage.Store, objects []database.StoredObject, w io.Writer) int {
+	problems := 0
+	for _, object := range objects {
+		sum, size, err := hashStoredObject(ctx, store, object.Key)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			fmt.Fprintf(w, "missing %s\n", object.Key)
+		case err != nil:
+			fmt.Fprintf(w, "unreadable %s: %v\n", object.Key, err)
+		case sum != object.SHA256 || size != object.Size:
+			fmt.Fprintf(w, "corrupted %s: sha256 %s (%d bytes), want %s (%d bytes)\n", object.Key, sum, size, object.SHA256, object.Size)
+		default:
+			continue
+		}
+		problems++
+	}
+	return problems
+}
+
+func hashStoredObject(ctx context.Context, store storage.Store, key string) (string, int64, error) {
+	body, err := storage.Open(ctx, store, key)
+	if err != nil {
+		return "", 0, err
+	}
+	defer body.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, body)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestVerifyStoredObjects(t *testing.T) {
+	store := storage.LocalStore{Root: t.TempDir(), BaseURL: "http://cdn.test"}
+	ctx := context.Background()
+
+	var objects []database.StoredObject
+	for _, key := range []string{"landscape/good.mp4", "landscape/corrupt.mp4", "portrait/missing.mp4"} {
+		if err := store.Put(ctx, key, strings.NewReader("contents of "+key), "video/mp4"); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(store.Root, filepath.FromSlash(key))
+		sum, size, err := hashFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, database.StoredObject{Key: key, SHA256: sum, Size: size})
+	}
+	if err := os.WriteFile(filepath.Join(store.Root, "landscape", "corrupt.mp4"), []byte("contents of landscape/corrupt.mp5"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "portrait/missing.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if problems := verifyStoredObjects(ctx, store, objects, &out); problems != 2 {
+		t.Errorf("problems = %d, want 2:\n%s", problems, out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "corrupted landscape/corrupt.mp4: ") || lines[1] != "missing portrait/missing.mp4" {
+		t.Errorf("unexpected report:\n%s", out.String())
+	}
+}
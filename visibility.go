@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func validVisibility(visibility database.Visibility) bool {
+	switch visibility {
+	case database.VisibilityPrivate, database.VisibilityUnlisted, database.VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// videoVisibleTo reports whether a viewer may see a video. Anonymous viewers
+// are uuid.Nil. Owners see everything; everyone else only sees published
+// videos, and unlisted ones only by ID.
+func videoVisibleTo(video database.Video, viewerID uuid.UUID) bool {
+	if viewerID != uuid.Nil && video.UserID == viewerID {
+		return true
+	}
+	return videoPubliclyVisible(video)
+}
+
+// videoPubliclyVisible reports whether anyone with the ID may see a video
+func videoPubliclyVisible(video database.Video) bool {
+	if !video.Published() {
+		return false
+	}
+	return video.Visibility == database.VisibilityUnlisted || video.Visibility == database.VisibilityPublic
+}
+
+// playlistVisibleTo is videoVisibleTo for playlists, which have no draft state
+func playlistVisibleTo(playlist database.Playlist, viewerID uuid.UUID) bool {
+	if viewerID != uuid.Nil && playlist.UserID == viewerID {
+		return true
+	}
+	return playlist.Visibility == database.VisibilityUnlisted || playlist.Visibility == database.VisibilityPublic
+}
+
+// optionalUserID authenticates the request if it carries a token, returning
+// uuid.Nil for anonymous requests. A token that is present but invalid is an error.
+func (cfg *apiConfig) optionalUserID(r *http.Request) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if errors.Is(err, auth.ErrNoAuthHeaderIncluded) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return auth.ValidateJWT(token, cfg.keyring)
+}